@@ -2,16 +2,63 @@ package main
 
 import (
 	"blunder/engine"
+	"blunder/match"
+	"blunder/tuner"
+	"fmt"
+	"os"
 )
 
+const UsageMessage = `Usage: blunder [command] [arguments]
+
+With no command, Blunder starts in its command line mode, from which the UCI protocol can be started.
+
+Commands:
+  bench [depth]                               search the benchmark positions and report the nodes and nps
+  perft -depth <N> [-fen <FEN>] [-divide]     count the leaf nodes of the move generation tree
+  search [-fen <FEN>] -movetime <MS>          search a position and report the best move, also
+                                              accepting -depth <N> and -nodes <N> as limits
+  match -engine <ENGINE> -engine <ENGINE>     play a match between two UCI engines
+  tune -data <FILE>                           tune the evaluation with texel tuning
+  gendata -type <texel|selfplay> -out <FILE>  generate training data for the tuner or NNUE networks
+
+Run "blunder <command> -h" to show the arguments of a command.
+`
+
+// The commands which can be given as command-line arguments, each of which
+// take the remaining arguments and return an exit code.
+var Commands = map[string]func(args []string) int{
+	"bench":   engine.RunBenchCommand,
+	"perft":   engine.RunPerftCommand,
+	"search":  engine.RunSearchCommand,
+	"match":   match.RunCommand,
+	"tune":    tuner.RunTuneCommand,
+	"gendata": tuner.RunGenDataCommand,
+}
+
 func init() {
 	engine.InitBitboards()
 	engine.InitTables()
 	engine.InitZobrist()
+	engine.InitSyzygyTables()
 	engine.InitEvalBitboards()
 	engine.InitSearchTables()
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "help", "-h", "-help", "--help":
+			fmt.Print(UsageMessage)
+			os.Exit(0)
+		}
+
+		command, ok := Commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], UsageMessage)
+			os.Exit(2)
+		}
+		os.Exit(command(os.Args[2:]))
+	}
+
 	engine.RunCommLoop()
 }
@@ -0,0 +1,20 @@
+package engine
+
+import (
+	"io"
+	"testing"
+)
+
+// bench_test.go provides a test to ensure the benchmark searches the same
+// number of nodes each time it's run.
+
+func TestBench(t *testing.T) {
+	nodes := Bench(5, io.Discard)
+	if nodes == 0 {
+		t.Fatal("Expected the benchmark to search some nodes")
+	}
+
+	if repeated := Bench(5, io.Discard); repeated != nodes {
+		t.Errorf("Expected the benchmark to search %d nodes again, got %d", nodes, repeated)
+	}
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// book_file.go implements probing a polyglot book in place, without loading it into
+// memory. Since the entries of a polyglot book are sorted by their hash, the entries
+// of a position can be found with a binary search over the file, which only needs to
+// read a few dozen entries, even for a book with millions of them.
+
+// An opening book probed in place in its file.
+type FileBook struct {
+	file       *os.File
+	numEntries int
+
+	// The learn fields updated since the book was last saved,
+	// by the index of their entry in the file.
+	learned map[int]uint32
+}
+
+// Open the polyglot book at the given path to be probed in place. The book is opened
+// for writing if possible, so its learned results can be saved.
+func OpenFileBook(path string) (*FileBook, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size()%EntryByteLength != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a polyglot book, its size isn't a multiple of %d bytes", path, EntryByteLength)
+	}
+
+	return &FileBook{
+		file:       file,
+		numEntries: int(info.Size() / EntryByteLength),
+		learned:    make(map[int]uint32),
+	}, nil
+}
+
+// Read the entry at the given index in the file.
+func (book *FileBook) readEntry(index int) (PolyglotEntry, error) {
+	var entryBytes [EntryByteLength]byte
+	if _, err := book.file.ReadAt(entryBytes[:], int64(index)*EntryByteLength); err != nil {
+		return PolyglotEntry{}, err
+	}
+
+	entry := decodePolyglotEntry(entryBytes)
+	if learn, ok := book.learned[index]; ok {
+		entry.Learn = learn
+	}
+	return entry, nil
+}
+
+// Find the index of the first entry with the given hash, or of the first entry with
+// a larger hash if there's none, using a binary search.
+func (book *FileBook) findFirst(hash uint64) int {
+	return sort.Search(book.numEntries, func(index int) bool {
+		var hashBytes [8]byte
+		if _, err := book.file.ReadAt(hashBytes[:], int64(index)*EntryByteLength); err != nil {
+			// Treat an entry which can't be read as being past the end of
+			// the book, so the search still finishes.
+			return true
+		}
+		return binary.BigEndian.Uint64(hashBytes[:]) >= hash
+	})
+}
+
+func (book *FileBook) Probe(hash uint64) (entries []PolyglotEntry) {
+	for index := book.findFirst(hash); index < book.numEntries; index++ {
+		entry, err := book.readEntry(index)
+		if err != nil || entry.Hash != hash {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (book *FileBook) AddResult(entry PolyglotEntry, halfPoints uint32) {
+	for index := book.findFirst(entry.Hash); index < book.numEntries; index++ {
+		bookEntry, err := book.readEntry(index)
+		if err != nil || bookEntry.Hash != entry.Hash {
+			break
+		}
+
+		if bookEntry.Move == entry.Move {
+			bookEntry.AddResult(halfPoints)
+			book.learned[index] = bookEntry.Learn
+		}
+	}
+}
+
+// Save the book by writing the updated learn fields in place, since the
+// order of the entries in the file doesn't change.
+func (book *FileBook) Save() error {
+	for index, learn := range book.learned {
+		var learnBytes [4]byte
+		binary.BigEndian.PutUint32(learnBytes[:], learn)
+
+		if _, err := book.file.WriteAt(learnBytes[:], int64(index)*EntryByteLength+12); err != nil {
+			return err
+		}
+		delete(book.learned, index)
+	}
+	return nil
+}
+
+func (book *FileBook) Close() error {
+	return book.file.Close()
+}
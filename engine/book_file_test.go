@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// book_file_test.go provides tests to ensure probing a polyglot book in place
+// gives the same entries as loading the book into memory.
+
+// Write a book with random entries, where many positions have several entries.
+func writeRandomBook(t *testing.T, path string, numPositions int) {
+	random := rand.New(rand.NewSource(1))
+	moves := []string{"e2e4", "d2d4", "g1f3", "c2c4", "e1h1", "a7a8q"}
+	entries := []PolyglotEntry{}
+
+	for i := 0; i < numPositions; i++ {
+		hash := random.Uint64()
+		for _, move := range moves[:1+random.Intn(len(moves))] {
+			entries = append(entries, PolyglotEntry{Hash: hash, Move: move, Weight: uint16(random.Intn(100))})
+		}
+	}
+
+	if err := WritePolyglotFile(path, entries); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.bin")
+	writeRandomBook(t, path, 1000)
+
+	mapBook, err := LoadMapBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileBook, err := OpenFileBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileBook.Close()
+
+	hashes := []uint64{0, 1<<64 - 1}
+	for hash := range mapBook.Entries {
+		hashes = append(hashes, hash, hash+1)
+	}
+
+	for _, hash := range hashes {
+		if !reflect.DeepEqual(fileBook.Probe(hash), mapBook.Probe(hash)) {
+			t.Fatalf(
+				"Probing the book in place for hash 0x%x gave %v, expected %v",
+				hash, fileBook.Probe(hash), mapBook.Probe(hash),
+			)
+		}
+	}
+
+	// Learned results should be saved to the file in place, without changing the
+	// order of the entries.
+	learned := mapBook.Probe(hashes[2])
+
+	before, _ := os.ReadFile(path)
+	fileBook.AddResult(learned[0], 2)
+	fileBook.AddResult(learned[0], 0)
+
+	if entries := fileBook.Probe(learned[0].Hash); entries[0].Learn != LearnMarker|2<<16|2 {
+		t.Errorf("Expected the learned results to be probed before saving, got %+v", entries[0])
+	}
+
+	if err := fileBook.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := os.ReadFile(path)
+	changed := 0
+	for index := range before {
+		if before[index] != after[index] {
+			changed++
+		}
+	}
+
+	// The learn field was empty, so only its marker, games, and half-points bytes change.
+	reloaded, _ := LoadPolyglotFile(path)
+	if reloaded[learned[0].Hash][0].Learn != LearnMarker|2<<16|2 || changed != 3 {
+		t.Errorf("Expected only the learn field of %+v to be saved, but %d bytes changed", learned[0], changed)
+	}
+
+	// A file which isn't made of whole entries should be rejected.
+	os.WriteFile(path, before[:len(before)-1], 0644)
+	if _, err := OpenFileBook(path); err == nil {
+		t.Errorf("Opening a book which isn't made of whole entries should fail")
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"sort"
+	"strings"
 )
 
-// book.go is an implementation of a polyglot opening book prober for Blunder,
-// as well as a polyglot hash generator.
+// book.go is an implementation of a polyglot opening book prober and writer for
+// Blunder, as well as a polyglot hash generator.
 
 const (
 	// The size of a polyglot entry
@@ -39,6 +43,27 @@ const (
 	fileCharacters = "abcdefgh"
 	rankCharacters = "12345678"
 
+	// The ways a move can be selected from the entries of a position:
+	// picking any entry with the same chance, picking an entry with a chance
+	// proportional to its weight, or always picking the entry with the highest
+	// weight.
+	BookSelectUniform  = "uniform"
+	BookSelectWeighted = "weighted"
+	BookSelectBest     = "best"
+
+	// The size in bytes of the largest polyglot book loaded into memory. Larger
+	// books are probed in place, by binary searching the file.
+	MaxMapBookSize = 64 * 1024 * 1024
+
+	// The largest number of games the learn field of an entry can count,
+	// after which the games and score counted are halved, so newer games
+	// count more than older ones.
+	MaxLearnedGames = 0x7fff
+
+	// The bit of the learn field Blunder sets on the entries it learns from,
+	// so learn fields written by other programs can be told apart and ignored.
+	LearnMarker uint32 = 1 << 31
+
 	// Hardcoded indexes into the array of random 64-bit numbers used
 	// to create a polyglot hash.
 	CastleWKSHash = 768
@@ -52,14 +77,127 @@ const (
 // Each polyglot book is composed of a series of 16-byte entries. Each
 // of these entries contains a key, which is the hash of the position
 // after the current moves have been made, the moves made, the weight
-// those moves are given (i.e. how good they are), and a learn field,
-// which, as far as I can tell, is usually ignored and set to zero
-// by polyglot book generators, so it's not included here. The key
-// element of the entry is the mapping key to a PolyglotEntry.
+// those moves are given (i.e. how good they are), and a learn field.
+// The polyglot format doesn't say what the learn field means, so Blunder
+// uses it to count how many games it played the entry in, in bits 16-30,
+// and how many half-points it scored in them, in bits 0-15, with bit 31
+// (LearnMarker) set. Other programs use the learn field differently, so a
+// learn field without the marker, or with more half-points than two for
+// each game, is treated as if nothing was learned, and is overwritten the
+// first time a result is learned for the entry.
+// The key element of the entry is the mapping key to a PolyglotEntry.
 type PolyglotEntry struct {
 	Hash   uint64
 	Move   string
 	Weight uint16
+	Learn  uint32
+}
+
+// Get the number of games the entry was played in, and the number of
+// half-points scored in those games, from its learn field, or zero for
+// both if the learn field wasn't written by Blunder.
+func (entry PolyglotEntry) LearnedResults() (games, halfPoints uint32) {
+	games, halfPoints = (entry.Learn&^LearnMarker)>>16, entry.Learn&0xffff
+	if entry.Learn&LearnMarker == 0 || halfPoints > 2*games {
+		return 0, 0
+	}
+	return games, halfPoints
+}
+
+// Add the result of a game the entry was played in to its learn field,
+// given as the number of half-points scored, from zero for a loss to two
+// for a win.
+func (entry *PolyglotEntry) AddResult(halfPoints uint32) {
+	games, score := entry.LearnedResults()
+	if games == MaxLearnedGames {
+		games /= 2
+		score /= 2
+	}
+	entry.Learn = LearnMarker | (games+1)<<16 | Min(score+halfPoints, 2*(games+1))
+}
+
+// Get the weight of the entry adjusted by its learned results. An entry
+// which scored well gets up to twice its weight, and an entry which keeps
+// losing gets less and less of its weight.
+func (entry PolyglotEntry) LearnedWeight() uint16 {
+	games, halfPoints := entry.LearnedResults()
+
+	// Start every entry with half a point from a single
+	// game, so a single loss doesn't remove an entry.
+	score := float64(halfPoints+1) / float64(2*games+2)
+	return uint16(math.Min(float64(entry.Weight)*2*score, math.MaxUint16))
+}
+
+// An opening book, which can be probed for the entries of a position, and which
+// can learn from the results of the games its entries were played in.
+type OpeningBook interface {
+	// Get the entries of the position with the given polyglot hash.
+	Probe(hash uint64) []PolyglotEntry
+
+	// Add the result of a game to the learn field of the given entry.
+	AddResult(entry PolyglotEntry, halfPoints uint32)
+
+	// Save the learned results of the book back to its file.
+	Save() error
+
+	// Close the book, releasing any resources it holds.
+	Close() error
+}
+
+// Open the polyglot book at the given path. Books larger than MaxMapBookSize
+// are probed in place, and smaller books are loaded into memory.
+func OpenPolyglotBook(path string) (OpeningBook, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() > MaxMapBookSize {
+		return OpenFileBook(path)
+	}
+	return LoadMapBook(path)
+}
+
+// An opening book loaded into memory, where each polyglot hash
+// maps to the entries of the position.
+type MapBook struct {
+	Path    string
+	Entries map[uint64][]PolyglotEntry
+}
+
+// Load the polyglot book at the given path into memory.
+func LoadMapBook(path string) (*MapBook, error) {
+	entries, err := LoadPolyglotFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MapBook{Path: path, Entries: entries}, nil
+}
+
+func (book *MapBook) Probe(hash uint64) []PolyglotEntry {
+	return book.Entries[hash]
+}
+
+func (book *MapBook) AddResult(entry PolyglotEntry, halfPoints uint32) {
+	entries := book.Entries[entry.Hash]
+	for index := range entries {
+		if entries[index].Move == entry.Move {
+			entries[index].AddResult(halfPoints)
+		}
+	}
+}
+
+// Save the book by writing every entry back to its file.
+func (book *MapBook) Save() error {
+	entries := []PolyglotEntry{}
+	for _, positionEntries := range book.Entries {
+		entries = append(entries, positionEntries...)
+	}
+	return WritePolyglotFile(book.Path, entries)
+}
+
+func (book *MapBook) Close() error {
+	return nil
 }
 
 // Parse a polyglot file and create a map of PolyglotEntry's
@@ -82,54 +220,183 @@ func LoadPolyglotFile(path string) (map[uint64][]PolyglotEntry, error) {
 			return nil, err
 		}
 
-		var entry PolyglotEntry
-
-		// Load polyglot hash
-		bytesBuffer := bytes.NewBuffer(entryBytes[0:8])
-		binary.Read(bytesBuffer, binary.BigEndian, &entry.Hash)
-
-		// Load the move
-		var move uint16
-		bytesBuffer.Reset()
-		bytesBuffer.Write(entryBytes[8:10])
-		binary.Read(bytesBuffer, binary.BigEndian, &move)
-
-		toFile := fileCharacters[move&ToFileMask]
-		toRank := rankCharacters[(move&ToRankMask)>>ToRankShift]
-		fromFile := fileCharacters[(move&FromFileMask)>>FromFileShift]
-		fromRank := rankCharacters[(move&FromRankMask)>>FromRankShift]
-		promotionPiece := (move & PromotionPieceMask) >> PromotionPieceShift
-
-		promotionCharacter := ""
-		switch promotionPiece {
-		case 1:
-			promotionCharacter = "n"
-		case 2:
-			promotionCharacter = "b"
-		case 3:
-			promotionCharacter = "r"
-		case 4:
-			promotionCharacter = "q"
+		entry := decodePolyglotEntry(entryBytes)
+		entries[entry.Hash] = append(entries[entry.Hash], entry)
+	}
+
+	return entries, nil
+}
+
+// Decode the bytes of a polyglot entry.
+func decodePolyglotEntry(entryBytes [EntryByteLength]byte) PolyglotEntry {
+	var entry PolyglotEntry
+
+	// Load polyglot hash
+	bytesBuffer := bytes.NewBuffer(entryBytes[0:8])
+	binary.Read(bytesBuffer, binary.BigEndian, &entry.Hash)
+
+	// Load the move
+	var move uint16
+	bytesBuffer.Reset()
+	bytesBuffer.Write(entryBytes[8:10])
+	binary.Read(bytesBuffer, binary.BigEndian, &move)
+
+	toFile := fileCharacters[move&ToFileMask]
+	toRank := rankCharacters[(move&ToRankMask)>>ToRankShift]
+	fromFile := fileCharacters[(move&FromFileMask)>>FromFileShift]
+	fromRank := rankCharacters[(move&FromRankMask)>>FromRankShift]
+	promotionPiece := (move & PromotionPieceMask) >> PromotionPieceShift
+
+	promotionCharacter := ""
+	switch promotionPiece {
+	case 1:
+		promotionCharacter = "n"
+	case 2:
+		promotionCharacter = "b"
+	case 3:
+		promotionCharacter = "r"
+	case 4:
+		promotionCharacter = "q"
+	}
+
+	entry.Move = fmt.Sprintf("%c%c%c%c%v", fromFile, fromRank, toFile, toRank, promotionCharacter)
+
+	// Load the weight
+	var weight uint16
+	bytesBuffer.Reset()
+	bytesBuffer.Write(entryBytes[10:12])
+	binary.Read(bytesBuffer, binary.BigEndian, &weight)
+	entry.Weight = weight
+
+	// Load the learn data
+	var learn uint32
+	bytesBuffer.Reset()
+	bytesBuffer.Write(entryBytes[12:16])
+	binary.Read(bytesBuffer, binary.BigEndian, &learn)
+	entry.Learn = learn
+	return entry
+}
+
+// Select an entry from the entries of a position using the given selection policy,
+// ignoring any entries with a weight below the minimum weight. If learning is used,
+// the weights are adjusted by the learned results of the entries. False is returned
+// if there are no entries to select from.
+func SelectBookEntry(entries []PolyglotEntry, selection string, minWeight uint16, learning bool) (PolyglotEntry, bool) {
+	candidates := []PolyglotEntry{}
+	weights := []int{}
+	totalWeight := 0
+
+	for _, entry := range entries {
+		weight := entry.Weight
+		if learning {
+			weight = entry.LearnedWeight()
 		}
 
-		entry.Move = fmt.Sprintf("%c%c%c%c%v", fromFile, fromRank, toFile, toRank, promotionCharacter)
+		if weight >= minWeight {
+			candidates = append(candidates, entry)
+			weights = append(weights, int(weight))
+			totalWeight += int(weight)
+		}
+	}
 
-		// Load the weight
-		var weight uint16
-		bytesBuffer.Reset()
-		bytesBuffer.Write(entryBytes[10:12])
-		binary.Read(bytesBuffer, binary.BigEndian, &weight)
-		entry.Weight = weight
+	if len(candidates) == 0 {
+		return PolyglotEntry{}, false
+	}
 
-		// Load the learn data, and discard it
-		var learn uint32
-		bytesBuffer.Reset()
-		bytesBuffer.Write(entryBytes[12:16])
-		binary.Read(bytesBuffer, binary.BigEndian, &learn)
-		entries[entry.Hash] = append(entries[entry.Hash], entry)
+	switch selection {
+	case BookSelectWeighted:
+		// If every entry has no weight, fall back to a uniform selection.
+		if totalWeight == 0 {
+			break
+		}
+
+		choice := rand.Intn(totalWeight)
+		for index, entry := range candidates {
+			if choice < weights[index] {
+				return entry, true
+			}
+			choice -= weights[index]
+		}
+	case BookSelectBest:
+		best := 0
+		for index := range candidates {
+			if weights[index] > weights[best] {
+				best = index
+			}
+		}
+		return candidates[best], true
 	}
 
-	return entries, nil
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// Get a move in the notation used by polyglot books, which is the same as UCI
+// notation, except castling moves are given as the king capturing its own rook.
+func PolyglotNotation(move Move) string {
+	notation := posToCoordinate(move.FromSq()) + posToCoordinate(move.ToSq())
+	if move.MoveType() == Promotion {
+		notation += string("nbrq"[move.Flag()])
+	}
+	return notation
+}
+
+// Encode a move given in polyglot notation into the move part of a polyglot entry.
+func encodePolyglotMove(move string) uint16 {
+	toFile := uint16(strings.IndexByte(fileCharacters, move[2]))
+	toRank := uint16(strings.IndexByte(rankCharacters, move[3]))
+	fromFile := uint16(strings.IndexByte(fileCharacters, move[0]))
+	fromRank := uint16(strings.IndexByte(rankCharacters, move[1]))
+
+	promotionPiece := uint16(0)
+	if len(move) == 5 {
+		promotionPiece = uint16(strings.IndexByte("nbrq", move[4]) + 1)
+	}
+
+	return toFile | toRank<<ToRankShift | fromFile<<FromFileShift |
+		fromRank<<FromRankShift | promotionPiece<<PromotionPieceShift
+}
+
+// Write the given entries to a polyglot file. Polyglot books are probed using a
+// binary search, so the entries are sorted by their hash, and entries for the same
+// position are sorted from the highest to the lowest weight.
+func WritePolyglotFile(path string, entries []PolyglotEntry) error {
+	sorted := make([]PolyglotEntry, len(entries))
+	copy(sorted, entries)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Hash != sorted[j].Hash {
+			return sorted[i].Hash < sorted[j].Hash
+		}
+		if sorted[i].Weight != sorted[j].Weight {
+			return sorted[i].Weight > sorted[j].Weight
+		}
+		return sorted[i].Move < sorted[j].Move
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, entry := range sorted {
+		var entryBytes [EntryByteLength]byte
+		binary.BigEndian.PutUint64(entryBytes[0:8], entry.Hash)
+		binary.BigEndian.PutUint16(entryBytes[8:10], encodePolyglotMove(entry.Move))
+		binary.BigEndian.PutUint16(entryBytes[10:12], entry.Weight)
+		binary.BigEndian.PutUint32(entryBytes[12:16], entry.Learn)
+
+		if _, err := writer.Write(entryBytes[:]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 // Create an initial zobrist hash for a board loaded from a
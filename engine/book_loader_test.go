@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"path/filepath"
 	"testing"
 )
 
@@ -8,6 +9,8 @@ import (
 func init() {
 	InitBitboards()
 	InitTables()
+	InitZobrist()
+	InitSyzygyTables()
 }
 
 // polyglot_test.go provides tests to ensure polyglot hashing it working correctly.
@@ -43,3 +46,143 @@ func TestPolyglotHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectBookEntry(t *testing.T) {
+	entries := []PolyglotEntry{
+		{Move: "e2e4", Weight: 30},
+		{Move: "d2d4", Weight: 10},
+		{Move: "c2c4", Weight: 2},
+		{Move: "g2g4", Weight: 0},
+	}
+
+	if entry, _ := SelectBookEntry(entries, BookSelectBest, 0, false); entry.Move != "e2e4" {
+		t.Errorf("Expected the best entry to be e2e4, got %s", entry.Move)
+	}
+
+	if _, ok := SelectBookEntry(entries, BookSelectUniform, 31, false); ok {
+		t.Errorf("No entry should be selected when every entry is below the minimum weight")
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		entry, _ := SelectBookEntry(entries, BookSelectWeighted, 0, false)
+		counts[entry.Move]++
+	}
+
+	if counts["g2g4"] != 0 || counts["e2e4"] < counts["d2d4"] || counts["d2d4"] < counts["c2c4"] {
+		t.Errorf("Weighted selection didn't follow the weights of the entries: %v", counts)
+	}
+
+	counts = map[string]int{}
+	for i := 0; i < 4000; i++ {
+		entry, _ := SelectBookEntry(entries, BookSelectUniform, 2, false)
+		counts[entry.Move]++
+	}
+
+	if len(counts) != 3 || counts["g2g4"] != 0 {
+		t.Errorf("Uniform selection should pick every entry above the minimum weight: %v", counts)
+	}
+}
+
+func TestBookLearning(t *testing.T) {
+	entry := PolyglotEntry{Move: "e2e4", Weight: 100}
+	if entry.LearnedWeight() != 100 {
+		t.Errorf("An entry with nothing learned should keep its weight, got %d", entry.LearnedWeight())
+	}
+
+	// An entry which keeps losing should be downweighted more and more.
+	previousWeight := entry.LearnedWeight()
+	for i := 0; i < 3; i++ {
+		entry.AddResult(0)
+		if entry.LearnedWeight() >= previousWeight {
+			t.Errorf("Expected a lost game to lower the weight below %d, got %d", previousWeight, entry.LearnedWeight())
+		}
+		previousWeight = entry.LearnedWeight()
+	}
+
+	if games, halfPoints := entry.LearnedResults(); games != 3 || halfPoints != 0 {
+		t.Errorf("Expected 3 games and 0 half-points to be learned, got %d and %d", games, halfPoints)
+	}
+
+	// A learn field written by another program should be ignored, and replaced
+	// once a result is learned.
+	foreign := PolyglotEntry{Move: "e2e4", Weight: 100, Learn: 3<<16 | 1}
+	if games, _ := foreign.LearnedResults(); games != 0 || foreign.LearnedWeight() != 100 {
+		t.Errorf("Expected a learn field without the marker to be ignored, got %d games", games)
+	}
+
+	foreign.Learn = LearnMarker | 1<<16 | 5
+	foreign.AddResult(2)
+	if games, halfPoints := foreign.LearnedResults(); games != 1 || halfPoints != 2 {
+		t.Errorf("Expected an invalid learn field to be replaced, got %d games and %d half-points", games, halfPoints)
+	}
+
+	// Once the most games are counted, the learned results should be halved.
+	entry.Learn = LearnMarker | MaxLearnedGames<<16 | 1000
+	entry.AddResult(2)
+	if games, halfPoints := entry.LearnedResults(); games != MaxLearnedGames/2+1 || halfPoints != 502 {
+		t.Errorf("Expected the learned results to be aged, got %d games and %d half-points", games, halfPoints)
+	}
+
+	// Losing the game after playing a book move should be saved to the book file.
+	pos := Position{}
+	pos.LoadFEN(FENStartPosition)
+	hash := GenPolyglotHash(&pos)
+
+	inter := UCIInterface{}
+	inter.OptionBookLearning = true
+	inter.OptionBookPath = filepath.Join(t.TempDir(), "book.bin")
+	inter.OpeningBook = &MapBook{
+		Path: inter.OptionBookPath,
+		Entries: map[uint64][]PolyglotEntry{
+			hash: {{Hash: hash, Move: "e2e4", Weight: 10}, {Hash: hash, Move: "d2d4", Weight: 10}},
+		},
+	}
+	inter.bookMovesPlayed = []PolyglotEntry{{Hash: hash, Move: "e2e4"}}
+	inter.lastScore, inter.searched = -500, true
+	inter.learnFromGame()
+
+	book, err := LoadPolyglotFile(inter.OptionBookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range book[hash] {
+		games, _ := entry.LearnedResults()
+		if (entry.Move == "e2e4") != (games == 1) || entry.Weight != 10 {
+			t.Errorf("The learned results of entry %+v weren't saved correctly", entry)
+		}
+	}
+}
+
+func TestBookLearningConcurrentGame(t *testing.T) {
+	inter := UCIInterface{}
+	inter.Search.Setup(FENStartPosition)
+	hash := GenPolyglotHash(&inter.Search.Pos)
+
+	inter.OptionUseBook = true
+	inter.OptionBookDepth = DefaultBookDepth
+	inter.OptionBookLearning = true
+	inter.OptionBookPath = filepath.Join(t.TempDir(), "book.bin")
+	inter.OpeningBook = &MapBook{
+		Path:    inter.OptionBookPath,
+		Entries: map[uint64][]PolyglotEntry{hash: {{Hash: hash, Move: "e2e4", Weight: 10}}},
+	}
+
+	// The book move is recorded by the goroutine running the go command, while
+	// the UCI loop may start a new game at the same time.
+	done := make(chan struct{})
+	go func() {
+		inter.goCommandResponse("go")
+		close(done)
+	}()
+
+	inter.learnFromGame()
+	<-done
+
+	inter.learnMutex.Lock()
+	defer inter.learnMutex.Unlock()
+	if len(inter.bookMovesPlayed) > 1 {
+		t.Errorf("Expected at most one book move to be recorded, got %v", inter.bookMovesPlayed)
+	}
+}
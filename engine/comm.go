@@ -33,6 +33,12 @@ Commands:
 - fen <FEN>: Load a fen string given by <FEN>
 - print: Display the current board state
 - eval: Display the static evaluation of the current position
+- epd <FILE> [movetime <MILLISECONDS>] [depth <INTEGER>] [nodes <INTEGER>]: Run the EPD test suite
+  in <FILE>, searching each position with the given limits, or for one second if none are given
+- sts <FILE> [movetime <MILLISECONDS>] [depth <INTEGER>] [nodes <INTEGER>]: Run the Strategic Test
+  Suite in <FILE>, and report the score of each theme
+- bench [DEPTH]: Search the benchmark positions to [DEPTH], or to the default depth if none is given,
+  and report the total nodes searched and the nodes per second
 - help: Display this help message
 - quit: Quit the program
 
@@ -40,7 +46,7 @@ Commands:
 )
 
 // Run the perft command in the command line mode
-func perftCommand(pos *Position, command string, TT *TransTable[PerftEntry]) {
+func perftCommand(pos *Position, command string, TT *TransTable[PerftEntry, *PerftEntry]) {
 	command = strings.TrimPrefix(command, "perft ")
 	command = strings.TrimSuffix(command, "\n")
 
@@ -63,7 +69,7 @@ func perftCommand(pos *Position, command string, TT *TransTable[PerftEntry]) {
 }
 
 // Run the divide perft command in the command line mode
-func dividePerftCommand(pos *Position, command string, TT *TransTable[PerftEntry]) {
+func dividePerftCommand(pos *Position, command string, TT *TransTable[PerftEntry, *PerftEntry]) {
 	command = strings.TrimPrefix(command, "dperft ")
 	command = strings.TrimSuffix(command, "\n")
 
@@ -99,8 +105,86 @@ func fenCommand(pos *Position, command string) {
 	pos.LoadFEN(command)
 }
 
+// Parse the file and search limits given to the epd and sts commands.
+func parseEPDCommand(command string) (records []EPDRecord, limits EPDLimits, ok bool) {
+	fields := strings.Fields(command)
+	if len(fields) < 2 {
+		fmt.Println("An EPD file must be given")
+		return nil, limits, false
+	}
+
+	for index := 2; index+1 < len(fields); index += 2 {
+		value, err := strconv.ParseUint(fields[index+1], 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid value for %s\n", fields[index])
+			return nil, limits, false
+		}
+
+		switch fields[index] {
+		case "movetime":
+			limits.MoveTime = time.Duration(value) * time.Millisecond
+		case "depth":
+			limits.Depth = uint8(Min(value, MaxDepth))
+		case "nodes":
+			limits.Nodes = value
+		default:
+			fmt.Printf("Unknown search limit %s\n", fields[index])
+			return nil, limits, false
+		}
+	}
+
+	if limits == (EPDLimits{}) {
+		limits.MoveTime = time.Second
+	}
+
+	records, err := LoadEPDFile(fields[1])
+	if err != nil {
+		fmt.Printf("Failed to load EPD file: %v\n", err)
+		return nil, limits, false
+	}
+	return records, limits, true
+}
+
+// Run the epd command in the command line mode
+func epdCommand(command string) {
+	if records, limits, ok := parseEPDCommand(command); ok {
+		fmt.Println()
+		RunEPD(records, limits, os.Stdout)
+		fmt.Println()
+	}
+}
+
+// Run the sts command in the command line mode
+func stsCommand(command string) {
+	if records, limits, ok := parseEPDCommand(command); ok {
+		fmt.Println()
+		if _, err := RunSTS(records, limits, os.Stdout); err != nil {
+			fmt.Printf("Failed to run STS: %v\n", err)
+		}
+		fmt.Println()
+	}
+}
+
+// Run the bench command in the command line mode
+func benchCommand(command string) {
+	command = strings.TrimSpace(strings.TrimPrefix(command, "bench"))
+	depth := DefaultBenchDepth
+
+	if command != "" {
+		var err error
+		if depth, err = strconv.Atoi(command); err != nil || depth < 1 || depth > MaxDepth {
+			fmt.Println("Invalid value for depth of benchmark.")
+			return
+		}
+	}
+
+	fmt.Println()
+	Bench(uint8(depth), os.Stdout)
+	fmt.Println()
+}
+
 // Resize the perft transposition table.
-func resizeTT(TT *TransTable[PerftEntry], command string) {
+func resizeTT(TT *TransTable[PerftEntry, *PerftEntry], command string) {
 	command = strings.TrimPrefix(command, "tt ")
 	command = strings.TrimSuffix(command, "\n")
 
@@ -123,7 +207,7 @@ func RunCommLoop() {
 
 	reader := bufio.NewReader(os.Stdin)
 	inter := UCIInterface{}
-	TT := TransTable[PerftEntry]{}
+	TT := TransTable[PerftEntry, *PerftEntry]{}
 
 	inter.Search.Setup(FENStartPosition)
 	TT.Resize(DefaultTTSize, PerftEntrySize)
@@ -140,6 +224,12 @@ func RunCommLoop() {
 			dividePerftCommand(&inter.Search.Pos, command, &TT)
 		} else if strings.HasPrefix(command, "fen ") {
 			fenCommand(&inter.Search.Pos, command)
+		} else if strings.HasPrefix(command, "epd") {
+			epdCommand(command)
+		} else if strings.HasPrefix(command, "sts") {
+			stsCommand(command)
+		} else if strings.HasPrefix(command, "bench") {
+			benchCommand(command)
 		} else if strings.HasPrefix(command, "tt ") {
 			resizeTT(&TT, command)
 		} else if command == "print\n" {
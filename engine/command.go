@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// command.go implements the bench, perft, and search commands, which can be given
+// as command-line arguments to run Blunder without going through stdin.
+
+// Load a FEN string into the position, returning an error instead of panicking
+// if it isn't valid.
+func loadFENSafely(pos *Position, fen string) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("invalid fen %q", fen)
+		}
+	}()
+	pos.LoadFEN(fen)
+	return nil
+}
+
+// Run the "bench" command with the given command-line arguments, and return the exit code.
+// The depth can be given as a flag, or as the only argument.
+func RunBenchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	depth := flags.Int("depth", DefaultBenchDepth, "the depth to search each position to")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() > 0 {
+		var err error
+		if *depth, err = strconv.Atoi(flags.Arg(0)); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid value for depth of benchmark.")
+			return 2
+		}
+	}
+
+	if *depth < 1 || *depth > MaxDepth {
+		fmt.Fprintf(os.Stderr, "The depth of the benchmark should be between 1 and %d\n", MaxDepth)
+		return 2
+	}
+
+	Bench(uint8(*depth), os.Stdout)
+	return 0
+}
+
+// Run the "perft" command with the given command-line arguments, and return the exit code.
+func RunPerftCommand(args []string) int {
+	flags := flag.NewFlagSet("perft", flag.ContinueOnError)
+	fen := flags.String("fen", FENStartPosition, "the position to run perft from")
+	depth := flags.Int("depth", 0, "the depth to run perft to")
+	divide := flags.Bool("divide", false, "report the nodes after each move of the position")
+	hashSize := flags.Uint64("hash", DefaultTTSize, "the size of the transposition table in MB")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *depth < 1 || *depth > PerftDepthLimit {
+		fmt.Fprintf(os.Stderr, "The perft depth should be between 1 and %d, given with -depth\n", PerftDepthLimit)
+		return 2
+	}
+
+	var pos Position
+	if err := loadFENSafely(&pos, *fen); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	TT := TransTable[PerftEntry, *PerftEntry]{}
+	TT.Resize(*hashSize, PerftEntrySize)
+
+	start := time.Now()
+	var nodes uint64
+	if *divide {
+		nodes = DividePerft(&pos, uint8(*depth), uint8(*depth), &TT)
+	} else {
+		nodes = Perft(&pos, uint8(*depth), &TT)
+	}
+	elapsed := time.Since(start)
+
+	fmt.Println("Nodes:", nodes)
+	fmt.Printf("Time: %vms\n", elapsed.Milliseconds())
+	fmt.Printf("Nps: %d\n", int(float64(nodes)/elapsed.Seconds()))
+	return 0
+}
+
+// Run the "search" command with the given command-line arguments, and return the exit code.
+// The search reports its progress with UCI info lines, and the move found with a bestmove line.
+// If the side to move is checkmated or stalemated, there's nothing to search, so "bestmove 0000"
+// is reported instead, which isn't treated as a failure.
+func RunSearchCommand(args []string) int {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	fen := flags.String("fen", FENStartPosition, "the position to search")
+	moveTime := flags.Int64("movetime", 0, "the time to search for in milliseconds")
+	depth := flags.Int("depth", 0, "the depth to search to")
+	nodes := flags.Uint64("nodes", 0, "the number of nodes to search")
+	threads := flags.Int("threads", 1, "the number of threads to search with")
+	hashSize := flags.Uint64("hash", DefaultTTSize, "the size of the transposition table in MB")
+	evalParams := flags.String("eval-params", "", "a JSON file of evaluation parameters to search with")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *moveTime <= 0 && *depth <= 0 && *nodes == 0 {
+		fmt.Fprintln(os.Stderr, "At least one of -movetime, -depth, or -nodes should be given")
+		return 2
+	}
+
+	if *evalParams != "" {
+		if err := LoadEvalParams(*evalParams); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load eval params:", err)
+			return 1
+		}
+	}
+
+	search := Search{}
+	if err := loadFENSafely(&search.Pos, *fen); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	search.Setup(*fen)
+	if len(GenLegalMoves(&search.Pos)) == 0 {
+		if search.Pos.InCheck() {
+			fmt.Println("info string no legal moves, the side to move is checkmated")
+		} else {
+			fmt.Println("info string no legal moves, the side to move is stalemated")
+		}
+		fmt.Println("bestmove 0000")
+		return 0
+	}
+
+	search.SetThreads(*threads)
+	search.TT.Resize(*hashSize, SearchEntrySize)
+
+	searchTime, maxDepth, maxNodes := int64(NoValue), uint8(MaxDepth), uint64(math.MaxUint64)
+	if *moveTime > 0 {
+		searchTime = *moveTime
+	}
+	if *depth > 0 {
+		maxDepth = uint8(Min(*depth, MaxDepth))
+	}
+	if *nodes > 0 {
+		maxNodes = *nodes
+	}
+
+	search.Timer.Setup(InfiniteTime, NoValue, searchTime, int16(NoValue), maxDepth, maxNodes)
+	fmt.Printf("bestmove %v\n", search.Search())
+	return 0
+}
@@ -0,0 +1,330 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epd.go implements running test suites of positions given in the Extended Position
+// Description (EPD) format, like Win at Chess, to measure the tactical strength of
+// Blunder. Each position is searched with the same limits, and is solved if the
+// move found satisfies the best move (bm), avoid move (am), and direct mate (dm)
+// operations of the position:
+// https://www.chessprogramming.org/Extended_Position_Description
+
+// A position from an EPD file, and its operations.
+type EPDRecord struct {
+	FEN string
+	ID  string
+
+	// The moves of the best move (bm) and avoid move (am) operations,
+	// and the number of moves of the direct mate (dm) operation, if any.
+	BestMoves  []Move
+	AvoidMoves []Move
+	MateIn     int
+
+	// Every operation of the record, by its opcode, with quotes
+	// removed from string operands.
+	Operations map[string]string
+}
+
+// The limits each position of an EPD test suite is searched with. A limit
+// of zero means no limit, but at least one of them should be set.
+type EPDLimits struct {
+	MoveTime time.Duration
+	Depth    uint8
+	Nodes    uint64
+}
+
+// The result of searching a position from an EPD test suite.
+type EPDResult struct {
+	Record EPDRecord
+	Move   Move
+	Score  int16
+	Depth  uint8
+	Nodes  uint64
+	Time   time.Duration
+
+	// Whether the position was solved, and if so, the time and depth at which
+	// the search found the solution and kept it until the end of the search.
+	Solved     bool
+	SolveTime  time.Duration
+	SolveDepth uint8
+}
+
+// Parse a line of an EPD file.
+func ParseEPD(line string) (record EPDRecord, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return record, fmt.Errorf("invalid EPD %q", line)
+	}
+
+	record.Operations = make(map[string]string)
+	for _, operation := range splitEPDOperations(strings.Join(fields[4:], " ")) {
+		opcode, operand, _ := strings.Cut(operation, " ")
+		operand = strings.TrimSpace(operand)
+		if strings.HasPrefix(operand, "\"") && strings.HasSuffix(operand, "\"") && len(operand) > 1 {
+			operand = operand[1 : len(operand)-1]
+		}
+		record.Operations[opcode] = operand
+	}
+
+	halfMoveClock, fullMoveCounter := "0", "1"
+	if operand, ok := record.Operations["hmvc"]; ok {
+		halfMoveClock = operand
+	}
+	if operand, ok := record.Operations["fmvn"]; ok {
+		fullMoveCounter = operand
+	}
+
+	record.FEN = strings.Join(append(fields[:4:4], halfMoveClock, fullMoveCounter), " ")
+	record.ID = record.Operations["id"]
+
+	var pos Position
+	pos.LoadFEN(record.FEN)
+
+	if record.BestMoves, err = parseEPDMoves(&pos, record.Operations["bm"]); err != nil {
+		return record, err
+	}
+
+	if record.AvoidMoves, err = parseEPDMoves(&pos, record.Operations["am"]); err != nil {
+		return record, err
+	}
+
+	if operand, ok := record.Operations["dm"]; ok {
+		if record.MateIn, err = strconv.Atoi(operand); err != nil || record.MateIn <= 0 {
+			return record, fmt.Errorf("invalid direct mate operation %q", operand)
+		}
+	}
+
+	return record, nil
+}
+
+// Split the operations of an EPD record, which are each ended by a semicolon
+// that isn't inside of a quoted string.
+func splitEPDOperations(operations string) (split []string) {
+	inQuotes := false
+	start := 0
+
+	for index, char := range operations {
+		if char == '"' {
+			inQuotes = !inQuotes
+		} else if char == ';' && !inQuotes {
+			split = append(split, strings.TrimSpace(operations[start:index]))
+			start = index + 1
+		}
+	}
+
+	if rest := strings.TrimSpace(operations[start:]); rest != "" {
+		split = append(split, rest)
+	}
+	return split
+}
+
+// Parse the moves of an EPD operation, which are usually in SAN, but are
+// sometimes given in UCI notation instead.
+func parseEPDMoves(pos *Position, operand string) (moves []Move, err error) {
+	for _, moveStr := range strings.Fields(operand) {
+		move, ok := parseEPDMove(pos, moveStr)
+		if !ok {
+			return nil, fmt.Errorf("invalid move %s for position %s", moveStr, pos.GenFEN())
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+// Find the legal move matching a move in SAN or UCI notation.
+func parseEPDMove(pos *Position, moveStr string) (Move, bool) {
+	san := strings.ReplaceAll(strings.TrimRight(moveStr, "+#!?"), "0", "O")
+	for _, move := range GenLegalMoves(pos) {
+		if strings.TrimRight(ConvertMoveToSAN(pos, move), "+#") == san || move.String() == moveStr {
+			return move, true
+		}
+	}
+	return NullMove, false
+}
+
+// Load the records of an EPD file, skipping empty lines and comments.
+func LoadEPDFile(path string) (records []EPDRecord, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		record, err := ParseEPD(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// Determine if a move found by the search, with the given score, satisfies the
+// operations of the record.
+func (record *EPDRecord) isSolution(move Move, score int16) bool {
+	if len(record.BestMoves) == 0 && len(record.AvoidMoves) == 0 && record.MateIn == 0 {
+		return false
+	}
+
+	for _, avoidMove := range record.AvoidMoves {
+		if move.Equal(avoidMove) {
+			return false
+		}
+	}
+
+	if len(record.BestMoves) > 0 {
+		found := false
+		for _, bestMove := range record.BestMoves {
+			found = found || move.Equal(bestMove)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if record.MateIn > 0 {
+		return score > Checkmate && int((Inf-score+1)/2) <= record.MateIn
+	}
+	return true
+}
+
+// Search the position of the record with the given limits, and determine if it was solved.
+func SolveEPD(search *Search, record EPDRecord, limits EPDLimits) (result EPDResult) {
+	result.Record = record
+	search.Setup(record.FEN)
+	search.Reset()
+
+	moveTime, maxDepth, maxNodes := int64(NoValue), uint8(MaxDepth), uint64(math.MaxUint64)
+	if limits.MoveTime > 0 {
+		moveTime = limits.MoveTime.Milliseconds()
+	}
+	if limits.Depth > 0 {
+		maxDepth = limits.Depth
+	}
+	if limits.Nodes > 0 {
+		maxNodes = limits.Nodes
+	}
+
+	search.SearchMoves = nil
+	search.MateDepth = uint8(record.MateIn)
+	search.Timer.Setup(InfiniteTime, NoValue, moveTime, int16(NoValue), maxDepth, maxNodes)
+
+	// Keep track of when the search found a solution and kept it.
+	start := time.Now()
+	search.IterationHook = func(depth uint8, bestMove Move, score int16) {
+		if !record.isSolution(bestMove, score) {
+			result.SolveTime, result.SolveDepth = 0, 0
+		} else if result.SolveDepth == 0 {
+			result.SolveTime, result.SolveDepth = time.Since(start), depth
+		}
+		result.Depth = depth
+	}
+
+	result.Move = search.Search()
+	result.Time = time.Since(start)
+	result.Score = search.BestScore()
+	result.Nodes = search.nodeCount()
+	result.Solved = record.isSolution(result.Move, result.Score)
+	search.IterationHook = nil
+	search.MateDepth = 0
+
+	// The search may have been stopped before finishing the iteration in
+	// which it changed its mind to the solution.
+	if result.Solved && result.SolveDepth == 0 {
+		result.SolveTime, result.SolveDepth = result.Time, result.Depth
+	}
+	return result
+}
+
+// Run an EPD test suite, writing the result of each position and a summary of
+// the results to the given writer.
+func RunEPD(records []EPDRecord, limits EPDLimits, out io.Writer) (results []EPDResult) {
+	search := Search{Silent: true}
+	search.TT.Resize(DefaultTTSize, SearchEntrySize)
+
+	solved := 0
+	totalSolveTime := time.Duration(0)
+	totalNodes := uint64(0)
+	start := time.Now()
+
+	for index, record := range records {
+		var pos Position
+		pos.LoadFEN(record.FEN)
+
+		result := SolveEPD(&search, record, limits)
+		results = append(results, result)
+		totalNodes += result.Nodes
+
+		status := "failed"
+		if result.Solved {
+			status = "solved"
+			solved++
+			totalSolveTime += result.SolveTime
+		}
+
+		fmt.Fprintf(
+			out, "%4d %-16s %-16s found %-7s %-7s %-10s depth %2d  time %6dms  nodes %d\n",
+			index+1, record.ID, formatEPDSolution(&pos, &record), formatEPDMove(&pos, result.Move),
+			status, getMateOrCPScore(result.Score), result.Depth, result.Time.Milliseconds(), result.Nodes,
+		)
+	}
+
+	elapsed := time.Since(start)
+	fmt.Fprintf(out, "\nSolved %d of %d positions (%.1f%%)\n", solved, len(records), 100*float64(solved)/float64(max(1, len(records))))
+	if solved > 0 {
+		fmt.Fprintf(
+			out, "Time to solution: %dms total, %dms average\n",
+			totalSolveTime.Milliseconds(), totalSolveTime.Milliseconds()/int64(solved),
+		)
+	}
+	fmt.Fprintf(out, "Total time: %dms, nodes: %d, nps: %d\n", elapsed.Milliseconds(), totalNodes, uint64(float64(totalNodes)/elapsed.Seconds()))
+	return results
+}
+
+// Format the operations of a record which determine its solution.
+func formatEPDSolution(pos *Position, record *EPDRecord) string {
+	solution := []string{}
+	if len(record.BestMoves) > 0 {
+		solution = append(solution, "bm")
+		for _, move := range record.BestMoves {
+			solution = append(solution, ConvertMoveToSAN(pos, move))
+		}
+	}
+
+	if len(record.AvoidMoves) > 0 {
+		solution = append(solution, "am")
+		for _, move := range record.AvoidMoves {
+			solution = append(solution, ConvertMoveToSAN(pos, move))
+		}
+	}
+
+	if record.MateIn > 0 {
+		solution = append(solution, fmt.Sprintf("dm %d", record.MateIn))
+	}
+	return strings.Join(solution, " ")
+}
+
+// Format a move found by the search in SAN, if the search found one.
+func formatEPDMove(pos *Position, move Move) string {
+	if move == NullMove {
+		return "none"
+	}
+	return ConvertMoveToSAN(pos, move)
+}
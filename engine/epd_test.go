@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"io"
+	"testing"
+)
+
+// epd_test.go provides tests to ensure EPD records are parsed correctly, and
+// that the solutions of a few easy test positions are found.
+
+var EPDTestRecords = []string{
+	`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`,
+	`r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - bm Bc5+; id "WAC.004";`,
+	`6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - dm 1; id "mate in one"; c0 "back rank; easy";`,
+	`rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - am f3 g4; hmvc 3; fmvn 7; id "avoid";`,
+}
+
+func TestParseEPD(t *testing.T) {
+	record, err := ParseEPD(EPDTestRecords[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.FEN != "6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - 0 1" || record.ID != "mate in one" || record.MateIn != 1 {
+		t.Errorf("Parsing EPD record failed, got %+v", record)
+	}
+
+	if record.Operations["c0"] != "back rank; easy" {
+		t.Errorf("Expected a c0 operation of %q, got %q", "back rank; easy", record.Operations["c0"])
+	}
+
+	record, err = ParseEPD(EPDTestRecords[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.FEN != "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 3 7" || len(record.AvoidMoves) != 2 {
+		t.Errorf("Parsing EPD record failed, got %+v", record)
+	}
+
+	if record.AvoidMoves[0].String() != "f2f3" || record.AvoidMoves[1].String() != "g2g4" {
+		t.Errorf("Expected avoid moves of f2f3 and g2g4, got %v", record.AvoidMoves)
+	}
+
+	if _, err := ParseEPD("2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qh8;"); err == nil {
+		t.Errorf("Parsing an EPD record with an illegal best move should fail")
+	}
+}
+
+func TestRunEPD(t *testing.T) {
+	records := []EPDRecord{}
+	for _, line := range EPDTestRecords {
+		record, err := ParseEPD(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	results := RunEPD(records, EPDLimits{Depth: 6}, io.Discard)
+	for _, result := range results {
+		if !result.Solved {
+			t.Errorf("Expected position %s to be solved, got %v", result.Record.ID, result.Move)
+		}
+
+		if result.SolveDepth == 0 || result.SolveDepth > result.Depth || result.SolveTime > result.Time {
+			t.Errorf("Invalid time to solution for position %s: %+v", result.Record.ID, result)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package engine
+
+// eval_params.go implements loading the parameters of the hand-crafted evaluation
+// from a JSON file, so the weights written by the tuner can be used by the engine
+// without copying them into the source by hand. A file looks like:
+//
+//	{
+//	    "scaling_factor": 0.0154,
+//	    "params": {
+//	        "PieceValueMG": [84, 333, 346, 441, 921],
+//	        "BishopPairBonusMG": 22,
+//	        ...
+//	    }
+//	}
+//
+// Each parameter is named after the variable it's stored in, piece-square tables
+// are given as one flat list, with the table of each piece after the other, the
+// piece values leave out the king, and parameters left out of a file keep their
+// current values.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The most values a parameter can have for each of its values to be listed as a
+// UCI option. Larger parameters, like the piece-square tables, can still be set
+// with setoption, but listing them would flood the options shown by a GUI.
+const MaxListedEvalParamValues = 8
+
+// A parameter of the evaluation, given by its name and pointers to the values
+// it's stored in. A parameter with one value is a single number.
+//
+// Each parameter can also be set as a UCI option, either all at once, with its
+// values separated by spaces, or one value at a time, using the option name of
+// the value.
+//
+// Every value of a parameter must be between its minimum and maximum, which are
+// also the bounds its UCI options are listed with. The king safety points are
+// never negative, since they're added up as unsigned numbers.
+type EvalParam struct {
+	Name     string
+	Values   []*int16
+	Min, Max int16
+}
+
+// The parameters of the evaluation, in the order they're written to a file. The
+// value of the king is left out, since it's never counted as material.
+var EvalParams = []EvalParam{
+	arrayParam("PieceValueMG", PieceValueMG[:King], 0, 3000),
+	arrayParam("PieceValueEG", PieceValueEG[:King], 0, 3000),
+	arrayParam("PieceMobilityMG", PieceMobilityMG[:], -100, 100),
+	arrayParam("PieceMobilityEG", PieceMobilityEG[:], -100, 100),
+	scalarParam("BishopPairBonusMG", &BishopPairBonusMG, -500, 500),
+	scalarParam("BishopPairBonusEG", &BishopPairBonusEG, -500, 500),
+	scalarParam("IsolatedPawnPenatlyMG", &IsolatedPawnPenatlyMG, -500, 500),
+	scalarParam("IsolatedPawnPenatlyEG", &IsolatedPawnPenatlyEG, -500, 500),
+	scalarParam("DoubledPawnPenatlyMG", &DoubledPawnPenatlyMG, -500, 500),
+	scalarParam("DoubledPawnPenatlyEG", &DoubledPawnPenatlyEG, -500, 500),
+	scalarParam("RookOrQueenOnSeventhBonusEG", &RookOrQueenOnSeventhBonusEG, -500, 500),
+	scalarParam("KnightOnOutpostBonusMG", &KnightOnOutpostBonusMG, -500, 500),
+	scalarParam("KnightOnOutpostBonusEG", &KnightOnOutpostBonusEG, -500, 500),
+	scalarParam("RookOnOpenFileBonusMG", &RookOnOpenFileBonusMG, -500, 500),
+	scalarParam("TempoBonusMG", &TempoBonusMG, -500, 500),
+	scalarParam("BishopOutPostBonusMG", &BishopOutPostBonusMG, -500, 500),
+	scalarParam("BishopOutPostBonusEG", &BishopOutPostBonusEG, -500, 500),
+	arrayParam("OuterRingAttackPoints", OuterRingAttackPoints[:], 0, 20),
+	arrayParam("InnerRingAttackPoints", InnerRingAttackPoints[:], 0, 20),
+	scalarParam("SemiOpenFileNextToKingPenalty", &SemiOpenFileNextToKingPenalty, 0, 20),
+	psqtParam("PSQT_MG", &PSQT_MG, -500, 500),
+	psqtParam("PSQT_EG", &PSQT_EG, -500, 500),
+	arrayParam("PassedPawnPSQT_MG", PassedPawnPSQT_MG[:], -500, 500),
+	arrayParam("PassedPawnPSQT_EG", PassedPawnPSQT_EG[:], -500, 500),
+}
+
+// Get the name of the UCI option which sets the value at the given index of the
+// parameter, which is the name of the parameter followed by the index, like
+// PieceValueMG_1, or just the name of the parameter if it's a single number.
+func (param *EvalParam) OptionName(index int) string {
+	if len(param.Values) == 1 {
+		return param.Name
+	}
+	return fmt.Sprintf("%s_%d", param.Name, index)
+}
+
+// Check that a value is within the bounds of the parameter.
+func (param *EvalParam) checkValue(value int16) error {
+	if value < param.Min || value > param.Max {
+		return fmt.Errorf("eval param %s must be between %d and %d, not %d", param.Name, param.Min, param.Max, value)
+	}
+	return nil
+}
+
+func scalarParam(name string, value *int16, minValue, maxValue int16) EvalParam {
+	return EvalParam{Name: name, Values: []*int16{value}, Min: minValue, Max: maxValue}
+}
+
+func arrayParam(name string, values []int16, minValue, maxValue int16) EvalParam {
+	param := EvalParam{Name: name, Min: minValue, Max: maxValue}
+	for i := range values {
+		param.Values = append(param.Values, &values[i])
+	}
+	return param
+}
+
+func psqtParam(name string, psqt *[6][64]int16, minValue, maxValue int16) EvalParam {
+	param := EvalParam{Name: name, Min: minValue, Max: maxValue}
+	for piece := range psqt {
+		param.Values = append(param.Values, arrayParam(name, psqt[piece][:], minValue, maxValue).Values...)
+	}
+	return param
+}
+
+// Get the current values of each parameter of the evaluation, by name.
+func EvalParamValues() map[string][]int16 {
+	values := make(map[string][]int16, len(EvalParams))
+	for _, param := range EvalParams {
+		for _, value := range param.Values {
+			values[param.Name] = append(values[param.Name], *value)
+		}
+	}
+	return values
+}
+
+// Write the given values of the parameters of the evaluation as JSON, along with the
+// scaling factor they were tuned with, or none if it's zero. The parameters are written
+// in the order of EvalParams, and any parameter without a value is left out.
+func WriteEvalParams(out io.Writer, values map[string][]int16, scalingFactor float64) error {
+	writer := bufio.NewWriter(out)
+	fmt.Fprint(writer, "{\n")
+	if scalingFactor != 0 {
+		fmt.Fprintf(writer, "    \"scaling_factor\": %v,\n", scalingFactor)
+	}
+	fmt.Fprint(writer, "    \"params\": {")
+
+	separator := "\n"
+	for _, param := range EvalParams {
+		paramValues, ok := values[param.Name]
+		if !ok {
+			continue
+		}
+
+		if len(paramValues) != len(param.Values) {
+			return fmt.Errorf("%s has %d values, but should have %d", param.Name, len(paramValues), len(param.Values))
+		}
+
+		fmt.Fprintf(writer, "%s        %q: ", separator, param.Name)
+		separator = ",\n"
+
+		if len(param.Values) == 1 {
+			fmt.Fprint(writer, paramValues[0])
+			continue
+		}
+
+		// Write long lists, like the piece-square tables, eight values to a line.
+		fmt.Fprint(writer, "[")
+		for i, value := range paramValues {
+			if i > 0 {
+				fmt.Fprint(writer, ", ")
+			}
+			if len(paramValues) > 8 && i%8 == 0 {
+				fmt.Fprint(writer, "\n            ")
+			}
+			fmt.Fprint(writer, value)
+		}
+		if len(paramValues) > 8 {
+			fmt.Fprint(writer, "\n        ")
+		}
+		fmt.Fprint(writer, "]")
+	}
+
+	fmt.Fprint(writer, "\n    }\n}\n")
+	return writer.Flush()
+}
+
+// Load the parameters of the evaluation from the given JSON file. Every parameter in
+// the file is checked before any is set, so a bad file leaves the evaluation as it was.
+func LoadEvalParams(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		Params map[string]json.RawMessage `json:"params"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid eval params file %s: %w", path, err)
+	}
+
+	if len(file.Params) == 0 {
+		return fmt.Errorf("eval params file %s has no params", path)
+	}
+
+	params := evalParamsByName()
+	values := make(map[string][]int16, len(file.Params))
+
+	for name, raw := range file.Params {
+		param, ok := params[name]
+		if !ok {
+			return fmt.Errorf("unknown eval param %q", name)
+		}
+
+		var paramValues []int16
+		if len(param.Values) == 1 {
+			paramValues = make([]int16, 1)
+			err = json.Unmarshal(raw, &paramValues[0])
+		} else {
+			err = json.Unmarshal(raw, &paramValues)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid value for eval param %s: %w", name, err)
+		}
+		values[name] = paramValues
+	}
+	return SetEvalParamValues(values)
+}
+
+// Set the parameters of the evaluation to the given values, by name. Every value is
+// checked before any is set, and parameters not given keep their current values.
+func SetEvalParamValues(values map[string][]int16) error {
+	params := evalParamsByName()
+	for name, paramValues := range values {
+		param, ok := params[name]
+		if !ok {
+			return fmt.Errorf("unknown eval param %q", name)
+		}
+
+		if len(paramValues) != len(param.Values) {
+			return fmt.Errorf("eval param %s has %d values, but should have %d", name, len(paramValues), len(param.Values))
+		}
+
+		for _, value := range paramValues {
+			if err := param.checkValue(value); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, paramValues := range values {
+		for i, value := range paramValues {
+			*params[name].Values[i] = value
+		}
+	}
+	return nil
+}
+
+// Set a parameter of the evaluation from a UCI option, given either by the name
+// of the parameter and all of its values separated by spaces, or by the option
+// name of one of its values and that value. Whether the option names a parameter
+// is returned, so other options can be told apart.
+func SetEvalParamOption(option, value string) (found bool, err error) {
+	params := evalParamsByName()
+	if _, ok := params[option]; ok {
+		fields := strings.Fields(value)
+		values := make([]int16, len(fields))
+
+		for i, field := range fields {
+			if values[i], err = parseEvalParamValue(field); err != nil {
+				return true, err
+			}
+		}
+		return true, SetEvalParamValues(map[string][]int16{option: values})
+	}
+
+	separator := strings.LastIndexByte(option, '_')
+	if separator == -1 {
+		return false, nil
+	}
+
+	param, ok := params[option[:separator]]
+	index, err := strconv.Atoi(option[separator+1:])
+	if !ok || err != nil || len(param.Values) == 1 {
+		return false, nil
+	}
+
+	if index < 0 || index >= len(param.Values) {
+		return true, fmt.Errorf("eval param %s has no value %d", param.Name, index)
+	}
+
+	parsed, err := parseEvalParamValue(value)
+	if err != nil {
+		return true, err
+	}
+
+	if err := param.checkValue(parsed); err != nil {
+		return true, err
+	}
+
+	*param.Values[index] = parsed
+	return true, nil
+}
+
+func parseEvalParamValue(value string) (int16, error) {
+	parsed, err := strconv.ParseInt(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid eval param value %q", value)
+	}
+	return int16(parsed), nil
+}
+
+func evalParamsByName() map[string]EvalParam {
+	params := make(map[string]EvalParam, len(EvalParams))
+	for _, param := range EvalParams {
+		params[param.Name] = param
+	}
+	return params
+}
+
+// Recompute the incrementally updated material and piece-square table scores
+// of the position, after the parameters of the evaluation have changed.
+func (pos *Position) RefreshScores() {
+	pos.MGScores = [2]int16{}
+	pos.EGScores = [2]int16{}
+
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		if piece.Type != NoType {
+			pos.MGScores[piece.Color] += PieceValueMG[piece.Type] + PSQT_MG[piece.Type][FlipSq[piece.Color][sq]]
+			pos.EGScores[piece.Color] += PieceValueEG[piece.Type] + PSQT_EG[piece.Type][FlipSq[piece.Color][sq]]
+		}
+	}
+}
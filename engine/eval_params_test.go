@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// eval_params_test.go provides tests to ensure the parameters of the evaluation
+// are written and loaded correctly, and that a bad file leaves them unchanged.
+
+func TestEvalParamsRoundTrip(t *testing.T) {
+	original := EvalParamValues()
+	defer SetEvalParamValues(original)
+
+	changed := EvalParamValues()
+	changed["PieceValueMG"][Knight] += 10
+	changed["TempoBonusMG"][0] = -3
+	changed["PSQT_EG"][int(Queen)*64+27] = 42
+
+	var buffer bytes.Buffer
+	if err := WriteEvalParams(&buffer, changed, 0.0154); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "params.json")
+	os.WriteFile(path, buffer.Bytes(), 0644)
+
+	pos := Position{}
+	pos.LoadFEN(NNUETestPositions[1])
+
+	if err := LoadEvalParams(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if PieceValueMG[Knight] != original["PieceValueMG"][Knight]+10 || TempoBonusMG != -3 || PSQT_EG[Queen][27] != 42 {
+		t.Errorf(
+			"Expected the changed params to be loaded, got %d, %d, and %d",
+			PieceValueMG[Knight], TempoBonusMG, PSQT_EG[Queen][27],
+		)
+	}
+
+	// The scores of a position loaded before the params changed should match
+	// those of the same position loaded after, once they're refreshed.
+	expected := Position{}
+	expected.LoadFEN(NNUETestPositions[1])
+
+	pos.RefreshScores()
+	if pos.MGScores != expected.MGScores || pos.EGScores != expected.EGScores {
+		t.Errorf(
+			"Expected refreshed scores %v and %v, got %v and %v",
+			expected.MGScores, expected.EGScores, pos.MGScores, pos.EGScores,
+		)
+	}
+}
+
+func TestLoadBadEvalParams(t *testing.T) {
+	original := EvalParamValues()
+	defer SetEvalParamValues(original)
+
+	badFiles := []string{
+		`{"params": {"TempoBonusMG": 5, "NoSuchParam": 1}}`,
+		`{"params": {"TempoBonusMG": 5, "PieceValueMG": [1, 2, 3]}}`,
+		`{"params": {"TempoBonusMG": 5, "BishopPairBonusMG": [1]}}`,
+		`{"params": {"TempoBonusMG": 5, "OuterRingAttackPoints": [0, 1, -1, 1, 1]}}`,
+		`{"params": {}}`,
+		`not json`,
+	}
+
+	for _, contents := range badFiles {
+		path := filepath.Join(t.TempDir(), "params.json")
+		os.WriteFile(path, []byte(contents), 0644)
+
+		if err := LoadEvalParams(path); err == nil {
+			t.Errorf("Expected an error loading %s", contents)
+		}
+
+		if TempoBonusMG != original["TempoBonusMG"][0] {
+			t.Fatalf("Expected a bad file to leave the params unchanged, loading %s", contents)
+		}
+	}
+}
+
+func TestSetEvalParamOption(t *testing.T) {
+	original := EvalParamValues()
+	defer SetEvalParamValues(original)
+
+	options := []struct {
+		option, value string
+		found, failed bool
+	}{
+		{"BishopPairBonusMG", "31", true, false},
+		{"PieceValueEG_3", "500", true, false},
+		{"InnerRingAttackPoints", "0 5 6 7 8", true, false},
+		{"PSQT_MG_100", "-12", true, false},
+		{"PieceValueEG_5", "0", true, true},
+		{"PieceValueEG_2", "-300", true, true},
+		{"InnerRingAttackPoints_1", "21", true, true},
+		{"InnerRingAttackPoints", "1 2", true, true},
+		{"TempoBonusMG", "40000", true, true},
+		{"TempoBonusMG", "501", true, true},
+		{"TempoBonusMG", "ten", true, true},
+		{"TempoBonusMG_0", "10", false, false},
+		{"Hash", "64", false, false},
+	}
+
+	for _, test := range options {
+		found, err := SetEvalParamOption(test.option, test.value)
+		if found != test.found || (err != nil) != test.failed {
+			t.Errorf(
+				"Expected setting %s to %s to give found %v and failed %v, got %v and %v",
+				test.option, test.value, test.found, test.failed, found, err,
+			)
+		}
+	}
+
+	if BishopPairBonusMG != 31 || PieceValueEG[Rook] != 500 || InnerRingAttackPoints != [5]int16{0, 5, 6, 7, 8} ||
+		PSQT_MG[Knight][100-64] != -12 {
+		t.Error("Expected the valid options to set the eval params")
+	}
+
+	if TempoBonusMG != original["TempoBonusMG"][0] {
+		t.Errorf("Expected the invalid options to leave TempoBonusMG at %d, got %d", original["TempoBonusMG"][0], TempoBonusMG)
+	}
+}
+
+func TestEvalParamBounds(t *testing.T) {
+	for _, param := range EvalParams {
+		for i, value := range param.Values {
+			if *value < param.Min || *value > param.Max {
+				t.Errorf("Expected %s to default to a value between %d and %d, got %d", param.OptionName(i), param.Min, param.Max, *value)
+			}
+		}
+	}
+}
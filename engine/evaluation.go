@@ -278,6 +278,10 @@ func EvaluatePos(pos *Position) int16 {
 		return Draw
 	}
 
+	if nnueActive {
+		return evaluateNNUE(pos)
+	}
+
 	eval := Eval{
 		MGScores: pos.MGScores,
 		EGScores: pos.EGScores,
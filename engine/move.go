@@ -28,6 +28,11 @@ const (
 
 type Move uint32
 
+// Whether castling moves are displayed in Chess960 notation, as the king capturing
+// its own rook (e.g. e1h1), or in standard notation, as the king moving two squares
+// (e.g. e1g1). Castling moves in either notation can always be read in.
+var Chess960Notation = false
+
 // Create a new move. The first 6 bits are the from square, the next 6 bits are the to square,
 // the next two represent the move type, the next two are reserved for any speical flags needed
 // to give full information concering the move, and the last 16-bits are used for scoring a move
@@ -75,6 +80,12 @@ func (move Move) Equal(m Move) bool {
 // A helper function to extract the info from a move represented
 // as 32-bits, and display it.
 func (move Move) String() string {
+	// The null move is written as "0000", which is how the UCI
+	// protocol reports that there's no move to play.
+	if move == NullMove {
+		return "0000"
+	}
+
 	from, to, moveType, flag := move.FromSq(), move.ToSq(), move.MoveType(), move.Flag()
 
 	promotionType := ""
@@ -90,6 +101,13 @@ func (move Move) String() string {
 			promotionType = "q"
 		}
 	}
+
+	// Castling moves are encoded as the king capturing its own rook, so unless we're
+	// using Chess960 notation, display the square the king ends up on instead.
+	if moveType == Castle && !Chess960Notation {
+		to, _ = castlingSquares(from, to)
+	}
+
 	return fmt.Sprintf("%v%v%v", posToCoordinate(from), posToCoordinate(to), promotionType)
 }
 
@@ -114,14 +132,17 @@ func moveFromCoord(pos *Position, move string) Move {
 		} else if move[moveLen-1] == 'q' {
 			flag = QueenPromotion
 		}
-	} else if move == "e1g1" && moved == King {
-		moveType = Castle
-	} else if move == "e1c1" && moved == King {
-		moveType = Castle
-	} else if move == "e8g8" && moved == King {
-		moveType = Castle
-	} else if move == "e8c8" && moved == King {
+	} else if moved == King && pos.Squares[to] == (Piece{Type: Rook, Color: pos.SideToMove}) {
+		// A castling move in Chess960 notation.
 		moveType = Castle
+	} else if moved == King && FileOf(from) == FileOf(E1) && (FileOf(to) == FileOf(G1) || FileOf(to) == FileOf(C1)) {
+		// A castling move in standard notation, which we encode as the king
+		// capturing its own rook.
+		wing := Queenside
+		if FileOf(to) == FileOf(G1) {
+			wing = Kingside
+		}
+		return pos.castlingMove(pos.SideToMove, wing)
 	} else if to == pos.EPSq && moved == Pawn {
 		moveType = Attack
 		flag = AttackEP
@@ -4,13 +4,6 @@ package engine
 
 import "fmt"
 
-const (
-	// These masks help determine whether or not the squares between
-	// the king and it's rooks are clear for castling
-	F1_G1, B1_C1_D1 = 0x600000000000000, 0x7000000000000000
-	F8_G8, B8_C8_D8 = 0x6, 0x70
-)
-
 // Generate all pseduo-legal moves for a given position.
 func genMoves(pos *Position) (moves MoveList) {
 	// Go through each piece type, and each piece for that type,
@@ -206,32 +199,48 @@ func makePromotionMoves(from, to uint8, moves *MoveList) {
 	moves.AddMove(NewMove(from, to, Promotion, QueenPromotion))
 }
 
-// Generate castling moves. Note testing whether or not castling has the king
-// crossing attacked squares is not tested for here, as pseduo-legal move
-// generation is the focus.
+// Generate the castling moves for the side to move. Castling moves are encoded as
+// the king capturing its own rook, which works for both standard chess and Chess960.
 func genCastlingMoves(pos *Position, moves *MoveList) {
 	allPieces := pos.Sides[pos.SideToMove] | pos.Sides[pos.SideToMove^1]
-	if pos.SideToMove == White {
-		if pos.CastlingRights&WhiteKingsideRight != 0 && (allPieces&F1_G1) == 0 && (!sqIsAttacked(pos, pos.SideToMove, E1) &&
-			!sqIsAttacked(pos, pos.SideToMove, F1) && !sqIsAttacked(pos, pos.SideToMove, G1)) {
-			moves.AddMove(NewMove(E1, G1, Castle, NoFlag))
+
+	for wing, right := range SideCastlingRights[pos.SideToMove] {
+		if pos.CastlingRights&right == 0 {
+			continue
 		}
-		if pos.CastlingRights&WhiteQueensideRight != 0 && (allPieces&B1_C1_D1) == 0 && (!sqIsAttacked(pos, pos.SideToMove, E1) &&
-			!sqIsAttacked(pos, pos.SideToMove, D1) && !sqIsAttacked(pos, pos.SideToMove, C1)) {
-			moves.AddMove(NewMove(E1, C1, Castle, NoFlag))
+
+		index := castlingIndex(right)
+		if allPieces&pos.castlingEmptyBBs[index] != 0 {
+			continue
 		}
-	} else {
-		if pos.CastlingRights&BlackKingsideRight != 0 && (allPieces&F8_G8) == 0 && (!sqIsAttacked(pos, pos.SideToMove, E8) &&
-			!sqIsAttacked(pos, pos.SideToMove, F8) && !sqIsAttacked(pos, pos.SideToMove, G8)) {
-			moves.AddMove(NewMove(E8, G8, Castle, NoFlag))
+
+		pathIsSafe := true
+		for path := pos.castlingPathBBs[index]; path != 0; {
+			if sqIsAttacked(pos, pos.SideToMove, path.PopBit()) {
+				pathIsSafe = false
+				break
+			}
 		}
-		if pos.CastlingRights&BlackQueensideRight != 0 && (allPieces&B8_C8_D8) == 0 && (!sqIsAttacked(pos, pos.SideToMove, E8) &&
-			!sqIsAttacked(pos, pos.SideToMove, D8) && !sqIsAttacked(pos, pos.SideToMove, C8)) {
-			moves.AddMove(NewMove(E8, C8, Castle, NoFlag))
+
+		if pathIsSafe {
+			moves.AddMove(pos.castlingMove(pos.SideToMove, uint8(wing)))
 		}
 	}
 }
 
+// Generate all legal moves for a given position.
+func GenLegalMoves(pos *Position) (legalMoves []Move) {
+	moves := genMoves(pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.DoMove(move) {
+			legalMoves = append(legalMoves, move)
+		}
+		pos.UndoMove(move)
+	}
+	return legalMoves
+}
+
 // From a bitboard representing possible squares a piece can move,
 // serialize it, and generate a list of moves.
 func genMovesFromBB(pos *Position, from uint8, movesBB, enemyBB Bitboard, moves *MoveList) {
@@ -290,7 +299,7 @@ func sqIsAttacked(pos *Position, usColor, sq uint8) bool {
 // number of nodes explored.  This function is used to
 // debug move generation and ensure it is working by comparing
 // the results to the known results of other engines
-func DividePerft(pos *Position, depth, divdeAt uint8, TT *TransTable[PerftEntry]) uint64 {
+func DividePerft(pos *Position, depth, divdeAt uint8, TT *TransTable[PerftEntry, *PerftEntry]) uint64 {
 	// If depth zero has been reached, return zero...
 	if depth == 0 {
 		return 1
@@ -332,7 +341,7 @@ func DividePerft(pos *Position, depth, divdeAt uint8, TT *TransTable[PerftEntry]
 
 // Same as divide perft but doesn't print subnode count
 // for each move, only the final total.
-func Perft(pos *Position, depth uint8, TT *TransTable[PerftEntry]) uint64 {
+func Perft(pos *Position, depth uint8, TT *TransTable[PerftEntry, *PerftEntry]) uint64 {
 	// If depth zero has been reached, return zero...
 	if depth == 0 {
 		return 1
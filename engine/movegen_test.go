@@ -26,11 +26,11 @@ type PerftTest struct {
 	DepthValues [MaxPerftDepth]uint64
 }
 
-// Load the perft test suite
-func loadPerftSuite() (perftTests []PerftTest) {
+// Load the perft test suite with the given file name
+func loadPerftSuite(fileName string) (perftTests []PerftTest) {
 	wd, _ := os.Getwd()
 	parentFolder := filepath.Dir(wd)
-	filePath := filepath.Join(parentFolder, "/perft_suite/perft_suite.epd")
+	filePath := filepath.Join(parentFolder, "/perft_suite/", fileName)
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -91,16 +91,27 @@ func printPerftTestRowSeparator() {
 
 // Test blunder against the perft suite
 func TestMovegen(t *testing.T) {
+	runPerftSuite(t, "perft_suite.epd")
+}
+
+// Test blunder against the Chess960 perft suite, which covers castling
+// with the king and rooks starting on different squares.
+func TestMovegen960(t *testing.T) {
+	runPerftSuite(t, "perft_suite_960.epd")
+}
+
+// Run the perft suite with the given file name
+func runPerftSuite(t *testing.T, fileName string) {
 	printPerftTestRowSeparator()
 	printPerftTestRow("position", "depth", "expected", "moves", "correct")
 	printPerftTestRowSeparator()
 
 	pos := Position{}
-	TT := TransTable[PerftEntry]{}
+	TT := TransTable[PerftEntry, *PerftEntry]{}
 	totalNodes := uint64(0)
 	testsPassed := true
 
-	perftTests := loadPerftSuite()
+	perftTests := loadPerftSuite(fileName)
 	TT.Resize(DefaultTTSize, PerftEntrySize)
 	start := time.Now()
 
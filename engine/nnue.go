@@ -0,0 +1,254 @@
+package engine
+
+// nnue.go implements an efficiently updatable neural network (NNUE) evaluation,
+// which can be used instead of the hand-crafted evaluation.
+//
+// The network has a single hidden layer. The input layer has 768 features, one for
+// each combination of piece color, piece type, and square, and it's evaluated from
+// the perspective of both sides. The hidden layer for each perspective, called an
+// accumulator, only changes by a few weights each time a piece is added or removed
+// from the board, so it's updated incrementally as moves are made and unmade. The
+// output is then computed from the clipped accumulators of the side to move and
+// its opponent.
+//
+// Everything is written in plain Go, so no SIMD support is needed to use a network.
+// The format of the network files is documented in docs/nnue.md.
+//
+// https://www.chessprogramming.org/NNUE
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// The number of features of the input layer, and the number of neurons in the hidden layer.
+	NNUEInputSize  = 768
+	NNUEHiddenSize = 256
+
+	// The quantization factors of the hidden and output layers, and the factor used to scale
+	// the output of the network into centipawns.
+	NNUEQA    = 255
+	NNUEQB    = 64
+	NNUEScale = 400
+
+	// The magic number and version at the start of every network file.
+	NNUEMagic   = "BNUE"
+	NNUEVersion = 1
+)
+
+// A struct representing the quantized weights and biases of a network.
+type Network struct {
+	FeatureWeights [NNUEInputSize][NNUEHiddenSize]int16
+	FeatureBiases  [NNUEHiddenSize]int16
+	OutputWeights  [2 * NNUEHiddenSize]int16
+	OutputBias     int16
+}
+
+// The network currently used for evaluation, and whether the network should
+// be used, as set by the UseNNUE option.
+var nnueNetwork *Network
+var useNNUE bool
+
+// Whether positions are being evaluated using the network. Positions only
+// keep their accumulators updated when this is true.
+var nnueActive bool
+
+// Load a network from the given file.
+func LoadNetwork(path string) (*Network, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	network, err := ReadNetwork(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return network, nil
+}
+
+// Read a network in the format described in docs/nnue.md.
+func ReadNetwork(reader io.Reader) (*Network, error) {
+	var header struct {
+		Magic      [4]byte
+		Version    uint32
+		InputSize  uint32
+		HiddenSize uint32
+	}
+
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading network header: %w", err)
+	}
+
+	if string(header.Magic[:]) != NNUEMagic {
+		return nil, errors.New("not a network file")
+	}
+
+	if header.Version != NNUEVersion {
+		return nil, fmt.Errorf("unsupported network version %d", header.Version)
+	}
+
+	if header.InputSize != NNUEInputSize || header.HiddenSize != NNUEHiddenSize {
+		return nil, fmt.Errorf(
+			"unsupported network architecture %dx%d, expected %dx%d",
+			header.InputSize, header.HiddenSize, NNUEInputSize, NNUEHiddenSize,
+		)
+	}
+
+	network := &Network{}
+	if err := binary.Read(reader, binary.LittleEndian, network); err != nil {
+		return nil, fmt.Errorf("reading network weights: %w", err)
+	}
+
+	// Make sure the file doesn't have any extra data, since that likely
+	// means it was created for a different architecture.
+	if n, _ := reader.Read(make([]byte, 1)); n != 0 {
+		return nil, errors.New("unexpected data after network weights")
+	}
+
+	return network, nil
+}
+
+// Write the network in the format described in docs/nnue.md.
+func (network *Network) Write(writer io.Writer) error {
+	header := struct {
+		Magic      [4]byte
+		Version    uint32
+		InputSize  uint32
+		HiddenSize uint32
+	}{Version: NNUEVersion, InputSize: NNUEInputSize, HiddenSize: NNUEHiddenSize}
+	copy(header.Magic[:], NNUEMagic)
+
+	if err := binary.Write(writer, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(writer, binary.LittleEndian, network)
+}
+
+// Set the network used for evaluation. A nil network
+// means the hand-crafted evaluation is always used.
+func SetNetwork(network *Network) {
+	nnueNetwork = network
+	nnueActive = useNNUE && nnueNetwork != nil
+}
+
+// Set whether the network should be used for evaluation,
+// if one has been loaded.
+func SetUseNNUE(use bool) {
+	useNNUE = use
+	nnueActive = useNNUE && nnueNetwork != nil
+}
+
+// Determine if positions are being evaluated using the network.
+func NNUEActive() bool {
+	return nnueActive
+}
+
+// Get the index of the input feature for a piece on a square, from the
+// perspective of the given side. Squares are flipped for black, so each
+// side sees their own pieces as if they were playing white.
+func nnueFeature(perspective, pieceType, pieceColor, sq uint8) int {
+	relativeColor := 0
+	if pieceColor != perspective {
+		relativeColor = 1
+	}
+
+	relativeSq := sq
+	if perspective == Black {
+		relativeSq ^= 56
+	}
+
+	return (relativeColor*6+int(pieceType))*64 + int(relativeSq)
+}
+
+// The accumulators of the hidden layer of the network, from the perspective of
+// each side.
+type Accumulators [2][NNUEHiddenSize]int16
+
+// Recompute the accumulators of the position from scratch.
+func (pos *Position) RefreshAccumulators() {
+	if !nnueActive {
+		return
+	}
+
+	if pos.accumulators == nil {
+		pos.accumulators = new(Accumulators)
+	}
+
+	pos.accumulators[White] = nnueNetwork.FeatureBiases
+	pos.accumulators[Black] = nnueNetwork.FeatureBiases
+
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		if piece.Type != NoType {
+			pos.addFeature(piece.Type, piece.Color, sq)
+		}
+	}
+}
+
+// Copy the given position into this one, giving it its own copy of the
+// accumulators of the given position, if it has any, so the two positions
+// can be updated independently.
+func (pos *Position) copyFrom(other *Position) {
+	accumulators := pos.accumulators
+	*pos = *other
+
+	if other.accumulators != nil {
+		if accumulators == nil || accumulators == other.accumulators {
+			accumulators = new(Accumulators)
+		}
+		*accumulators = *other.accumulators
+		pos.accumulators = accumulators
+	}
+}
+
+// Update the accumulators of the position for a piece being added.
+func (pos *Position) addFeature(pieceType, pieceColor, sq uint8) {
+	whiteWeights := &nnueNetwork.FeatureWeights[nnueFeature(White, pieceType, pieceColor, sq)]
+	blackWeights := &nnueNetwork.FeatureWeights[nnueFeature(Black, pieceType, pieceColor, sq)]
+
+	for index := 0; index < NNUEHiddenSize; index++ {
+		pos.accumulators[White][index] += whiteWeights[index]
+		pos.accumulators[Black][index] += blackWeights[index]
+	}
+}
+
+// Update the accumulators of the position for a piece being removed.
+func (pos *Position) removeFeature(pieceType, pieceColor, sq uint8) {
+	whiteWeights := &nnueNetwork.FeatureWeights[nnueFeature(White, pieceType, pieceColor, sq)]
+	blackWeights := &nnueNetwork.FeatureWeights[nnueFeature(Black, pieceType, pieceColor, sq)]
+
+	for index := 0; index < NNUEHiddenSize; index++ {
+		pos.accumulators[White][index] -= whiteWeights[index]
+		pos.accumulators[Black][index] -= blackWeights[index]
+	}
+}
+
+// Evaluate a position using the network, from the perspective of the side to move.
+func evaluateNNUE(pos *Position) int16 {
+	us := &pos.accumulators[pos.SideToMove]
+	them := &pos.accumulators[pos.SideToMove^1]
+	weights := &nnueNetwork.OutputWeights
+
+	output := int64(0)
+	for index := 0; index < NNUEHiddenSize; index++ {
+		output += clippedReLU(us[index]) * int64(weights[index])
+		output += clippedReLU(them[index]) * int64(weights[NNUEHiddenSize+index])
+	}
+
+	output = (output + int64(nnueNetwork.OutputBias)) * NNUEScale / (NNUEQA * NNUEQB)
+
+	// Keep the score out of the range of tablebase and checkmate scores.
+	return int16(max(-int64(TBWinScore)+1, Min(output, int64(TBWinScore)-1)))
+}
+
+// Clamp a value of the hidden layer between zero and one (scaled by NNUEQA).
+func clippedReLU(value int16) int64 {
+	return int64(max(0, Min(value, NNUEQA)))
+}
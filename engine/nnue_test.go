@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// nnue_test.go provides tests to ensure networks are loaded correctly, and
+// that the accumulators of a position are updated correctly as moves are
+// made and unmade.
+
+var NNUETestPositions []string = []string{
+	"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+	"r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1",
+	"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+}
+
+// Create a network with random weights and biases.
+func newRandomNetwork(seed int64) *Network {
+	random := rand.New(rand.NewSource(seed))
+	network := &Network{}
+
+	for feature := range network.FeatureWeights {
+		for index := range network.FeatureWeights[feature] {
+			network.FeatureWeights[feature][index] = int16(random.Intn(129) - 64)
+		}
+	}
+
+	for index := range network.FeatureBiases {
+		network.FeatureBiases[index] = int16(random.Intn(257) - 128)
+	}
+
+	for index := range network.OutputWeights {
+		network.OutputWeights[index] = int16(random.Intn(129) - 64)
+	}
+
+	network.OutputBias = int16(random.Intn(1025) - 512)
+	return network
+}
+
+func TestNNUELoading(t *testing.T) {
+	network := newRandomNetwork(1)
+
+	var buffer bytes.Buffer
+	if err := network.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+
+	loaded, err := ReadNetwork(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read a written network: %v", err)
+	}
+
+	if *loaded != *network {
+		t.Errorf("The network read back in doesn't match the network written out")
+	}
+
+	corrupt := func(offset int, value byte) []byte {
+		corrupted := append([]byte{}, data...)
+		corrupted[offset] = value
+		return corrupted
+	}
+
+	invalidFiles := map[string][]byte{
+		"bad magic":        corrupt(0, 'X'),
+		"bad version":      corrupt(4, NNUEVersion+1),
+		"bad architecture": corrupt(13, 2),
+		"truncated header": data[:10],
+		"truncated data":   data[:len(data)-1],
+		"trailing data":    append(append([]byte{}, data...), 0),
+	}
+
+	for name, file := range invalidFiles {
+		if _, err := ReadNetwork(bytes.NewReader(file)); err == nil {
+			t.Errorf("Reading a network with %s should fail", name)
+		}
+	}
+}
+
+// Make sure the accumulators updated incrementally as moves are made and unmade
+// match the accumulators computed from scratch.
+func TestNNUEAccumulators(t *testing.T) {
+	SetNetwork(newRandomNetwork(2))
+	SetUseNNUE(true)
+	defer SetNetwork(nil)
+	defer SetUseNNUE(false)
+
+	var pos Position
+	for _, fen := range NNUETestPositions {
+		pos.LoadFEN(fen)
+
+		var expected Position
+		expected.copyFrom(&pos)
+		expected.RefreshAccumulators()
+		if *pos.accumulators != *expected.accumulators {
+			t.Fatalf("Accumulators set incorrectly when loading position %s", fen)
+		}
+
+		checkNNUEAccumulators(t, &pos, 3)
+	}
+}
+
+func checkNNUEAccumulators(t *testing.T, pos *Position, depth uint8) {
+	if depth == 0 {
+		return
+	}
+
+	before := *pos.accumulators
+	moves := genMoves(pos)
+
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		move := moves.Moves[idx]
+		if pos.DoMove(move) {
+			var expected Position
+			expected.copyFrom(pos)
+			expected.RefreshAccumulators()
+			if *pos.accumulators != *expected.accumulators {
+				t.Fatalf("Accumulators updated incorrectly after move %v in position %s", move, pos.GenFEN())
+			}
+
+			checkNNUEAccumulators(t, pos, depth-1)
+		}
+		pos.UndoMove(move)
+
+		if *pos.accumulators != before {
+			t.Fatalf("Accumulators restored incorrectly after undoing move %v in position %s", move, pos.GenFEN())
+		}
+	}
+}
+
+// Make sure a position and its mirror, with the colors swapped, are evaluated the same.
+func TestNNUESymmetry(t *testing.T) {
+	SetNetwork(newRandomNetwork(3))
+	SetUseNNUE(true)
+	defer SetNetwork(nil)
+	defer SetUseNNUE(false)
+
+	var pos, mirror Position
+	for _, fen := range NNUETestPositions {
+		pos.LoadFEN(fen)
+		mirror.LoadFEN(mirrorFEN(fen))
+
+		if evaluateNNUE(&pos) != evaluateNNUE(&mirror) {
+			t.Errorf(
+				"Position %s evaluated as %d, but its mirror was evaluated as %d",
+				fen, evaluateNNUE(&pos), evaluateNNUE(&mirror),
+			)
+		}
+	}
+}
+
+// Make sure the helpers of a search with several threads update their own copies of the
+// accumulators, and leave those of the main search's position as they were.
+func TestNNUELazySMP(t *testing.T) {
+	SetNetwork(newRandomNetwork(4))
+	SetUseNNUE(true)
+	defer SetNetwork(nil)
+	defer SetUseNNUE(false)
+
+	search := Search{Silent: true}
+	search.TT.Resize(1, SearchEntrySize)
+	search.SetThreads(3)
+
+	for _, fen := range NNUETestPositions {
+		search.Setup(fen)
+		search.Timer.Setup(InfiniteTime, NoValue, NoValue, int16(NoValue), 4, math.MaxUint64)
+		search.Search()
+
+		expected := Position{}
+		expected.LoadFEN(fen)
+		if *search.Pos.accumulators != *expected.accumulators {
+			t.Fatalf("Expected the accumulators of %s to be unchanged after the search", fen)
+		}
+	}
+}
+
+// Flip the board of a FEN string vertically, and swap the colors of the pieces.
+func mirrorFEN(fen string) string {
+	fields := bytes.Fields([]byte(fen))
+	ranks := bytes.Split(fields[0], []byte("/"))
+	for i, j := 0, len(ranks)-1; i < j; i, j = i+1, j-1 {
+		ranks[i], ranks[j] = ranks[j], ranks[i]
+	}
+
+	swapCase := func(field []byte) []byte {
+		return bytes.Map(func(char rune) rune {
+			if char >= 'a' && char <= 'z' {
+				return char - 'a' + 'A'
+			} else if char >= 'A' && char <= 'Z' {
+				return char - 'A' + 'a'
+			}
+			return char
+		}, field)
+	}
+
+	fields[0] = swapCase(bytes.Join(ranks, []byte("/")))
+	if fields[1][0] == 'w' {
+		fields[1] = []byte("b")
+	} else {
+		fields[1] = []byte("w")
+	}
+	fields[2] = swapCase(fields[2])
+
+	if fields[3][0] != '-' {
+		fields[3] = []byte{fields[3][0], '9' - fields[3][1] + '0'}
+	}
+
+	return string(bytes.Join(fields, []byte(" ")))
+}
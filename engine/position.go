@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"math/bits"
 	"strconv"
 	"strings"
 	"unicode"
@@ -36,6 +37,10 @@ const (
 	BlackKingsideRight  uint8 = 0x2
 	BlackQueensideRight uint8 = 0x1
 
+	// Constants representing the two sides of the board a king can castle to.
+	Kingside  uint8 = 0
+	Queenside uint8 = 1
+
 	// Constants mapping each board coordinate to its square
 	A1, B1, C1, D1, E1, F1, G1, H1 = 0, 1, 2, 3, 4, 5, 6, 7
 	A2, B2, C2, D2, E2, F2, G2, H2 = 8, 9, 10, 11, 12, 13, 14, 15
@@ -54,28 +59,10 @@ const (
 	FENKiwiPete      = "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"
 )
 
-// A 64 element array where each entry, when bitwise ANDed with the
-// castling rights, destorys the correct bit in the castling rights
-// if a move to or from that square would take away castling rights.
-var Spoilers = [64]uint8{
-	0xb, 0xf, 0xf, 0xf, 0x3, 0xf, 0xf, 0x7,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf,
-	0xe, 0xf, 0xf, 0xf, 0xc, 0xf, 0xf, 0xd,
-}
-
-// An array mapping a castling kings destination square,
-// to the origin and destination square of the appropriate
-// rook to move.
-var CastlingRookSq = map[uint8]RookCastlePositions{
-	G1: {H1, F1},
-	C1: {A1, D1},
-	G8: {H8, F8},
-	C8: {A8, D8},
+// A constant mapping each color to its kingside and queenside castling rights.
+var SideCastlingRights = [2][2]uint8{
+	Black: {Kingside: BlackKingsideRight, Queenside: BlackQueensideRight},
+	White: {Kingside: WhiteKingsideRight, Queenside: WhiteQueensideRight},
 }
 
 // A constant mapping piece characters to Piece objects.
@@ -105,12 +92,6 @@ var PieceTypeToChar = map[uint8]rune{
 	NoType: '.',
 }
 
-// A struct representing the from and to squares of a given castling rook.
-type RookCastlePositions struct {
-	FromSq uint8
-	ToSq   uint8
-}
-
 // A struct representing a piece
 type Piece struct {
 	Type  uint8
@@ -146,6 +127,24 @@ type Position struct {
 	MGScores [2]int16
 	EGScores [2]int16
 	Phase    int16
+
+	// The accumulators of the network, from the perspective of each side,
+	// which are only allocated and kept updated while the network is being
+	// used, so they don't add to the size of the position. Since copies of
+	// the position share them, a position which is copied to be searched on
+	// its own should be copied using copyFrom.
+	accumulators *Accumulators
+
+	// The data needed for castling, which is setup when a FEN string is loaded.
+	// Since in Chess960 the king and rooks can start on any square of their back
+	// rank, the squares involved in castling aren't fixed. The first three arrays
+	// are indexed by the bit of each castling right, and the last by the castling
+	// rights.
+	castlingRookSqs  [4]uint8
+	castlingEmptyBBs [4]Bitboard
+	castlingPathBBs  [4]Bitboard
+	castlingSpoilers [64]uint8
+	castlingKeys     [16]uint64
 }
 
 // Setup the position using a fen string.
@@ -158,6 +157,13 @@ func (pos *Position) LoadFEN(FEN string) {
 	pos.EGScores = [2]int16{}
 	pos.CastlingRights = 0
 	pos.Phase = TotalPhase
+	if nnueActive {
+		if pos.accumulators == nil {
+			pos.accumulators = new(Accumulators)
+		}
+		pos.accumulators[White] = nnueNetwork.FeatureBiases
+		pos.accumulators[Black] = nnueNetwork.FeatureBiases
+	}
 
 	for square := range pos.Squares {
 		pos.Squares[square] = Piece{Type: NoType, Color: NoColor}
@@ -214,20 +220,43 @@ func (pos *Position) LoadFEN(FEN string) {
 	}
 	pos.Ply = uint16(gamePly)
 
-	// Set the castling rights, for the position.
+	// Set the castling rights for the position. Besides the standard "KQkq" letters,
+	// the file letters of the castling rooks used by X-FEN and Shredder-FEN are
+	// understood, so Chess960 positions can be loaded.
+	pos.castlingRookSqs = [4]uint8{}
 	for _, char := range castling {
-		switch char {
+		color := White
+		if unicode.IsLower(char) {
+			color = Black
+		}
+
+		rookSq := uint8(NoSq)
+		switch unicode.ToUpper(char) {
 		case 'K':
-			pos.CastlingRights |= WhiteKingsideRight
+			rookSq = pos.outermostRook(color, Kingside)
 		case 'Q':
-			pos.CastlingRights |= WhiteQueensideRight
-		case 'k':
-			pos.CastlingRights |= BlackKingsideRight
-		case 'q':
-			pos.CastlingRights |= BlackQueensideRight
+			rookSq = pos.outermostRook(color, Queenside)
+		case 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H':
+			rookSq = backRank(color) + uint8(unicode.ToUpper(char)-'A')
+		}
+
+		kingSq := pos.Pieces[color][King].Msb()
+		if rookSq == NoSq || kingSq == NoSq || rookSq == kingSq {
+			continue
 		}
+
+		wing := Queenside
+		if rookSq > kingSq {
+			wing = Kingside
+		}
+
+		right := SideCastlingRights[color][wing]
+		pos.CastlingRights |= right
+		pos.castlingRookSqs[castlingIndex(right)] = rookSq
 	}
 
+	pos.setupCastling()
+
 	// Generate the zobrist hash for the position...
 	pos.Hash = Zobrist.GenHash(pos)
 }
@@ -286,22 +315,7 @@ func (pos Position) GenFEN() string {
 		sideToMove = "b"
 	}
 
-	if pos.CastlingRights&WhiteKingsideRight != 0 {
-		castlingRights += "K"
-	}
-	if pos.CastlingRights&WhiteQueensideRight != 0 {
-		castlingRights += "Q"
-	}
-	if pos.CastlingRights&BlackKingsideRight != 0 {
-		castlingRights += "k"
-	}
-	if pos.CastlingRights&BlackQueensideRight != 0 {
-		castlingRights += "q"
-	}
-
-	if castlingRights == "" {
-		castlingRights = "-"
-	}
+	castlingRights = pos.castlingString()
 
 	if pos.EPSq == NoSq {
 		epSquare = "-"
@@ -349,19 +363,7 @@ func (pos Position) String() (boardStr string) {
 		boardStr += "turn: black\n"
 	}
 
-	boardStr += "castling rights: "
-	if pos.CastlingRights&WhiteKingsideRight != 0 {
-		boardStr += "K"
-	}
-	if pos.CastlingRights&WhiteQueensideRight != 0 {
-		boardStr += "Q"
-	}
-	if pos.CastlingRights&BlackKingsideRight != 0 {
-		boardStr += "k"
-	}
-	if pos.CastlingRights&BlackQueensideRight != 0 {
-		boardStr += "q"
-	}
+	boardStr += "castling rights: " + pos.castlingString()
 
 	boardStr += "\nen passant: "
 	if pos.EPSq == NoSq {
@@ -377,6 +379,110 @@ func (pos Position) String() (boardStr string) {
 	return boardStr
 }
 
+// Get the square of the rook furthest from the king on the given side of the
+// board, on the back rank of the given color. This is the rook castling rights
+// given as "K", "Q", "k", or "q" in FEN strings belong to.
+func (pos *Position) outermostRook(color, wing uint8) uint8 {
+	kingSq := pos.Pieces[color][King].Msb()
+	rookSq := uint8(NoSq)
+
+	for file := uint8(0); file < 8; file++ {
+		sq := backRank(color) + file
+		isOnWing := (wing == Kingside && sq > kingSq) || (wing == Queenside && sq < kingSq)
+		if isOnWing && pos.Squares[sq] == (Piece{Type: Rook, Color: color}) {
+			rookSq = sq
+			if wing == Queenside {
+				break
+			}
+		}
+	}
+
+	return rookSq
+}
+
+// Setup the squares involved in castling for each castling right
+// the position has.
+func (pos *Position) setupCastling() {
+	pos.castlingEmptyBBs = [4]Bitboard{}
+	pos.castlingPathBBs = [4]Bitboard{}
+
+	for sq := range pos.castlingSpoilers {
+		pos.castlingSpoilers[sq] = 0xf
+	}
+
+	for color := Black; color <= White; color++ {
+		for _, right := range SideCastlingRights[color] {
+			if pos.CastlingRights&right == 0 {
+				continue
+			}
+
+			index := castlingIndex(right)
+			kingSq := pos.Pieces[color][King].Msb()
+			rookSq := pos.castlingRookSqs[index]
+			kingTo, rookTo := castlingSquares(kingSq, rookSq)
+
+			// Every square the king and rook travel over must be empty, besides the squares
+			// they're on, and every square the king travels over must not be attacked.
+			kingPath := rankSegment(kingSq, kingTo)
+			rookPath := rankSegment(rookSq, rookTo)
+			pos.castlingEmptyBBs[index] = (kingPath | rookPath) & ^(SquareBB[kingSq] | SquareBB[rookSq])
+			pos.castlingPathBBs[index] = kingPath
+
+			// Moving the king takes away both of its castling rights, and moving
+			// or capturing a rook takes away the castling right it belongs to.
+			pos.castlingSpoilers[kingSq] &= ^right
+			pos.castlingSpoilers[rookSq] &= ^right
+		}
+	}
+
+	for castlingRights := range pos.castlingKeys {
+		pos.castlingKeys[castlingRights] = Zobrist.CastlingNumber(uint8(castlingRights), pos.castlingRookSqs)
+	}
+}
+
+// Get the string representation of the castling rights of the position. If a
+// castling right doesn't belong to the outermost rook on its side of the board,
+// which is only possible in Chess960, the file of the rook is used instead of
+// "K" or "Q", as is done in X-FEN.
+func (pos *Position) castlingString() (castlingRights string) {
+	for _, color := range [2]uint8{White, Black} {
+		for wing, right := range SideCastlingRights[color] {
+			if pos.CastlingRights&right == 0 {
+				continue
+			}
+
+			rookSq := pos.castlingRookSqs[castlingIndex(right)]
+			char := 'K'
+			if uint8(wing) == Queenside {
+				char = 'Q'
+			}
+
+			if rookSq != pos.outermostRook(color, uint8(wing)) {
+				char = rune('A' + FileOf(rookSq))
+			}
+
+			if color == Black {
+				char = unicode.ToLower(char)
+			}
+			castlingRights += string(char)
+		}
+	}
+
+	if castlingRights == "" {
+		castlingRights = "-"
+	}
+	return castlingRights
+}
+
+// Create the castling move for the given color and side of the board. Castling
+// moves are encoded as the king capturing its own rook, so they can be represented
+// the same way in standard chess and Chess960.
+func (pos *Position) castlingMove(color, wing uint8) Move {
+	kingSq := pos.Pieces[color][King].Msb()
+	rookSq := pos.castlingRookSqs[castlingIndex(SideCastlingRights[color][wing])]
+	return NewMove(kingSq, rookSq, Castle, NoFlag)
+}
+
 // Given a move, create a copy of the current position with the move
 // applied, and return whether or not the new position is valid.
 func (pos *Position) DoMove(move Move) (isValid bool) {
@@ -405,6 +511,12 @@ func (pos *Position) DoMove(move Move) (isValid bool) {
 	pos.Hash ^= Zobrist.EPNumber(pos.EPSq)
 	pos.EPSq = NoSq
 
+	// A castling move is encoded as the king capturing its own rook, but
+	// of course nothing is actually captured.
+	if moveType == Castle {
+		state.Captured = Piece{Type: NoType, Color: NoColor}
+	}
+
 	// Clear the moving piece from its origin square
 	pos.zobristClearPiece(from)
 
@@ -432,12 +544,12 @@ func (pos *Position) DoMove(move Move) (isValid bool) {
 		// Reset the fifty move rule counter
 		pos.Rule50 = 0
 	case Castle:
-		// If the move is a castle, move the king to the appropriate square...
-		pos.zobristPutPiece(state.Moved.Type, pos.SideToMove, to)
-
-		// And move the correct rook.
-		rookFrom, rookTo := CastlingRookSq[to].FromSq, CastlingRookSq[to].ToSq
-		pos.zobristClearPiece(rookFrom)
+		// If the move is a castle, clear the rook from its square before putting
+		// the king and rook on their destination squares, since in Chess960 the
+		// king and rook can end up on each other's squares.
+		kingTo, rookTo := castlingSquares(from, to)
+		pos.zobristClearPiece(to)
+		pos.zobristPutPiece(King, pos.SideToMove, kingTo)
 		pos.zobristPutPiece(Rook, pos.SideToMove, rookTo)
 	case Promotion:
 		// If a pawn is promoting, check if it's capturing a piece,
@@ -469,11 +581,11 @@ func (pos *Position) DoMove(move Move) (isValid bool) {
 	}
 
 	// Remove the current castling rights.
-	pos.Hash ^= Zobrist.CastlingNumber(pos.CastlingRights)
+	pos.Hash ^= pos.castlingKeys[pos.CastlingRights]
 
 	// Update the castling rights and the zobrist hash with the new castling rights.
-	pos.CastlingRights = pos.CastlingRights & Spoilers[from] & Spoilers[to]
-	pos.Hash ^= Zobrist.CastlingNumber(pos.CastlingRights)
+	pos.CastlingRights = pos.CastlingRights & pos.castlingSpoilers[from] & pos.castlingSpoilers[to]
+	pos.Hash ^= pos.castlingKeys[pos.CastlingRights]
 
 	// Update the zobrist hash if the en passant square was set
 	pos.Hash ^= Zobrist.EPNumber(pos.EPSq)
@@ -514,6 +626,18 @@ func (pos *Position) UndoMove(move Move) {
 	moveType := move.MoveType()
 	flag := move.Flag()
 
+	// If the move was a castle, clear the king and rook from their destination
+	// squares before putting them back, since in Chess960 the king and rook
+	// can end up on each other's squares.
+	if moveType == Castle {
+		kingTo, rookTo := castlingSquares(from, to)
+		pos.clearPiece(kingTo)
+		pos.clearPiece(rookTo)
+		pos.putPiece(King, pos.SideToMove, from)
+		pos.putPiece(Rook, pos.SideToMove, to)
+		return
+	}
+
 	// Put the moving piece back on it's orgin square
 	pos.putPiece(state.Moved.Type, state.Moved.Color, from)
 
@@ -535,14 +659,6 @@ func (pos *Position) UndoMove(move Move) {
 			pos.clearPiece(to)
 			pos.putPiece(state.Captured.Type, state.Captured.Color, to)
 		}
-	case Castle:
-		// If the move was a castle, clear the king from the destination square...
-		pos.clearPiece(to)
-
-		// and move the castled rook back to the right square.
-		rookFrom, rookTo := CastlingRookSq[to].FromSq, CastlingRookSq[to].ToSq
-		pos.clearPiece(rookTo)
-		pos.putPiece(Rook, pos.SideToMove, rookFrom)
 	case Promotion:
 		// If the pawn was promoted, remove the promoted piece, and if
 		// the promotion was a capture, put the captured piece back on
@@ -618,6 +734,17 @@ func (pos *Position) NoMajorsOrMiniors() bool {
 	return knights+bishops+rook+queen == 0
 }
 
+// Determine if neither side has enough material left to checkmate,
+// which is the case when only the kings and at most one minor are left.
+func (pos *Position) InsufficientMaterial() bool {
+	pawns := pos.Pieces[White][Pawn].CountBits() + pos.Pieces[Black][Pawn].CountBits()
+	knights := pos.Pieces[White][Knight].CountBits() + pos.Pieces[Black][Knight].CountBits()
+	bishops := pos.Pieces[White][Bishop].CountBits() + pos.Pieces[Black][Bishop].CountBits()
+	rooks := pos.Pieces[White][Rook].CountBits() + pos.Pieces[Black][Rook].CountBits()
+	queens := pos.Pieces[White][Queen].CountBits() + pos.Pieces[Black][Queen].CountBits()
+	return pawns+rooks+queens == 0 && knights+bishops <= 1
+}
+
 // Determine if a move is pseduo-legally valid.
 func (pos *Position) MoveIsPseduoLegal(move Move) bool {
 	fromSq, toSq := move.FromSq(), move.ToSq()
@@ -628,6 +755,19 @@ func (pos *Position) MoveIsPseduoLegal(move Move) bool {
 	allBB := pos.Sides[White] | pos.Sides[Black]
 	sideToMove := pos.SideToMove
 
+	// Castling moves are encoded as the king capturing its own rook, so
+	// check them against the castling moves that can be generated.
+	if move.MoveType() == Castle {
+		castlingMoves := MoveList{}
+		genCastlingMoves(pos, &castlingMoves)
+		for index := uint8(0); index < castlingMoves.Count; index++ {
+			if castlingMoves.Moves[index].Equal(move) {
+				return true
+			}
+		}
+		return false
+	}
+
 	if moved.Color != sideToMove ||
 		captured.Type == King ||
 		captured.Color == sideToMove {
@@ -672,6 +812,10 @@ func (pos *Position) putPiece(pieceType, pieceColor, to uint8) {
 	pos.MGScores[pieceColor] += PieceValueMG[pieceType] + PSQT_MG[pieceType][FlipSq[pieceColor][to]]
 	pos.EGScores[pieceColor] += PieceValueEG[pieceType] + PSQT_EG[pieceType][FlipSq[pieceColor][to]]
 	pos.Phase -= PhaseValues[pieceType]
+
+	if nnueActive {
+		pos.addFeature(pieceType, pieceColor, to)
+	}
 }
 
 // Clear a piece of a given color and type from a square.
@@ -684,6 +828,10 @@ func (pos *Position) clearPiece(from uint8) {
 	pos.EGScores[piece.Color] -= PieceValueEG[piece.Type] + PSQT_EG[piece.Type][FlipSq[piece.Color][from]]
 	pos.Phase += PhaseValues[piece.Type]
 
+	if nnueActive {
+		pos.removeFeature(piece.Type, piece.Color, from)
+	}
+
 	piece.Type = NoType
 	piece.Color = NoColor
 }
@@ -700,6 +848,10 @@ func (pos *Position) zobristPutPiece(pieceType, pieceColor, to uint8) {
 	pos.MGScores[pieceColor] += PieceValueMG[pieceType] + PSQT_MG[pieceType][FlipSq[pieceColor][to]]
 	pos.EGScores[pieceColor] += PieceValueEG[pieceType] + PSQT_EG[pieceType][FlipSq[pieceColor][to]]
 	pos.Phase -= PhaseValues[pieceType]
+
+	if nnueActive {
+		pos.addFeature(pieceType, pieceColor, to)
+	}
 }
 
 // Clear the piece given from the given square.
@@ -712,11 +864,49 @@ func (pos *Position) zobristClearPiece(from uint8) {
 	pos.EGScores[piece.Color] -= PieceValueEG[piece.Type] + PSQT_EG[piece.Type][FlipSq[piece.Color][from]]
 	pos.Phase += PhaseValues[piece.Type]
 
+	if nnueActive {
+		pos.removeFeature(piece.Type, piece.Color, from)
+	}
+
 	pos.Hash ^= Zobrist.PieceNumber(piece.Type, piece.Color, from)
 	piece.Type = NoType
 	piece.Color = NoColor
 }
 
+// Given a king and the rook it's castling with, return the destination squares
+// of the king and rook. Regardless of where they start, after castling kingside
+// the king and rook end up on the g and f files, and after castling queenside
+// they end up on the c and d files.
+func castlingSquares(kingSq, rookSq uint8) (kingTo, rookTo uint8) {
+	rankStart := kingSq - FileOf(kingSq)
+	if rookSq > kingSq {
+		return rankStart + G1, rankStart + F1
+	}
+	return rankStart + C1, rankStart + D1
+}
+
+// Given a castling right, return the index of its bit.
+func castlingIndex(right uint8) uint8 {
+	return uint8(bits.TrailingZeros8(right))
+}
+
+// Given a color, return the first square of its back rank.
+func backRank(color uint8) uint8 {
+	if color == White {
+		return A1
+	}
+	return A8
+}
+
+// Get a bitboard of the squares on a rank from one square to
+// another, including both squares.
+func rankSegment(sq1, sq2 uint8) (segment Bitboard) {
+	for sq := Min(sq1, sq2); sq <= max(sq1, sq2); sq++ {
+		segment.SetBit(sq)
+	}
+	return segment
+}
+
 // Given a color, return the delta for a single pawn push for that
 // color.
 func getPawnPushDelta(color uint8) int8 {
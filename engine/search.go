@@ -3,6 +3,8 @@ package engine
 import (
 	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -54,6 +56,13 @@ const (
 	SingularMoveMargin              int16 = 125
 	SingularExtensionDepthLimit     int8  = 4
 	SingularMoveExtension           int8  = 1
+
+	// The maximum number of threads the search can be run with.
+	MaxThreads = 256
+
+	// The maximum number of principal variation lines that can
+	// be searched and reported.
+	MaxMultiPV = 256
 )
 
 // Precomputed reductions
@@ -122,9 +131,23 @@ func (pvLine PVLine) String() string {
 // routines are thus implemented as methods of this struct.
 type Search struct {
 	Pos   Position
-	TT    TransTable[SearchEntry]
+	TT    TransTable[PackedSearchEntry, *PackedSearchEntry]
 	Timer TimeManager
 
+	// The moves the search is restricted to at the root, if any, and
+	// the number of moves of a mate the search should stop after finding,
+	// if any.
+	SearchMoves []Move
+	MateDepth   uint8
+
+	// Whether the search should run without printing any info lines, as when
+	// it's used to play games internally rather than through a GUI.
+	Silent bool
+
+	// A function called after each completed iteration of the search, if any,
+	// with the depth searched, and the best move and score found.
+	IterationHook func(depth uint8, bestMove Move, score int16)
+
 	side              uint8
 	age               uint8
 	totalNodes        uint64
@@ -133,6 +156,33 @@ type Search struct {
 	counter           [2][64][64]Move
 	zobristHistory    [MaxGamePly]uint64
 	zobristHistoryPly uint16
+
+	// The helper searches used for Lazy SMP. Each helper shares the
+	// transposition table with the main search, but keeps its own
+	// killer, history, and counter move tables.
+	helpers []*Search
+
+	// The number of nodes searched, reported every 2048 nodes, so it can be
+	// read by the main search while a helper is running. It's only accessed
+	// atomically, like the number of tablebase hits of a helper.
+	reportedNodes uint64
+
+	// The number of principal variation lines to search, and the moves
+	// excluded from the root while searching the current line.
+	multiPV           int
+	excludedRootMoves []Move
+
+	// The reply to the best move we expect to be played, and the score of the
+	// best move, found by the last search.
+	ponderMove Move
+	bestScore  int16
+
+	// The number of positions found in the tablebases, the largest number of pieces
+	// a position can have to be probed during the search, and the root moves left
+	// after ranking them with the tablebases, if the root position was in them.
+	tbHits        uint64
+	tbCardinality int
+	tbRootMoves   []Move
 }
 
 // Setup the necessary internals of the engine when given a new FEN string.
@@ -147,9 +197,53 @@ func (search *Search) Setup(FEN string) {
 // "ucinewgame" command is sent.
 func (search *Search) Reset() {
 	search.TT.Clear()
+	search.ResetHeuristics()
+}
+
+// Reset the move ordering heuristics of the search and its helpers,
+// while keeping the transposition table.
+func (search *Search) ResetHeuristics() {
 	search.ClearKillers()
 	search.ClearHistoryTable()
 	search.ClearCounterMoves()
+
+	for _, helper := range search.helpers {
+		helper.ClearKillers()
+		helper.ClearHistoryTable()
+		helper.ClearCounterMoves()
+	}
+}
+
+// Set the number of threads to use while searching. One thread is the main
+// search, and every other thread is a helper search.
+func (search *Search) SetThreads(numThreads int) {
+	numThreads = max(1, Min(numThreads, MaxThreads))
+	search.helpers = nil
+
+	for i := 1; i < numThreads; i++ {
+		search.helpers = append(search.helpers, &Search{})
+	}
+}
+
+// Get the total number of nodes searched by the main search and its
+// helpers. While the helpers are running, their counts are the ones
+// they last reported.
+func (search *Search) nodeCount() uint64 {
+	nodes := search.totalNodes
+	for _, helper := range search.helpers {
+		nodes += atomic.LoadUint64(&helper.reportedNodes)
+	}
+	return nodes
+}
+
+// Get the total number of tablebase hits of the main search
+// and its helpers.
+func (search *Search) tbHitCount() uint64 {
+	tbHits := atomic.LoadUint64(&search.tbHits)
+	for _, helper := range search.helpers {
+		tbHits += atomic.LoadUint64(&helper.tbHits)
+	}
+	return tbHits
 }
 
 // Add a zobrist hash to the history.
@@ -167,87 +261,412 @@ func (search *Search) RemoveHistory() {
 // deepening loop.
 func (search *Search) Search() Move {
 	search.side = search.Pos.SideToMove
+	search.bestScore = 0
 	search.totalNodes = 0
+	search.tbHits = 0
 	search.age ^= 1
 
-	pvLine := PVLine{}
+	search.probeRootTBs()
+
+	// If there are no moves to search, we've been checkmated or stalemated,
+	// so there's no best move to find.
+	rootMoves := search.rootMoves()
+	if len(rootMoves) == 0 {
+		if search.Pos.InCheck() {
+			search.bestScore = -Inf
+		}
+		search.tbRootMoves = nil
+		search.ponderMove = NullMove
+		return NullMove
+	}
+
+	// Don't try to search more lines than there are legal moves in the position.
+	numPVs := Min(search.MultiPV(), len(rootMoves))
+
+	pvLines := make([]PVLine, numPVs)
+	scores := make([]int16, numPVs)
 	bestMove := NullMove
 
 	timeExtended := false
 	totalTime := int64(0)
 	depth := uint8(0)
-	alpha := -Inf
-	beta := Inf
+
+	// Each line keeps its own aspiration window, since the scores of the
+	// lines can be far apart from each other.
+	alphas := make([]int16, numPVs)
+	betas := make([]int16, numPVs)
+	for pvIndex := range alphas {
+		alphas[pvIndex] = -Inf
+		betas[pvIndex] = Inf
+	}
 
 	search.ageHistoryTable()
 	search.Timer.Start(search.Pos.Ply)
 
+	helpersGroup := search.startHelpers()
+
 	for depth = 1; depth <= MaxDepth &&
 		depth <= search.Timer.MaxDepth &&
 		search.Timer.MaxNodeCount > 0; depth++ {
 
-		pvLine.Clear()
+		// Search each line with the best moves of the previous lines excluded
+		// from the root, so every line found has a different first move.
+		search.excludedRootMoves = search.excludedRootMoves[:0]
 
-		startTime := time.Now()
-		score := search.negamax(int8(depth), 0, alpha, beta, &pvLine, true, NullMove, NullMove, false)
-		endTime := time.Since(startTime)
+		for pvIndex := 0; pvIndex < numPVs && !search.Timer.Stopped(); pvIndex++ {
+			pvLine := PVLine{}
+
+			for {
+				startTime := time.Now()
+				score := search.negamax(int8(depth), 0, alphas[pvIndex], betas[pvIndex], &pvLine, true, NullMove, NullMove, false)
+				endTime := time.Since(startTime)
+
+				if search.Timer.Stopped() {
+					if bestMove == NullMove && pvIndex == 0 && len(pvLine.Moves) > 0 {
+						bestMove = pvLine.GetPVMove()
+					}
+					break
+				}
+
+				// ========================================================================//
+				// ASPIRATION WINDOWS: Many times, the scores returned between iterations  //
+				// are close to each other. So to achieve more beta-cutoffs and speed up   //
+				// the search, we can use a window centered around the score of the last   //
+				// iterations value instead of (-INF, INF). When the score returned from a //
+				// search with a narrow window is outside of the window, we need to do a   //
+				// research to make sure we're getting the true score. However, if the     //
+				// window size is picked well, this should rare enough to where the        //
+				// benefits of a quicker search easily outweigh the few extra searches.    //
+				// ========================================================================//
+
+				if score <= alphas[pvIndex] || score >= betas[pvIndex] {
+					alphas[pvIndex] = -Inf
+					betas[pvIndex] = Inf
+					pvLine.Clear()
+
+					// If we get a score outside of the bounds we expect,
+					// spend a little more time in the position to make
+					// sure we aren't missing anything.
+					if depth >= 6 && !timeExtended {
+						search.Timer.Update(search.Timer.TimeForMove * 13 / 10)
+
+						// Make sure we don't repeatedly keep extending the search time if
+						// we encounter subsequent fail lows at the root. Extending the search
+						// time once is enough.
+						timeExtended = true
+					}
+
+					continue
+				}
+
+				alphas[pvIndex] = score - WindowSize
+				betas[pvIndex] = score + WindowSize
 
-		if search.Timer.Stop {
-			if bestMove == NullMove && depth == 1 {
-				bestMove = pvLine.GetPVMove()
+				totalTime += endTime.Milliseconds()
+				pvLines[pvIndex] = pvLine
+				scores[pvIndex] = score
+				break
+			}
+
+			if !search.Timer.Stopped() {
+				search.excludedRootMoves = append(search.excludedRootMoves, pvLine.GetPVMove())
 			}
-			break
 		}
 
-		// ========================================================================//
-		// ASPIRATION WINDOWS: Many times, the scores returned between iterations  //
-		// are close to each other. So to achieve more beta-cutoffs and speed up   //
-		// the search, we can use a window centered around the score of the last   //
-		// iterations value instead of (-INF, INF). When the score returned from a //
-		// search with a narrow window is outside of the window, we need to do a   //
-		// research to make sure we're getting the true score. However, if the     //
-		// window size is picked well, this should rare enough to where the        //
-		// benefits of a quicker search easily outweigh the few extra searches.    //
-		// ========================================================================//
+		if search.Timer.Stopped() {
+			break
+		}
 
-		if score <= alpha || score >= beta {
-			alpha = -Inf
-			beta = Inf
-			depth--
+		bestMove = pvLines[0].GetPVMove()
+		search.bestScore = scores[0]
 
-			// If we get a score outside of the bounds we expect,
-			// spend a little more time in the position to make
-			// sure we aren't missing anything.
-			if depth >= 6 && !timeExtended {
-				search.Timer.Update(search.Timer.TimeForMove * 13 / 10)
+		if !search.Silent {
+			search.printInfo(depth, pvLines, scores, totalTime)
+		}
 
-				// Make sure we don't repeatedly keep extending the search time if
-				// we encounter subsequent fail lows at the root. Extending the search
-				// time once is enough.
-				timeExtended = true
-			}
+		if search.IterationHook != nil {
+			search.IterationHook(depth, bestMove, scores[0])
+		}
 
-			continue
+		// If we're looking for a mate, and we've found one short enough,
+		// there's no need to keep searching.
+		if search.MateDepth > 0 && scores[0] > Checkmate && (Inf-scores[0]+1)/2 <= int16(search.MateDepth) {
+			break
 		}
+	}
 
-		alpha = score - WindowSize
-		beta = score + WindowSize
+	search.excludedRootMoves = search.excludedRootMoves[:0]
+	search.tbRootMoves = nil
+	search.stopHelpers(helpersGroup)
+	search.ponderMove = search.findPonderMove(bestMove, pvLines[0])
+	return bestMove
+}
 
-		totalTime += endTime.Milliseconds()
+// Print the info line of each principal variation line found by
+// the last iteration of the search.
+func (search *Search) printInfo(depth uint8, pvLines []PVLine, scores []int16, totalTime int64) {
+	totalNodes := search.nodeCount()
+	nps := uint64(float64(totalNodes*1000) / float64(totalTime))
 
-		bestMove = pvLine.GetPVMove()
-		nps := uint64(float64(search.totalNodes*1000) / float64(totalTime))
+	for pvIndex := range pvLines {
+		multiPV := ""
+		if len(pvLines) > 1 {
+			multiPV = fmt.Sprintf(" multipv %d", pvIndex+1)
+		}
 
 		fmt.Printf(
-			"info depth %d score %s nodes %d nps %d time %d pv %s\n",
-			depth, getMateOrCPScore(score),
-			search.totalNodes, nps,
+			"info depth %d%s score %s nodes %d nps %d tbhits %d time %d pv %s\n",
+			depth, multiPV, getMateOrCPScore(scores[pvIndex]),
+			totalNodes, nps, search.tbHitCount(),
 			totalTime,
-			pvLine,
+			pvLines[pvIndex],
 		)
 	}
+}
 
-	return bestMove
+// Get the reply to the best move we expect to be played, found by the last
+// search, which can be used for pondering. If there isn't one, the null move
+// is returned.
+func (search *Search) PonderMove() Move {
+	return search.ponderMove
+}
+
+// Get the score of the best move found by the last search, from the
+// perspective of the side to move.
+func (search *Search) BestScore() int16 {
+	return search.bestScore
+}
+
+// Find the move to ponder on after the best move. Use the principal variation
+// if it's long enough, and otherwise try the transposition table.
+func (search *Search) findPonderMove(bestMove Move, pvLine PVLine) Move {
+	if bestMove == NullMove {
+		return NullMove
+	}
+
+	if len(pvLine.Moves) > 1 && pvLine.Moves[0].Equal(bestMove) {
+		return pvLine.Moves[1]
+	}
+
+	ponderMove := NullMove
+	if !search.Pos.DoMove(bestMove) {
+		search.Pos.UndoMove(bestMove)
+		return NullMove
+	}
+
+	entry := search.TT.Probe(search.Pos.Hash).Load()
+	if entry.Hash == search.Pos.Hash && entry.Best != NullMove {
+		for _, move := range GenLegalMoves(&search.Pos) {
+			if move.Equal(entry.Best) {
+				ponderMove = move
+				break
+			}
+		}
+	}
+
+	search.Pos.UndoMove(bestMove)
+	return ponderMove
+}
+
+// Set the number of principal variation lines to search and report.
+func (search *Search) SetMultiPV(multiPV int) {
+	search.multiPV = max(1, Min(multiPV, MaxMultiPV))
+}
+
+// Get the number of principal variation lines to search and report.
+func (search *Search) MultiPV() int {
+	return max(1, search.multiPV)
+}
+
+// Get the legal moves in the root position, restricted to the moves
+// the search was told to consider, if any.
+func (search *Search) rootMoves() (rootMoves []Move) {
+	for _, move := range GenLegalMoves(&search.Pos) {
+		if search.isSearchMove(move) {
+			rootMoves = append(rootMoves, move)
+		}
+	}
+	return rootMoves
+}
+
+// Check if a move should be skipped at the root, either because it's
+// already been excluded, or because the search was told not to consider it.
+func (search *Search) skipRootMove(move Move) bool {
+	for _, excludedMove := range search.excludedRootMoves {
+		if move.Equal(excludedMove) {
+			return true
+		}
+	}
+	return !search.isSearchMove(move)
+}
+
+// Check if a move is one of the moves the search was told to consider at the root,
+// and one of the best moves according to the tablebases, if they were probed.
+func (search *Search) isSearchMove(move Move) bool {
+	return moveInList(move, search.SearchMoves) && moveInList(move, search.tbRootMoves)
+}
+
+// Check if a move is in a list of moves, counting an empty list
+// as holding every move.
+func moveInList(move Move, moves []Move) bool {
+	if len(moves) == 0 {
+		return true
+	}
+
+	for _, listMove := range moves {
+		if move.Equal(listMove) {
+			return true
+		}
+	}
+	return false
+}
+
+// =====================================================================//
+// TABLEBASE ROOT PROBING: If the root position is in the tablebases,   //
+// rank the root moves using the DTZ tables, and only search the moves  //
+// ranked best, so the result of the position is kept, and a win is     //
+// converted before the fifty-move rule can kick in. Once the root      //
+// moves are filtered, probing the tables during the search isn't       //
+// needed anymore. But if only the WDL tables could be probed, keep     //
+// probing them during the search when we're winning, to help the       //
+// search find the way to a zeroing move.                               //
+// =====================================================================//
+
+// Filter the root moves using the tablebases, and decide if the tablebases
+// should be probed during the search.
+func (search *Search) probeRootTBs() {
+	search.tbRootMoves = nil
+	search.tbCardinality = Syzygy.Cardinality()
+
+	if pieceCount(&search.Pos) > search.tbCardinality || search.Pos.CastlingRights != 0 {
+		return
+	}
+
+	rootMoves := search.rootMoves()
+	bestMoves, usedDTZ, ok := Syzygy.RankRootMoves(&search.Pos, rootMoves, search.hasRepeated())
+	if !ok {
+		return
+	}
+
+	// Count each root move probed as a tablebase hit.
+	search.tbRootMoves = bestMoves
+	search.tbHits = uint64(len(rootMoves))
+
+	if usedDTZ {
+		search.tbCardinality = 0
+		return
+	}
+
+	wdl, ok := Syzygy.ProbeWDL(&search.Pos)
+	if !ok || wdl <= TBDraw {
+		search.tbCardinality = 0
+	}
+}
+
+// Determine if a position has been repeated since the last zeroing move.
+func (search *Search) hasRepeated() bool {
+	start := max(0, int(search.zobristHistoryPly)-int(search.Pos.Rule50))
+	for i := int(search.zobristHistoryPly); i > start; i-- {
+		for j := i - 2; j >= start; j -= 2 {
+			if search.zobristHistory[i] == search.zobristHistory[j] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// =====================================================================//
+// LAZY SMP: Each helper search runs its own iterative deepening loop   //
+// on a copy of the root position, and shares its results with the      //
+// main search through the transposition table. The helpers are never   //
+// used to pick a move directly, but since they fill the table with     //
+// useful entries, the main search is able to reach deeper depths       //
+// quicker. To keep the helpers from all searching the same tree, half  //
+// of them start one ply deeper than the main search.                   //
+// =====================================================================//
+
+// Start each helper search on its own goroutine.
+func (search *Search) startHelpers() *sync.WaitGroup {
+	helpersGroup := &sync.WaitGroup{}
+
+	for i, helper := range search.helpers {
+		helper.Pos.copyFrom(&search.Pos)
+		helper.TT = search.TT
+		helper.age = search.age
+		helper.side = search.side
+		helper.zobristHistory = search.zobristHistory
+		helper.zobristHistoryPly = search.zobristHistoryPly
+		helper.SearchMoves = search.SearchMoves
+		helper.tbCardinality = search.tbCardinality
+		helper.tbRootMoves = search.tbRootMoves
+		helper.totalNodes = 0
+		helper.reportedNodes = 0
+		helper.tbHits = 0
+
+		// The helpers are only stopped by the main search, so
+		// give them an infinite amount of time to search.
+		helper.Timer.Setup(
+			InfiniteTime,
+			NoValue,
+			NoValue,
+			int16(NoValue),
+			search.Timer.MaxDepth,
+			math.MaxUint64,
+		)
+		helper.Timer.Start(helper.Pos.Ply)
+
+		helpersGroup.Add(1)
+		go helper.helperSearch(uint8(1+i%2), helpersGroup)
+	}
+
+	return helpersGroup
+}
+
+// Stop each helper search and wait for them to finish.
+func (search *Search) stopHelpers(helpersGroup *sync.WaitGroup) {
+	for _, helper := range search.helpers {
+		helper.Timer.Stop()
+	}
+
+	helpersGroup.Wait()
+
+	// Drop each helper's reference to the transposition table, so the memory
+	// can be released if the table is resized between searches.
+	for _, helper := range search.helpers {
+		helper.TT = TransTable[PackedSearchEntry, *PackedSearchEntry]{}
+	}
+}
+
+// The iterative deepening loop run by a helper search, starting at the given depth.
+func (search *Search) helperSearch(startDepth uint8, helpersGroup *sync.WaitGroup) {
+	defer helpersGroup.Done()
+	search.ageHistoryTable()
+
+	pvLine := PVLine{}
+	alpha := -Inf
+	beta := Inf
+
+	for depth := startDepth; depth <= MaxDepth && depth <= search.Timer.MaxDepth; depth++ {
+		pvLine.Clear()
+		score := search.negamax(int8(depth), 0, alpha, beta, &pvLine, true, NullMove, NullMove, false)
+
+		if search.Timer.Stopped() {
+			break
+		}
+
+		if score <= alpha || score >= beta {
+			alpha = -Inf
+			beta = Inf
+			depth--
+			continue
+		}
+
+		alpha = score - WindowSize
+		beta = score + WindowSize
+	}
+
+	atomic.StoreUint64(&search.reportedNodes, search.totalNodes)
 }
 
 // Display the correct format for the search score if it's a centipawn score
@@ -279,18 +698,20 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 
 	// Make sure we haven't gone pass the node count limit.
 	if search.totalNodes >= search.Timer.MaxNodeCount {
-		search.Timer.Stop = true
+		search.Timer.Stop()
 	}
 
-	// Every 2048 nodes, check if our time has expired.
+	// Every 2048 nodes, check if our time has expired, and report the number
+	// of nodes searched so far.
 	if (search.totalNodes & 2047) == 0 {
 		search.Timer.Check()
+		atomic.StoreUint64(&search.reportedNodes, search.totalNodes)
 	}
 
 	// If we're told to stop, abort the current search and return 0. This won't
 	// affect anything, as the previous search's best move will be used, and
 	// everything from the current search will be discarded.
-	if search.Timer.Stop {
+	if search.Timer.Stopped() {
 		return 0
 	}
 
@@ -340,7 +761,7 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// a hit, return the score and stop searching.                          //
 	// =====================================================================//
 
-	entry := search.TT.Probe(search.Pos.Hash)
+	entry := search.TT.Probe(search.Pos.Hash).Load()
 	ttScore, shouldUse := entry.Get(search.Pos.Hash, ply, uint8(depth), alpha, beta, &ttMove)
 	ttHit = entry.Hash == search.Pos.Hash
 
@@ -348,6 +769,36 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 		return ttScore
 	}
 
+	// =====================================================================//
+	// TABLEBASE PROBING: If there are few enough pieces left, probe the    //
+	// WDL tables for the result of the position. Since the tables don't    //
+	// know about the fifty-move counter, only probe them right after a     //
+	// zeroing move, where the result they store is exact. Store the        //
+	// result in the transposition table, and stop searching.               //
+	// =====================================================================//
+
+	// Probing the tables makes a few captures on the position, so make sure
+	// there's room to store their states.
+	if !isRoot && search.tbCardinality > 0 && search.Pos.Rule50 == 0 &&
+		search.Pos.CastlingRights == 0 && ply < MaxDepth-TBMaxPieces &&
+		pieceCount(&search.Pos) <= search.tbCardinality {
+
+		if wdl, ok := Syzygy.ProbeWDL(&search.Pos); ok {
+			atomic.AddUint64(&search.tbHits, 1)
+
+			score := search.contempt()
+			if wdl == TBWin {
+				score = TBWinScore - int16(ply)
+			} else if wdl == TBLoss {
+				score = -TBWinScore + int16(ply)
+			}
+
+			entry := search.TT.Store(search.Pos.Hash, uint8(depth), search.age)
+			entry.Set(search.Pos.Hash, score, NullMove, ply, uint8(depth), ExactFlag, search.age)
+			return score
+		}
+	}
+
 	// =====================================================================//
 	// STATIC NULL MOVE PRUNING: If our current material score is so good   //
 	// that even if we give ourselves a big hit materially and subtract a   //
@@ -384,7 +835,7 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 		search.Pos.UndoNullMove()
 		childPVLine.Clear()
 
-		if search.Timer.Stop {
+		if search.Timer.Stopped() {
 			return 0
 		}
 
@@ -458,6 +909,12 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 			continue
 		}
 
+		// Skip the moves at the root which are the first moves of principal
+		// variation lines we've already found, or that we were told not to search.
+		if isRoot && search.skipRootMove(move) {
+			continue
+		}
+
 		if !search.Pos.DoMove(move) {
 			search.Pos.UndoMove(move)
 			continue
@@ -624,7 +1081,10 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	}
 
 	// If we're not out of time, store the result of the search for this position.
-	if !search.Timer.Stop {
+	// But don't store the root position when some of its moves were skipped, since
+	// the best move found might not be the best move of the position.
+	rootMovesSkipped := len(search.excludedRootMoves) > 0 || len(search.SearchMoves) > 0 || len(search.tbRootMoves) > 0
+	if !search.Timer.Stopped() && !(isRoot && rootMovesSkipped) {
 		entry := search.TT.Store(search.Pos.Hash, uint8(depth), search.age)
 		entry.Set(
 			search.Pos.Hash, bestScore, bestMove, ply, uint8(depth), ttFlag, search.age,
@@ -648,14 +1108,15 @@ func (search *Search) Qsearch(alpha, beta int16, maxPly uint8, pvLine *PVLine, p
 	}
 
 	if search.totalNodes >= search.Timer.MaxNodeCount {
-		search.Timer.Stop = true
+		search.Timer.Stop()
 	}
 
 	if (search.totalNodes & 2047) == 0 {
 		search.Timer.Check()
+		atomic.StoreUint64(&search.reportedNodes, search.totalNodes)
 	}
 
-	if search.Timer.Stop {
+	if search.Timer.Stopped() {
 		return 0
 	}
 
@@ -728,7 +1189,7 @@ func (search *Search) Qsearch(alpha, beta int16, maxPly uint8, pvLine *PVLine, p
 // Given a "killer move" (a quiet move that caused a beta cut-off), store the
 // Move in the slot for the given depth.
 func (search *Search) storeKiller(ply uint8, move Move) {
-	if search.Pos.Squares[move.ToSq()].Type == NoType {
+	if search.isQuiet(move) {
 		if !move.Equal(search.killers[ply][0]) {
 			search.killers[ply][1] = search.killers[ply][0]
 			search.killers[ply][0] = move
@@ -736,6 +1197,13 @@ func (search *Search) storeKiller(ply uint8, move Move) {
 	}
 }
 
+// Determine if the given move is quiet, meaning it doesn't capture a piece on its
+// destination square. Castling moves are quiet, even though they're encoded as
+// the king capturing its own rook.
+func (search *Search) isQuiet(move Move) bool {
+	return search.Pos.Squares[move.ToSq()].Type == NoType || move.MoveType() == Castle
+}
+
 // Clear the killer moves table.
 func (search *Search) ClearKillers() {
 	for ply := 0; ply < MaxDepth+1; ply++ {
@@ -747,7 +1215,7 @@ func (search *Search) ClearKillers() {
 // Given a counter move (a move that caused the previous move made to be refuted, i.e.
 // cause a beta-cutoff)
 func (search *Search) storeCounterMove(prevMove, currMove Move) {
-	if search.Pos.Squares[currMove.ToSq()].Type == NoType {
+	if search.isQuiet(currMove) {
 		search.counter[search.Pos.SideToMove][prevMove.FromSq()][prevMove.ToSq()] = currMove
 	}
 }
@@ -764,7 +1232,7 @@ func (search *Search) ClearCounterMoves() {
 
 // Increment the history score for the given move if it caused a beta-cutoff and is quiet.
 func (search *Search) incrementHistoryScore(move Move, depth int8) {
-	if search.Pos.Squares[move.ToSq()].Type == NoType {
+	if search.isQuiet(move) {
 		search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] += int32(depth) * int32(depth)
 	}
 
@@ -775,7 +1243,7 @@ func (search *Search) incrementHistoryScore(move Move, depth int8) {
 
 // Decrement the history score for the given move if it didn't cause a beta-cutoff and is quiet.
 func (search *Search) decrementHistoryScore(move Move) {
-	if search.Pos.Squares[move.ToSq()].Type == NoType {
+	if search.isQuiet(move) {
 		if search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] > 0 {
 			search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] -= 1
 		}
@@ -822,6 +1290,9 @@ func (search *Search) scoreMoves(moves *MoveList, pvMove Move, ply uint8, prevMo
 	for index := uint8(0); index < moves.Count; index++ {
 		move := &moves.Moves[index]
 		capturedType := search.Pos.Squares[move.ToSq()].Type
+		if move.MoveType() == Castle {
+			capturedType = NoType
+		}
 
 		if move.Equal(pvMove) {
 			move.AddScore(MvvLvaOffset + PVMoveScore)
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// search_test.go provides tests to ensure the search finds a legal move when it's
+// run with several threads, and can be stopped from another goroutine. Since the
+// threads share the transposition table and their stop flags, these tests should
+// also be run with the race detector, using "go test -race -run LazySMP ./engine".
+
+func TestLazySMPSearch(t *testing.T) {
+	search := Search{Silent: true}
+	search.TT.Resize(16, SearchEntrySize)
+	search.SetThreads(4)
+
+	for _, fen := range NNUETestPositions {
+		search.Setup(fen)
+		search.Timer.Setup(InfiniteTime, NoValue, NoValue, int16(NoValue), 6, math.MaxUint64)
+
+		bestMove := search.Search()
+		if !moveInList(bestMove, GenLegalMoves(&search.Pos)) || bestMove == NullMove {
+			t.Errorf("Expected a legal move searching %s with 4 threads, got %v", fen, bestMove)
+		}
+
+		if search.nodeCount() <= search.totalNodes {
+			t.Errorf("Expected the helpers to search some nodes in %s", fen)
+		}
+	}
+}
+
+func TestLazySMPStop(t *testing.T) {
+	search := Search{Silent: true}
+	search.TT.Resize(16, SearchEntrySize)
+	search.SetThreads(4)
+	search.Setup(FENStartPosition)
+	search.Timer.Setup(InfiniteTime, NoValue, NoValue, int16(NoValue), MaxDepth, math.MaxUint64)
+
+	done := make(chan Move)
+	go func() { done <- search.Search() }()
+
+	time.Sleep(200 * time.Millisecond)
+	search.Timer.Stop()
+
+	select {
+	case bestMove := <-done:
+		if bestMove == NullMove {
+			t.Error("Expected a best move after stopping the search")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the search to stop after being told to")
+	}
+}
+
+func TestSearchNoLegalMoves(t *testing.T) {
+	search := Search{Silent: true}
+	search.TT.Resize(1, SearchEntrySize)
+	search.SetThreads(2)
+	search.SetMultiPV(3)
+
+	positions := map[string]int16{
+		"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1":                                Draw, // Stalemate.
+		"rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3": -Inf, // Checkmate.
+	}
+
+	for fen, expectedScore := range positions {
+		search.Setup(fen)
+		search.Timer.Setup(InfiniteTime, NoValue, NoValue, int16(NoValue), 3, math.MaxUint64)
+
+		if bestMove := search.Search(); bestMove != NullMove || bestMove.String() != "0000" {
+			t.Errorf("Expected no best move in %s, got %v", fen, bestMove)
+		}
+
+		if search.BestScore() != expectedScore || search.PonderMove() != NullMove {
+			t.Errorf("Expected a score of %d and no ponder move in %s, got %d", expectedScore, fen, search.BestScore())
+		}
+	}
+}
+
+func TestSearchCommandNoLegalMoves(t *testing.T) {
+	for _, fen := range []string{
+		"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1",
+		"rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3",
+	} {
+		if code := RunSearchCommand([]string{"-fen", fen, "-depth", "3"}); code != 0 {
+			t.Errorf("Expected the search command to exit with 0 in %s, got %d", fen, code)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sts.go implements scoring the Strategic Test Suite (STS), a set of 1500 positions
+// split into 15 positional themes, like undermining or knight outposts. Rather than
+// only counting the positions solved, the c0 operation of each position awards points
+// to several moves, usually ten to the best move, and fewer to the other good moves:
+// https://www.chessprogramming.org/Strategic_Test_Suite
+
+// The score of the positions of a single STS theme.
+type STSTheme struct {
+	Number    int
+	Name      string
+	Positions int
+	Score     int
+	MaxScore  int
+}
+
+// The points awarded to a move by the c0 operation of an STS position.
+type STSPoints struct {
+	Move   Move
+	Points int
+}
+
+// Parse the points awarded to each move by the c0 operation of a record, given as
+// "Kg2=10, Kh1=3, Nc2=4". If there's no c0 operation, ten points are awarded to
+// each of the best moves.
+func ParseSTSPoints(record *EPDRecord) (points []STSPoints, err error) {
+	c0, ok := record.Operations["c0"]
+	if !ok {
+		for _, move := range record.BestMoves {
+			points = append(points, STSPoints{move, 10})
+		}
+		return points, nil
+	}
+
+	var pos Position
+	pos.LoadFEN(record.FEN)
+
+	for _, field := range strings.Split(c0, ",") {
+		moveStr, pointsStr, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid c0 operation %q", c0)
+		}
+
+		move, ok := parseEPDMove(&pos, moveStr)
+		movePoints, err := strconv.Atoi(pointsStr)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid c0 operation %q", c0)
+		}
+		points = append(points, STSPoints{move, movePoints})
+	}
+
+	return points, nil
+}
+
+// Get the number and name of the theme of an STS position from its id, which is
+// given as "STS(v<NUMBER>.0) <NAME>.<POSITION>". Positions from other suites are
+// grouped by their id without the position number.
+func parseSTSTheme(id string) (number int, name string) {
+	name = id
+	if index := strings.LastIndex(name, "."); index >= 0 {
+		name = name[:index]
+	}
+
+	if strings.HasPrefix(name, "STS(v") {
+		version, rest, _ := strings.Cut(strings.TrimPrefix(name, "STS(v"), ")")
+		number, _ = strconv.Atoi(strings.Split(version, ".")[0])
+		name = strings.TrimSpace(rest)
+	}
+	return number, name
+}
+
+// Score the move found for an STS position, returning the points awarded to
+// the move, and the most points any move could have been awarded.
+func ScoreSTS(result *EPDResult) (score, maxScore int, err error) {
+	points, err := ParseSTSPoints(&result.Record)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, movePoints := range points {
+		if movePoints.Move.Equal(result.Move) {
+			score = movePoints.Points
+		}
+		maxScore = max(maxScore, movePoints.Points)
+	}
+	return score, maxScore, nil
+}
+
+// Run the Strategic Test Suite, or any suite of positions with c0 operations, and
+// write a report of the score of each theme to the given writer.
+func RunSTS(records []EPDRecord, limits EPDLimits, out io.Writer) (themes []STSTheme, err error) {
+	// Make sure every position can be scored before spending any time searching.
+	for _, record := range records {
+		if _, err := ParseSTSPoints(&record); err != nil {
+			return nil, fmt.Errorf("position %s: %w", record.ID, err)
+		}
+	}
+
+	search := Search{Silent: true}
+	search.TT.Resize(DefaultTTSize, SearchEntrySize)
+	themesByName := map[string]*STSTheme{}
+
+	for index, record := range records {
+		result := SolveEPD(&search, record, limits)
+		score, maxScore, _ := ScoreSTS(&result)
+
+		number, name := parseSTSTheme(record.ID)
+		theme, ok := themesByName[name]
+		if !ok {
+			theme = &STSTheme{Number: number, Name: name}
+			themesByName[name] = theme
+		}
+
+		theme.Positions++
+		theme.Score += score
+		theme.MaxScore += maxScore
+
+		if (index+1)%100 == 0 || index+1 == len(records) {
+			fmt.Fprintf(out, "Searched %d of %d positions\n", index+1, len(records))
+		}
+	}
+
+	for _, theme := range themesByName {
+		themes = append(themes, *theme)
+	}
+
+	sort.Slice(themes, func(i, j int) bool {
+		if themes[i].Number != themes[j].Number {
+			return themes[i].Number < themes[j].Number
+		}
+		return themes[i].Name < themes[j].Name
+	})
+
+	writeSTSReport(themes, out)
+	return themes, nil
+}
+
+// Write the score of each theme, and the total score.
+func writeSTSReport(themes []STSTheme, out io.Writer) {
+	total := STSTheme{Name: "Total"}
+	percent := func(theme STSTheme) float64 {
+		return 100 * float64(theme.Score) / float64(max(1, theme.MaxScore))
+	}
+
+	fmt.Fprintf(out, "\n%-3s %-40s %9s %7s %7s %7s\n", "", "Theme", "Positions", "Score", "Max", "Percent")
+	for _, theme := range themes {
+		number := ""
+		if theme.Number > 0 {
+			number = strconv.Itoa(theme.Number)
+		}
+
+		fmt.Fprintf(
+			out, "%-3s %-40s %9d %7d %7d %6.1f%%\n",
+			number, theme.Name, theme.Positions, theme.Score, theme.MaxScore, percent(theme),
+		)
+
+		total.Positions += theme.Positions
+		total.Score += theme.Score
+		total.MaxScore += theme.MaxScore
+	}
+
+	fmt.Fprintf(
+		out, "%-3s %-40s %9d %7d %7d %6.1f%%\n",
+		"", total.Name, total.Positions, total.Score, total.MaxScore, percent(total),
+	)
+}
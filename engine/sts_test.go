@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"io"
+	"testing"
+)
+
+// sts_test.go provides tests to ensure STS positions are scored correctly, and
+// that the scores are grouped by theme.
+
+var STSTestRecords = []string{
+	`6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - bm Rd8+; id "STS(v1.0) Undermine.001"; c0 "Rd8+=10, Rd7=3, Kf1=1";`,
+	`6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - bm Rd8+; id "STS(v1.0) Undermine.002"; c0 "Rd7=10, Rd8=2";`,
+	`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "STS(v12.0) Center Control.001"; c0 "Qg6=10";`,
+}
+
+func TestParseSTS(t *testing.T) {
+	record, _ := ParseEPD(STSTestRecords[0])
+	points, err := ParseSTSPoints(&record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"d1d8", "d1d7", "g1f1"}
+	for index, movePoints := range points {
+		if movePoints.Move.String() != expected[index] {
+			t.Errorf("Expected move %s in the c0 operation, got %s", expected[index], movePoints.Move)
+		}
+	}
+
+	if len(points) != 3 || points[0].Points != 10 || points[1].Points != 3 || points[2].Points != 1 {
+		t.Errorf("Parsing the c0 operation failed, got %v", points)
+	}
+
+	if number, name := parseSTSTheme("STS(v14.0) Queens and Rooks to the 7th rank.042"); number != 14 || name != "Queens and Rooks to the 7th rank" {
+		t.Errorf("Parsing the STS theme failed, got %d and %q", number, name)
+	}
+
+	record.Operations["c0"] = "Rd8=ten"
+	if _, err := ParseSTSPoints(&record); err == nil {
+		t.Errorf("Parsing an invalid c0 operation should fail")
+	}
+}
+
+func TestRunSTS(t *testing.T) {
+	records := []EPDRecord{}
+	for _, line := range STSTestRecords {
+		record, err := ParseEPD(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	themes, err := RunSTS(records, EPDLimits{Depth: 6}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []STSTheme{
+		{Number: 1, Name: "Undermine", Positions: 2, Score: 12, MaxScore: 20},
+		{Number: 12, Name: "Center Control", Positions: 1, Score: 10, MaxScore: 10},
+	}
+
+	if len(themes) != len(expected) {
+		t.Fatalf("Expected %d themes, got %v", len(expected), themes)
+	}
+
+	for index := range expected {
+		if themes[index] != expected[index] {
+			t.Errorf("Expected theme %+v, got %+v", expected[index], themes[index])
+		}
+	}
+}
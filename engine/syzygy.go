@@ -0,0 +1,1505 @@
+package engine
+
+// syzygy.go implements probing Syzygy endgame tablebases, which store the
+// perfect result of every position with a few pieces left on the board. The
+// WDL tables store whether a position is won, drawn, or lost, and the DTZ
+// tables store how many plies it takes to reach a zeroing move (a capture
+// or pawn move) while keeping the result.
+//
+// The format of the tables, and the way they're probed, follow Ronald de
+// Man's original probing code, and Stockfish's rewrite of it.
+//
+// https://www.chessprogramming.org/Syzygy_Bases
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/mmap"
+)
+
+const (
+	// The maximum number of pieces a Syzygy table can have.
+	TBMaxPieces = 7
+
+	// The score given to a position won according to the tablebases. The score
+	// is kept below the checkmate scores, so a real mate found by the search is
+	// still prefered over a tablebase win.
+	TBWinScore int16 = Checkmate - 2*MaxDepth
+
+	// Constants representing the possible results stored in the WDL tables. A
+	// cursed win is a win that can't be forced before the fifty-move rule kicks
+	// in, and a blessed loss is a loss that can be held to a draw by the rule.
+	TBLoss        int8 = -2
+	TBBlessedLoss int8 = -1
+	TBDraw        int8 = 0
+	TBCursedWin   int8 = 1
+	TBWin         int8 = 2
+
+	// Constants representing the state of a probe.
+	tbFail            int8 = 0
+	tbOK              int8 = 1
+	tbChangeSTM       int8 = -1
+	tbZeroingBestMove int8 = 2
+
+	// Constants representing the flags stored for each table.
+	tbFlagSTM         uint8 = 1
+	tbFlagMapped      uint8 = 2
+	tbFlagWinPlies    uint8 = 4
+	tbFlagLossPlies   uint8 = 8
+	tbFlagWide        uint8 = 16
+	tbFlagSingleValue uint8 = 128
+
+	// The file extensions of the WDL and DTZ tables.
+	WDLSuffix = ".rtbw"
+	DTZSuffix = ".rtbz"
+)
+
+// The magic numbers at the start of the WDL and DTZ tables.
+var tbMagic = [2][4]byte{
+	{0xD7, 0x66, 0x0C, 0xA5},
+	{0x71, 0xE8, 0x23, 0x5D},
+}
+
+// The tables used to encode the squares of the pieces in a position into an index
+// into a Syzygy table. See InitSyzygyTables for a description of each one.
+var mapPawns [64]int
+var mapB1H1H7 [64]int
+var mapA1D1D4 [64]int
+var mapKK [10][64]int
+var binomial [TBMaxPieces][64]uint64
+var leadPawnIdx [TBMaxPieces][64]uint64
+var leadPawnsSize [TBMaxPieces][4]uint64
+
+// A struct which holds the data needed to decompress one of the subtables of a
+// Syzygy table. Each table has a subtable for each side to move, and tables
+// with pawns have one for each file the leading pawn can be on.
+//
+// The values of a table are compressed using recursive pairing, and the symbols
+// created are stored in blocks using canonical Huffman codes. The fields which
+// point into the table file are stored as offsets from the start of the file.
+type pairsData struct {
+	flags           uint8
+	maxSymLen       int
+	minSymLen       int
+	numBlocks       int
+	sizeofBlock     int
+	span            uint64
+	lowestSym       int
+	btree           int
+	blockLength     int
+	blockLengthSize int
+	sparseIndex     int
+	sparseIndexSize int
+	data            int
+	base64          []uint64
+	symLen          []uint8
+	pieces          [TBMaxPieces]uint8
+	groupIdx        [TBMaxPieces + 1]uint64
+	groupLen        [TBMaxPieces + 1]int
+	mapIdx          [4]uint16
+}
+
+// A struct representing a WDL or DTZ table for a given material
+// configuration, such as KRvK. The table file is only mapped into
+// memory the first time it's probed.
+type tbTable struct {
+	name            string
+	isDTZ           bool
+	key             uint64
+	key2            uint64
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool
+	pawnCount       [2]int
+
+	once    sync.Once
+	ready   bool
+	file    *mmap.ReaderAt
+	items   [2][4]pairsData
+	dtzMap  int
+	dirPath string
+}
+
+// A struct holding the WDL and DTZ tables of a material configuration.
+type tbEntry struct {
+	wdl *tbTable
+	dtz *tbTable
+}
+
+// A constant which will be a singleton of the _Syzygy struct below,
+// since only one instance is ever needed.
+var Syzygy = _Syzygy{ProbeLimit: TBMaxPieces}
+
+// A struct which holds the tablebases found in the tablebase path, and
+// has methods to probe them.
+type _Syzygy struct {
+	// The largest number of pieces of any table found, and the largest number
+	// of pieces the tables should be probed with.
+	MaxPieces  int
+	ProbeLimit int
+
+	paths  []string
+	tables map[uint64]*tbEntry
+}
+
+// Load the tablebases found in the given path. Multiple directories can be
+// given, separated by the system's path list separator.
+func (syzygy *_Syzygy) Init(path string) {
+	syzygy.Close()
+
+	path = strings.TrimSpace(path)
+	if path == "" || path == "<empty>" {
+		return
+	}
+
+	syzygy.paths = filepath.SplitList(path)
+	syzygy.tables = make(map[uint64]*tbEntry)
+
+	// Look for every possible table with up to seven pieces. The pieces
+	// of each side are listed from the strongest to the weakest, and the
+	// stronger side is always listed first, as Syzygy tables are named.
+	for p1 := Pawn; p1 < King; p1++ {
+		syzygy.add(King, p1, King)
+
+		for p2 := Pawn; p2 <= p1; p2++ {
+			syzygy.add(King, p1, p2, King)
+			syzygy.add(King, p1, King, p2)
+
+			for p3 := Pawn; p3 < King; p3++ {
+				syzygy.add(King, p1, p2, King, p3)
+			}
+
+			for p3 := Pawn; p3 <= p2; p3++ {
+				syzygy.add(King, p1, p2, p3, King)
+
+				for p4 := Pawn; p4 <= p3; p4++ {
+					syzygy.add(King, p1, p2, p3, p4, King)
+
+					for p5 := Pawn; p5 <= p4; p5++ {
+						syzygy.add(King, p1, p2, p3, p4, p5, King)
+					}
+
+					for p5 := Pawn; p5 < King; p5++ {
+						syzygy.add(King, p1, p2, p3, p4, King, p5)
+					}
+				}
+
+				for p4 := Pawn; p4 < King; p4++ {
+					syzygy.add(King, p1, p2, p3, King, p4)
+
+					for p5 := Pawn; p5 <= p4; p5++ {
+						syzygy.add(King, p1, p2, p3, King, p4, p5)
+					}
+				}
+			}
+
+			for p3 := Pawn; p3 <= p1; p3++ {
+				p4Max := p3
+				if p1 == p3 {
+					p4Max = p2
+				}
+
+				for p4 := Pawn; p4 <= p4Max; p4++ {
+					syzygy.add(King, p1, p2, King, p3, p4)
+				}
+			}
+		}
+	}
+}
+
+// Unmap any tables that were probed, and forget about all the tables found.
+func (syzygy *_Syzygy) Close() {
+	for _, entry := range syzygy.tables {
+		for _, table := range [2]*tbTable{entry.wdl, entry.dtz} {
+			if table.file != nil {
+				table.file.Close()
+			}
+		}
+	}
+
+	syzygy.MaxPieces = 0
+	syzygy.paths = nil
+	syzygy.tables = nil
+}
+
+// Get the largest number of pieces a position can have to be probed.
+func (syzygy *_Syzygy) Cardinality() int {
+	return Min(syzygy.MaxPieces, syzygy.ProbeLimit)
+}
+
+// Add the table for the given pieces if its WDL file can be found.
+func (syzygy *_Syzygy) add(pieceTypes ...uint8) {
+	name := ""
+	for index, pieceType := range pieceTypes {
+		if index > 0 && pieceType == King {
+			name += "v"
+		}
+		name += string("PNBRQK"[pieceType])
+	}
+
+	dirPath := ""
+	for _, path := range syzygy.paths {
+		if _, err := os.Stat(filepath.Join(path, name+WDLSuffix)); err == nil {
+			dirPath = path
+			break
+		}
+	}
+
+	if dirPath == "" {
+		return
+	}
+
+	wdl := newTBTable(name, false)
+	dtz := newTBTable(name, true)
+	wdl.dirPath = dirPath
+	dtz.dirPath = dirPath
+
+	for _, path := range syzygy.paths {
+		if _, err := os.Stat(filepath.Join(path, name+DTZSuffix)); err == nil {
+			dtz.dirPath = path
+			break
+		}
+	}
+
+	// Both material keys of the table point to it, since a table like
+	// KRvK is also used to probe positions where black has the rook.
+	entry := &tbEntry{wdl: wdl, dtz: dtz}
+	syzygy.tables[wdl.key] = entry
+	syzygy.tables[wdl.key2] = entry
+	syzygy.MaxPieces = max(syzygy.MaxPieces, wdl.pieceCount)
+}
+
+// Create a new table from its name, such as KRvK.
+func newTBTable(name string, isDTZ bool) *tbTable {
+	table := tbTable{name: name, isDTZ: isDTZ}
+	sides := strings.Split(name, "v")
+
+	var counts [2][6]int
+	for _, char := range sides[0] {
+		counts[White][strings.IndexRune("PNBRQK", char)]++
+	}
+	for _, char := range sides[1] {
+		counts[Black][strings.IndexRune("PNBRQK", char)]++
+	}
+
+	table.key = materialKey(counts[White], counts[Black])
+	table.key2 = materialKey(counts[Black], counts[White])
+	table.pieceCount = len(name) - 1
+	table.hasPawns = counts[White][Pawn]+counts[Black][Pawn] > 0
+
+	for pieceType := Pawn; pieceType < King; pieceType++ {
+		if counts[White][pieceType] == 1 || counts[Black][pieceType] == 1 {
+			table.hasUniquePieces = true
+		}
+	}
+
+	// The leading color is the side with less pawns, since it leads to better
+	// compression. It's white if black has no pawns.
+	whiteLeads := counts[Black][Pawn] == 0 ||
+		(counts[White][Pawn] > 0 && counts[Black][Pawn] >= counts[White][Pawn])
+
+	if whiteLeads {
+		table.pawnCount = [2]int{counts[White][Pawn], counts[Black][Pawn]}
+	} else {
+		table.pawnCount = [2]int{counts[Black][Pawn], counts[White][Pawn]}
+	}
+
+	return &table
+}
+
+// Create a key identifying the material of a position, from the number
+// of each piece type white and black have.
+func materialKey(whiteCounts, blackCounts [6]int) uint64 {
+	key := uint64(0)
+	for pieceType := Pawn; pieceType <= King; pieceType++ {
+		key |= uint64(whiteCounts[pieceType]) << (4 * pieceType)
+		key |= uint64(blackCounts[pieceType]) << (4 * (pieceType + 6))
+	}
+	return key
+}
+
+// Get the material key of a position.
+func positionMaterialKey(pos *Position) uint64 {
+	var counts [2][6]int
+	for color := Black; color <= White; color++ {
+		for pieceType := Pawn; pieceType <= King; pieceType++ {
+			counts[color][pieceType] = pos.Pieces[color][pieceType].CountBits()
+		}
+	}
+	return materialKey(counts[White], counts[Black])
+}
+
+// Get the number of pieces on the board.
+func pieceCount(pos *Position) int {
+	return (pos.Sides[White] | pos.Sides[Black]).CountBits()
+}
+
+// Probe the WDL tables for the result of the position from the perspective of the
+// side to move. The position shouldn't have any castling rights, and if the fifty-move
+// counter isn't zero, the result might not account for the fifty-move rule correctly.
+func (syzygy *_Syzygy) ProbeWDL(pos *Position) (wdl int8, ok bool) {
+	state := tbOK
+	wdl = syzygy.search(pos, false, &state)
+	return wdl, state != tbFail
+}
+
+// Probe the DTZ tables for the number of plies until a zeroing move, when the best
+// moves are played. The number is positive if the side to move is winning, negative
+// if it's losing, and zero if the position is drawn. For a cursed win or a blessed
+// loss, the number is offset by 100.
+func (syzygy *_Syzygy) ProbeDTZ(pos *Position) (dtz int, ok bool) {
+	state := tbOK
+	dtz = syzygy.probeDTZ(pos, &state)
+	return dtz, state != tbFail
+}
+
+// Rank the root moves using the DTZ tables, or the WDL tables if the DTZ tables
+// can't be probed, and return the moves ranked best. Each winning move is ranked
+// equally, unless a fifty-move rule draw is in sight, in which case the moves
+// which make the most progress are ranked best. Whether the DTZ tables were
+// used, and whether probing the tables succeeded, is also returned.
+func (syzygy *_Syzygy) RankRootMoves(pos *Position, moves []Move, hasRepeated bool) (bestMoves []Move, usedDTZ, ok bool) {
+	ranks, ok := syzygy.rankRootMovesDTZ(pos, moves, hasRepeated)
+	usedDTZ = ok
+
+	if !ok {
+		ranks, ok = syzygy.rankRootMovesWDL(pos, moves)
+	}
+
+	if !ok || len(moves) == 0 {
+		return nil, false, false
+	}
+
+	bestRank := ranks[0]
+	for _, rank := range ranks {
+		bestRank = max(bestRank, rank)
+	}
+
+	for index, move := range moves {
+		if ranks[index] == bestRank {
+			bestMoves = append(bestMoves, move)
+		}
+	}
+
+	return bestMoves, usedDTZ, true
+}
+
+// Rank the root moves using the DTZ tables.
+func (syzygy *_Syzygy) rankRootMovesDTZ(pos *Position, moves []Move, hasRepeated bool) (ranks []int, ok bool) {
+	rule50 := int(pos.Rule50)
+	state := tbOK
+
+	for _, move := range moves {
+		pos.DoMove(move)
+
+		// Get the dtz of the position after the move, counted from the root.
+		dtz := 0
+		if pos.Rule50 == 0 {
+			state = tbOK
+			dtz = dtzBeforeZeroing(-syzygy.search(pos, false, &state))
+		} else {
+			dtz = -syzygy.probeDTZ(pos, &state)
+			dtz += signOf(dtz)
+		}
+
+		// Make sure a mating move is given a dtz of one.
+		if dtz == 2 && pos.InCheck() && len(GenLegalMoves(pos)) == 0 {
+			dtz = 1
+		}
+
+		pos.UndoMove(move)
+
+		if state == tbFail {
+			return nil, false
+		}
+
+		rank := 0
+		if dtz > 0 {
+			rank = 1000
+			if dtz+rule50 > 99 || hasRepeated {
+				rank = 1000 - (dtz + rule50)
+			}
+		} else if dtz < 0 {
+			rank = -1000
+			if -dtz*2+rule50 >= 100 {
+				rank = -1000 + (-dtz + rule50)
+			}
+		}
+
+		ranks = append(ranks, rank)
+	}
+
+	return ranks, true
+}
+
+// Rank the root moves using the WDL tables.
+func (syzygy *_Syzygy) rankRootMovesWDL(pos *Position, moves []Move) (ranks []int, ok bool) {
+	wdlToRank := [5]int{-1000, -899, 0, 899, 1000}
+
+	for _, move := range moves {
+		pos.DoMove(move)
+		wdl, ok := syzygy.ProbeWDL(pos)
+		pos.UndoMove(move)
+
+		if !ok {
+			return nil, false
+		}
+
+		ranks = append(ranks, wdlToRank[-wdl+2])
+	}
+
+	return ranks, true
+}
+
+// Search the captures of a position, and probe the position itself, to get its result.
+//
+// The tables don't need to store the correct result of a position where the side to
+// move has a winning capture, or a position where the best move is en passant, since
+// the tables don't know about en passant rights. So the generator is free to store
+// whatever value compresses best for these positions, and the correct result is the
+// best result out of the captures and the value from the tables.
+//
+// The DTZ tables also don't store the correct value if the best move is a zeroing
+// move, so when checkZeroingMoves is true, pawn moves are searched as well, and the
+// state is set if the best move is a zeroing move.
+func (syzygy *_Syzygy) search(pos *Position, checkZeroingMoves bool, state *int8) int8 {
+	bestValue := TBLoss
+	moves := GenLegalMoves(pos)
+	moveCount := 0
+
+	for _, move := range moves {
+		if !isTBCapture(pos, move) && (!checkZeroingMoves || pos.Squares[move.FromSq()].Type != Pawn) {
+			continue
+		}
+
+		moveCount++
+
+		pos.DoMove(move)
+		value := -syzygy.search(pos, false, state)
+		pos.UndoMove(move)
+
+		if *state == tbFail {
+			return TBDraw
+		}
+
+		if value > bestValue {
+			bestValue = value
+			if value >= TBWin {
+				*state = tbZeroingBestMove
+				return value
+			}
+		}
+	}
+
+	// If every legal move was already searched, there's no need to probe the
+	// tables, which might have a wrong value stored for the position.
+	noMoreMoves := moveCount > 0 && moveCount == len(moves)
+	value := bestValue
+
+	if !noMoreMoves {
+		value = int8(syzygy.probeTable(pos, false, TBDraw, state))
+		if *state == tbFail {
+			return TBDraw
+		}
+	}
+
+	if bestValue >= value {
+		*state = tbOK
+		if bestValue > TBDraw || noMoreMoves {
+			*state = tbZeroingBestMove
+		}
+		return bestValue
+	}
+
+	*state = tbOK
+	return value
+}
+
+// Probe the DTZ tables, given a probe state.
+func (syzygy *_Syzygy) probeDTZ(pos *Position, state *int8) int {
+	*state = tbOK
+	wdl := syzygy.search(pos, true, state)
+
+	// The DTZ tables don't store draws.
+	if *state == tbFail || wdl == TBDraw {
+		return 0
+	}
+
+	if *state == tbZeroingBestMove {
+		return dtzBeforeZeroing(wdl)
+	}
+
+	dtz := syzygy.probeTable(pos, true, wdl, state)
+	if *state == tbFail {
+		return 0
+	}
+
+	if *state != tbChangeSTM {
+		if wdl == TBCursedWin || wdl == TBBlessedLoss {
+			dtz += 100
+		}
+		return dtz * signOf(int(wdl))
+	}
+
+	// The DTZ tables only store one side to move, so if they don't store the side to move
+	// of this position, do a one ply search and find the best dtz of the other side.
+	minDTZ := 0xFFFF
+	for _, move := range GenLegalMoves(pos) {
+		zeroing := isTBCapture(pos, move) || pos.Squares[move.FromSq()].Type == Pawn
+		pos.DoMove(move)
+
+		// For zeroing moves, get the dtz before the move is made, since otherwise
+		// we'd get the dtz of the next sequence of moves.
+		if zeroing {
+			dtz = -dtzBeforeZeroing(syzygy.search(pos, false, state))
+		} else {
+			dtz = -syzygy.probeDTZ(pos, state)
+		}
+
+		// If the move mates, force the dtz to one.
+		if dtz == 1 && pos.InCheck() && len(GenLegalMoves(pos)) == 0 {
+			minDTZ = 1
+		}
+
+		if !zeroing {
+			dtz += signOf(dtz)
+		}
+
+		// Skip draws, and only pick positive dtz values if we're winning.
+		if dtz < minDTZ && signOf(dtz) == signOf(int(wdl)) {
+			minDTZ = dtz
+		}
+
+		pos.UndoMove(move)
+
+		if *state == tbFail {
+			return 0
+		}
+	}
+
+	// If there are no legal moves, the position is checkmate.
+	if minDTZ == 0xFFFF {
+		return -1
+	}
+	return minDTZ
+}
+
+// Get the dtz of a position where the best move is a zeroing move with the given result.
+func dtzBeforeZeroing(wdl int8) int {
+	switch wdl {
+	case TBWin:
+		return 1
+	case TBCursedWin:
+		return 101
+	case TBBlessedLoss:
+		return -101
+	case TBLoss:
+		return -1
+	}
+	return 0
+}
+
+// Determine if a move is a capture.
+func isTBCapture(pos *Position, move Move) bool {
+	return move.MoveType() == Attack ||
+		(move.MoveType() == Promotion && pos.Squares[move.ToSq()].Type != NoType)
+}
+
+// Get the sign of an integer.
+func signOf(n int) int {
+	if n > 0 {
+		return 1
+	} else if n < 0 {
+		return -1
+	}
+	return 0
+}
+
+// Probe the WDL or DTZ table of the position.
+func (syzygy *_Syzygy) probeTable(pos *Position, isDTZ bool, wdl int8, state *int8) int {
+	// There's no table for KvK, since it's always a draw.
+	if pieceCount(pos) == 2 {
+		return int(TBDraw)
+	}
+
+	key := positionMaterialKey(pos)
+	entry, ok := syzygy.tables[key]
+	if !ok {
+		*state = tbFail
+		return 0
+	}
+
+	table := entry.wdl
+	if isDTZ {
+		table = entry.dtz
+	}
+
+	if !table.mapFile() {
+		*state = tbFail
+		return 0
+	}
+
+	return table.probe(pos, key, wdl, state)
+}
+
+// Map the table file into memory, and setup the table from its header. This
+// is only done once, when the table is first probed.
+func (table *tbTable) mapFile() bool {
+	table.once.Do(func() {
+		suffix := WDLSuffix
+		if table.isDTZ {
+			suffix = DTZSuffix
+		}
+
+		path := filepath.Join(table.dirPath, table.name+suffix)
+		file, err := mmap.Open(path)
+		if err != nil {
+			return
+		}
+
+		// Every table file is padded to a multiple of 64 bytes, plus the 16 byte checksum.
+		if file.Len()%64 != 16 {
+			fmt.Printf("info string corrupted tablebase file %s\n", path)
+			file.Close()
+			return
+		}
+
+		magic := tbMagic[0]
+		if table.isDTZ {
+			magic = tbMagic[1]
+		}
+
+		for index := range magic {
+			if file.At(index) != magic[index] {
+				fmt.Printf("info string corrupted tablebase file %s\n", path)
+				file.Close()
+				return
+			}
+		}
+
+		table.file = file
+		table.ready = table.setup()
+
+		if !table.ready {
+			fmt.Printf("info string corrupted tablebase file %s\n", path)
+		}
+	})
+
+	return table.ready
+}
+
+// Get the subtable for the given side to move and file of the leading pawn.
+func (table *tbTable) get(stm, file int) *pairsData {
+	sides := 2
+	if table.isDTZ {
+		sides = 1
+	}
+
+	if !table.hasPawns {
+		file = 0
+	}
+
+	return &table.items[stm%sides][file]
+}
+
+// Read a byte from the table file.
+func (table *tbTable) u8(offset int) uint8 {
+	return table.file.At(offset)
+}
+
+// Read a little endian 16-bit number from the table file.
+func (table *tbTable) u16(offset int) uint16 {
+	return uint16(table.file.At(offset)) | uint16(table.file.At(offset+1))<<8
+}
+
+// Read a little endian 32-bit number from the table file.
+func (table *tbTable) u32(offset int) uint32 {
+	return uint32(table.u16(offset)) | uint32(table.u16(offset+2))<<16
+}
+
+// Read a big endian 32-bit number from the table file.
+func (table *tbTable) u32BE(offset int) uint32 {
+	return uint32(table.file.At(offset))<<24 | uint32(table.file.At(offset+1))<<16 |
+		uint32(table.file.At(offset+2))<<8 | uint32(table.file.At(offset+3))
+}
+
+// Read a big endian 64-bit number from the table file.
+func (table *tbTable) u64BE(offset int) uint64 {
+	return uint64(table.u32BE(offset))<<32 | uint64(table.u32BE(offset+4))
+}
+
+// Setup the subtables from the header of the table file. If the file is
+// malformed, false is returned.
+func (table *tbTable) setup() (ok bool) {
+	// A malformed file can make us try to read past its end,
+	// so catch it instead of crashing.
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	offset := len(tbMagic[0])
+
+	// The first byte stores whether the table is split by side to move, and whether it has pawns.
+	flags := table.u8(offset)
+	if table.hasPawns != (flags&2 != 0) || (table.key != table.key2) != (flags&1 != 0) {
+		return false
+	}
+	offset++
+
+	sides := 1
+	if !table.isDTZ && table.key != table.key2 {
+		sides = 2
+	}
+
+	maxFile := 0
+	if table.hasPawns {
+		maxFile = 3
+	}
+
+	// Whether there are pawns on both sides.
+	bothPawns := table.hasPawns && table.pawnCount[1] > 0
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			*table.get(side, file) = pairsData{}
+		}
+
+		order := [2][2]int{
+			{int(table.u8(offset) & 0xF), 0xF},
+			{int(table.u8(offset) >> 4), 0xF},
+		}
+		offset++
+
+		if bothPawns {
+			order[0][1] = int(table.u8(offset) & 0xF)
+			order[1][1] = int(table.u8(offset) >> 4)
+			offset++
+		}
+
+		for index := 0; index < table.pieceCount; index++ {
+			piece := table.u8(offset)
+			table.get(0, file).pieces[index] = piece & 0xF
+			if sides == 2 {
+				table.get(1, file).pieces[index] = piece >> 4
+			}
+			offset++
+		}
+
+		for side := 0; side < sides; side++ {
+			table.setGroups(table.get(side, file), order[side], file)
+		}
+	}
+
+	offset += offset & 1
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			offset = table.setSizes(table.get(side, file), offset)
+		}
+	}
+
+	if table.isDTZ {
+		offset = table.setDTZMap(offset, maxFile)
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			data := table.get(side, file)
+			data.sparseIndex = offset
+			offset += data.sparseIndexSize * 6
+		}
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			data := table.get(side, file)
+			data.blockLength = offset
+			offset += data.blockLengthSize * 2
+		}
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			// The compressed data is aligned to 64 bytes.
+			offset = (offset + 0x3F) &^ 0x3F
+			data := table.get(side, file)
+			data.data = offset
+			offset += data.numBlocks * data.sizeofBlock
+		}
+	}
+
+	return offset <= table.file.Len()
+}
+
+// Setup the groups of pieces of a subtable. The pieces of each group are
+// encoded together, such as the two kings and the rook in KRvK, or the
+// two rooks in KRRvK.
+func (table *tbTable) setGroups(data *pairsData, order [2]int, file int) {
+	numGroups := 0
+	firstLen := 2
+	if table.hasPawns {
+		firstLen = 0
+	} else if table.hasUniquePieces {
+		firstLen = 3
+	}
+
+	data.groupLen[numGroups] = 1
+	for index := 1; index < table.pieceCount; index++ {
+		firstLen--
+		if firstLen > 0 || data.pieces[index] == data.pieces[index-1] {
+			data.groupLen[numGroups]++
+		} else {
+			numGroups++
+			data.groupLen[numGroups] = 1
+		}
+	}
+
+	numGroups++
+	data.groupLen[numGroups] = 0
+
+	// The groups are encoded in the order given by the table, so if the pieces of each
+	// group g can be placed on the board in N(g) ways, the index of a position is of the
+	// form g1 * N(g2) * N(g3) + g2 * N(g3) + g3. The leading group's place in the order
+	// is order[0], and the place of the remaining pawns, if any, is order[1].
+	bothPawns := table.hasPawns && table.pawnCount[1] > 0
+	next := 1
+	freeSquares := 64 - data.groupLen[0]
+
+	if bothPawns {
+		next = 2
+		freeSquares -= data.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < numGroups || k == order[0] || k == order[1]; k++ {
+		if k == order[0] {
+			data.groupIdx[0] = idx
+			if table.hasPawns {
+				idx *= leadPawnsSize[data.groupLen[0]][file]
+			} else if table.hasUniquePieces {
+				idx *= 31332
+			} else {
+				idx *= 462
+			}
+		} else if k == order[1] {
+			data.groupIdx[1] = idx
+			idx *= binomial[data.groupLen[1]][48-data.groupLen[0]]
+		} else {
+			data.groupIdx[next] = idx
+			idx *= binomial[data.groupLen[next]][freeSquares]
+			freeSquares -= data.groupLen[next]
+			next++
+		}
+	}
+
+	data.groupIdx[numGroups] = idx
+}
+
+// Setup the sizes and Huffman codes of a subtable, and return the
+// offset of the data following them.
+func (table *tbTable) setSizes(data *pairsData, offset int) int {
+	data.flags = table.u8(offset)
+	offset++
+
+	// If every position in the subtable has the same value, only the value is stored.
+	if data.flags&tbFlagSingleValue != 0 {
+		data.minSymLen = int(table.u8(offset))
+		return offset + 1
+	}
+
+	// The number of positions in the subtable is stored in
+	// the index of the group following the last one.
+	numGroups := 0
+	for data.groupLen[numGroups] != 0 {
+		numGroups++
+	}
+	tbSize := data.groupIdx[numGroups]
+
+	data.sizeofBlock = 1 << table.u8(offset)
+	data.span = 1 << table.u8(offset+1)
+	data.sparseIndexSize = int((tbSize + data.span - 1) / data.span)
+	padding := int(table.u8(offset + 2))
+	data.numBlocks = int(table.u32(offset + 3))
+	data.blockLengthSize = data.numBlocks + padding
+	data.maxSymLen = int(table.u8(offset + 7))
+	data.minSymLen = int(table.u8(offset + 8))
+	data.lowestSym = offset + 9
+	data.base64 = make([]uint64, data.maxSymLen-data.minSymLen+1)
+
+	// The codes are canonical Huffman codes, so longer codes have lower values, and
+	// all the codes of the same length are consecutive. Create a table indexed by
+	// code length, where each value is the lowest code of that length, padded to
+	// 64 bits, so the length of a code can be found by comparing against the table.
+	for index := len(data.base64) - 2; index >= 0; index-- {
+		data.base64[index] = (data.base64[index+1] +
+			uint64(table.u16(data.lowestSym+2*index)) -
+			uint64(table.u16(data.lowestSym+2*(index+1)))) / 2
+	}
+
+	for index := range data.base64 {
+		data.base64[index] <<= 64 - index - data.minSymLen
+	}
+
+	offset = data.lowestSym + len(data.base64)*2
+	numSyms := int(table.u16(offset))
+	offset += 2
+
+	// Each symbol created by recursive pairing expands into a pair of
+	// symbols, stored as a tree. Find how many values each symbol
+	// expands into.
+	data.btree = offset
+	data.symLen = make([]uint8, numSyms)
+	visited := make([]bool, numSyms)
+
+	for sym := 0; sym < numSyms; sym++ {
+		if !visited[sym] {
+			data.symLen[sym] = table.setSymLen(data, sym, visited)
+		}
+	}
+
+	return offset + numSyms*3 + numSyms&1
+}
+
+// Get the number of values, minus one, a symbol expands into.
+func (table *tbTable) setSymLen(data *pairsData, sym int, visited []bool) uint8 {
+	visited[sym] = true
+
+	right := table.btreeRight(data, sym)
+	if right == 0xFFF {
+		return 0
+	}
+
+	left := table.btreeLeft(data, sym)
+	if !visited[left] {
+		data.symLen[left] = table.setSymLen(data, left, visited)
+	}
+	if !visited[right] {
+		data.symLen[right] = table.setSymLen(data, right, visited)
+	}
+
+	return data.symLen[left] + data.symLen[right] + 1
+}
+
+// Get the left symbol a symbol expands into, or the value
+// of the symbol if it doesn't expand into other symbols.
+func (table *tbTable) btreeLeft(data *pairsData, sym int) int {
+	offset := data.btree + 3*sym
+	return int(table.u8(offset+1)&0xF)<<8 | int(table.u8(offset))
+}
+
+// Get the right symbol a symbol expands into.
+func (table *tbTable) btreeRight(data *pairsData, sym int) int {
+	offset := data.btree + 3*sym
+	return int(table.u8(offset+2))<<4 | int(table.u8(offset+1)>>4)
+}
+
+// Setup the maps used by the DTZ table to convert the stored values
+// into dtz values, and return the offset of the data following them.
+func (table *tbTable) setDTZMap(offset, maxFile int) int {
+	table.dtzMap = offset
+
+	for file := 0; file <= maxFile; file++ {
+		data := table.get(0, file)
+		if data.flags&tbFlagMapped == 0 {
+			continue
+		}
+
+		if data.flags&tbFlagWide != 0 {
+			offset += offset & 1
+			for index := 0; index < 4; index++ {
+				data.mapIdx[index] = uint16((offset-table.dtzMap)/2 + 1)
+				offset += 2*int(table.u16(offset)) + 2
+			}
+		} else {
+			for index := 0; index < 4; index++ {
+				data.mapIdx[index] = uint16(offset - table.dtzMap + 1)
+				offset += int(table.u8(offset)) + 1
+			}
+		}
+	}
+
+	return offset + offset&1
+}
+
+// Probe the table for the value stored for the position. For the DTZ tables,
+// the result of the position needs to be given.
+func (table *tbTable) probe(pos *Position, key uint64, wdl int8, state *int8) (value int) {
+	// A malformed file can make us try to read past its end,
+	// so catch it instead of crashing.
+	defer func() {
+		if recover() != nil {
+			*state = tbFail
+			value = 0
+		}
+	}()
+
+	data, tbFile, idx, ok := table.encode(pos, key)
+	if !ok {
+		*state = tbChangeSTM
+		return 0
+	}
+
+	return table.mapScore(tbFile, table.decompressPairs(data, idx), wdl)
+}
+
+// Encode the position into an index into the table, and get the subtable the index
+// is for. If the table is a DTZ table which doesn't store the side to move of the
+// position, false is returned.
+func (table *tbTable) encode(pos *Position, key uint64) (data *pairsData, tbFile int, idx uint64, ok bool) {
+	var squares [TBMaxPieces]uint8
+	var pieces [TBMaxPieces]uint8
+	var leadPawns Bitboard
+
+	size, leadPawnsCnt := 0, 0
+
+	// The tables use 0 for white to move, and 1 for black to move.
+	stm := int(pos.SideToMove ^ 1)
+
+	// The tables are stored with white as the stronger side, so if black is the
+	// stronger side, the colors and the squares need to be flipped. If both sides
+	// have the same pieces, only white to move is stored, so the position needs
+	// to be flipped if it's black to move.
+	symmetricBlackToMove := table.key == table.key2 && stm == 1
+	blackStronger := key != table.key
+
+	flipColor, flipSquares := uint8(0), uint8(0)
+	if symmetricBlackToMove || blackStronger {
+		flipColor = 8
+		flipSquares = 56
+		stm ^= 1
+	}
+
+	// Tables with pawns are split into four subtables, depending on which file the
+	// leading pawn is on after mirroring. The leading pawn is the pawn closest to the
+	// edge of the board, and the one on the lowest rank out of pawns on the same file.
+	if table.hasPawns {
+		leadPiece := table.get(0, 0).pieces[0] ^ flipColor
+		leadPawns = pos.Pieces[tbPieceColor(leadPiece)][Pawn]
+
+		pawns := leadPawns
+		for pawns != 0 {
+			squares[size] = pawns.PopBit() ^ flipSquares
+			size++
+		}
+		leadPawnsCnt = size
+
+		leadIndex := 0
+		for index := 1; index < leadPawnsCnt; index++ {
+			if mapPawns[squares[index]] > mapPawns[squares[leadIndex]] {
+				leadIndex = index
+			}
+		}
+
+		squares[0], squares[leadIndex] = squares[leadIndex], squares[0]
+		tbFile = int(Min(FileOf(squares[0]), 7-FileOf(squares[0])))
+	}
+
+	// The DTZ tables only store one side to move, so let the caller
+	// know if they store the other one.
+	if table.isDTZ {
+		flags := table.get(stm, tbFile).flags
+		if int(flags&tbFlagSTM) != stm && !(table.key == table.key2 && !table.hasPawns) {
+			return nil, tbFile, 0, false
+		}
+	}
+
+	piecesBB := (pos.Sides[White] | pos.Sides[Black]) &^ leadPawns
+	for piecesBB != 0 {
+		sq := piecesBB.PopBit()
+		squares[size] = sq ^ flipSquares
+		pieces[size] = tbPiece(pos.Squares[sq]) ^ flipColor
+		size++
+	}
+
+	data = table.get(stm, tbFile)
+
+	// Order the pieces the same way the table does.
+	for i := leadPawnsCnt; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if data.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror the squares so the leading piece is on the a-d files.
+	if FileOf(squares[0]) > 3 {
+		for index := 0; index < size; index++ {
+			squares[index] ^= 7
+		}
+	}
+
+	if table.hasPawns {
+		// Encode the leading pawns, starting with the one with the
+		// lowest value in mapPawns.
+		idx = leadPawnIdx[leadPawnsCnt][squares[0]]
+
+		sortSquares(squares[1:leadPawnsCnt], func(sq1, sq2 uint8) bool {
+			return mapPawns[sq1] < mapPawns[sq2]
+		})
+
+		for index := 1; index < leadPawnsCnt; index++ {
+			idx += binomial[index][mapPawns[squares[index]]]
+		}
+	} else {
+		// Without pawns, also mirror the squares so the leading piece is below the 5th rank...
+		if RankOf(squares[0]) > 3 {
+			for index := 0; index < size; index++ {
+				squares[index] ^= 56
+			}
+		}
+
+		// ...and so the first piece of the leading group not on the a1-h8
+		// diagonal is below the diagonal.
+		for i := 0; i < data.groupLen[0]; i++ {
+			if offA1H8(squares[i]) == 0 {
+				continue
+			}
+
+			if offA1H8(squares[i]) > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = ((squares[j] >> 3) | (squares[j] << 3)) & 63
+				}
+			}
+			break
+		}
+
+		idx = encodeLeadingPieces(squares[:], table.hasUniquePieces)
+	}
+
+	// Encode the remaining groups. The squares of each group are mapped down to
+	// skip the squares taken by the pieces in the previous groups, and the remaining
+	// pawns, if any, are mapped down to skip the first rank.
+	idx *= data.groupIdx[0]
+	groupStart := data.groupLen[0]
+	remainingPawns := table.hasPawns && table.pawnCount[1] > 0
+
+	for next := 1; data.groupLen[next] != 0; next++ {
+		group := squares[groupStart : groupStart+data.groupLen[next]]
+		sortSquares(group, func(sq1, sq2 uint8) bool { return sq1 < sq2 })
+
+		n := uint64(0)
+		for index, sq := range group {
+			adjust := 0
+			for _, prevSq := range squares[:groupStart] {
+				if sq > prevSq {
+					adjust++
+				}
+			}
+
+			if remainingPawns {
+				adjust += 8
+			}
+
+			n += binomial[index+1][int(sq)-adjust]
+		}
+
+		remainingPawns = false
+		idx += n * data.groupIdx[next]
+		groupStart += data.groupLen[next]
+	}
+
+	return data, tbFile, idx, true
+}
+
+// Encode the leading group of pieces of a table without pawns. If there are at least three
+// unique pieces, including the kings, the first three pieces are encoded together, and
+// otherwise the two kings are. The first piece is always in the a1-d1-d4 triangle.
+func encodeLeadingPieces(squares []uint8, hasUniquePieces bool) uint64 {
+	if !hasUniquePieces {
+		return uint64(mapKK[mapA1D1D4[squares[0]]][squares[1]])
+	}
+
+	sq0, sq1, sq2 := int(squares[0]), int(squares[1]), int(squares[2])
+	rank0, rank1, rank2 := int(RankOf(squares[0])), int(RankOf(squares[1])), int(RankOf(squares[2]))
+
+	adjust1 := 0
+	if sq1 > sq0 {
+		adjust1++
+	}
+
+	adjust2 := 0
+	if sq2 > sq0 {
+		adjust2++
+	}
+	if sq2 > sq1 {
+		adjust2++
+	}
+
+	if offA1H8(squares[0]) != 0 {
+		// The first piece is below the diagonal, in the b1-d1-d3 triangle.
+		return uint64((mapA1D1D4[sq0]*63+(sq1-adjust1))*62 + sq2 - adjust2)
+	} else if offA1H8(squares[1]) != 0 {
+		// The first piece is on the diagonal, and the second is below it.
+		return uint64((6*63+rank0*28+mapB1H1H7[sq1])*62 + sq2 - adjust2)
+	} else if offA1H8(squares[2]) != 0 {
+		// The first two pieces are on the diagonal, and the third is below it.
+		return uint64(6*63*62 + 4*28*62 + rank0*7*28 + (rank1-adjust1)*28 + mapB1H1H7[sq2])
+	}
+
+	// All three pieces are on the diagonal.
+	return uint64(6*63*62 + 4*28*62 + 4*7*28 + rank0*7*6 + (rank1-adjust1)*6 + (rank2 - adjust2))
+}
+
+// Convert the value stored in a table into a WDL or DTZ value.
+func (table *tbTable) mapScore(tbFile, value int, wdl int8) int {
+	if !table.isDTZ {
+		return value - 2
+	}
+
+	data := table.get(0, tbFile)
+	wdlMap := [5]int{1, 3, 0, 2, 0}
+
+	if data.flags&tbFlagMapped != 0 {
+		mapIdx := int(data.mapIdx[wdlMap[wdl+2]])
+		if data.flags&tbFlagWide != 0 {
+			value = int(table.u16(table.dtzMap + 2*(mapIdx+value)))
+		} else {
+			value = int(table.u8(table.dtzMap + mapIdx + value))
+		}
+	}
+
+	// The DTZ tables store either moves or plies, so convert moves to plies.
+	if (wdl == TBWin && data.flags&tbFlagWinPlies == 0) ||
+		(wdl == TBLoss && data.flags&tbFlagLossPlies == 0) ||
+		wdl == TBCursedWin || wdl == TBBlessedLoss {
+		value *= 2
+	}
+
+	return value + 1
+}
+
+// Decompress the value stored at the given index of a subtable.
+func (table *tbTable) decompressPairs(data *pairsData, idx uint64) int {
+	if data.flags&tbFlagSingleValue != 0 {
+		return data.minSymLen
+	}
+
+	// Each block n stores blockLength[n] + 1 values. To avoid walking through every block
+	// to find the one holding the value at idx, the sparse index stores the block and the
+	// offset in the block of every value at an index k * span + span / 2.
+	k := int(idx / data.span)
+	block := int(table.u32(data.sparseIndex + 6*k))
+	offset := int(table.u16(data.sparseIndex + 6*k + 4))
+	offset += int(idx%data.span) - int(data.span/2)
+
+	// Move to the block holding the value, until 0 <= offset <= blockLength[block].
+	for offset < 0 {
+		block--
+		offset += int(table.u16(data.blockLength+2*block)) + 1
+	}
+
+	for offset > int(table.u16(data.blockLength+2*block)) {
+		offset -= int(table.u16(data.blockLength+2*block)) + 1
+		block++
+	}
+
+	// Read the Huffman codes in the block until we find the symbol
+	// which expands into the value we're looking for.
+	ptr := data.data + block*data.sizeofBlock
+	buf64 := table.u64BE(ptr)
+	buf64Size := 64
+	ptr += 8
+
+	sym := 0
+	for {
+		length := 0
+		for buf64 < data.base64[length] {
+			length++
+		}
+
+		sym = int((buf64 - data.base64[length]) >> (64 - length - data.minSymLen))
+		sym += int(table.u16(data.lowestSym + 2*length))
+
+		if offset < int(data.symLen[sym])+1 {
+			break
+		}
+
+		offset -= int(data.symLen[sym]) + 1
+		length += data.minSymLen
+		buf64 <<= length
+		buf64Size -= length
+
+		if buf64Size <= 32 {
+			buf64Size += 32
+			buf64 |= uint64(table.u32BE(ptr)) << (64 - buf64Size)
+			ptr += 4
+		}
+	}
+
+	// Expand the symbol into its pair of symbols until we reach the value.
+	for data.symLen[sym] != 0 {
+		left := table.btreeLeft(data, sym)
+		if offset < int(data.symLen[left])+1 {
+			sym = left
+		} else {
+			offset -= int(data.symLen[left]) + 1
+			sym = table.btreeRight(data, sym)
+		}
+	}
+
+	return table.btreeLeft(data, sym)
+}
+
+// Convert a piece into the encoding used by the tables, where white pieces are
+// numbered 1 to 6 from pawn to king, and black pieces 9 to 14.
+func tbPiece(piece Piece) uint8 {
+	if piece.Color == Black {
+		return piece.Type + 9
+	}
+	return piece.Type + 1
+}
+
+// Get the color of a piece in the encoding used by the tables.
+func tbPieceColor(piece uint8) uint8 {
+	if piece&8 != 0 {
+		return Black
+	}
+	return White
+}
+
+// Get how far above the a1-h8 diagonal a square is. The value is
+// negative for squares below the diagonal.
+func offA1H8(sq uint8) int {
+	return int(RankOf(sq)) - int(FileOf(sq))
+}
+
+// Sort a small slice of squares using insertion sort, keeping
+// the order of squares which compare as equal.
+func sortSquares(squares []uint8, less func(sq1, sq2 uint8) bool) {
+	for i := 1; i < len(squares); i++ {
+		for j := i; j > 0 && less(squares[j], squares[j-1]); j-- {
+			squares[j], squares[j-1] = squares[j-1], squares[j]
+		}
+	}
+}
+
+// Initialize the tables used to encode positions into indexes into the Syzygy tables.
+func InitSyzygyTables() {
+	// mapB1H1H7 maps the squares below the a1-h8 diagonal to 0..27.
+	code := 0
+	for sq := uint8(0); sq < 64; sq++ {
+		if offA1H8(sq) < 0 {
+			mapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	// mapA1D1D4 maps the squares of the a1-d1-d4 triangle to 0..9, with
+	// the squares on the diagonal mapped last.
+	code = 0
+	diagonal := []uint8{}
+	for _, sq := range []uint8{
+		A1, B1, C1, D1,
+		A2, B2, C2, D2,
+		A3, B3, C3, D3,
+		A4, B4, C4, D4,
+	} {
+		if offA1H8(sq) < 0 {
+			mapA1D1D4[sq] = code
+			code++
+		} else if offA1H8(sq) == 0 {
+			diagonal = append(diagonal, sq)
+		}
+	}
+
+	for _, sq := range diagonal {
+		mapA1D1D4[sq] = code
+		code++
+	}
+
+	// mapKK maps the 462 legal placements of two kings, where the first is in the
+	// a1-d1-d4 triangle, and the second isn't above the diagonal if the first is on
+	// it. Placements with both kings on the diagonal are mapped last.
+	type kingPair struct {
+		idx int
+		sq  uint8
+	}
+
+	bothOnDiagonal := []kingPair{}
+	code = 0
+
+	for idx := 0; idx < 10; idx++ {
+		for sq1 := uint8(A1); sq1 <= D4; sq1++ {
+			// B1 is mapped to zero, along with all the squares outside of the triangle.
+			if mapA1D1D4[sq1] != idx || (idx == 0 && sq1 != B1) {
+				continue
+			}
+
+			for sq2 := uint8(0); sq2 < 64; sq2++ {
+				if kingDistance(sq1, sq2) <= 1 {
+					continue
+				} else if offA1H8(sq1) == 0 && offA1H8(sq2) > 0 {
+					continue
+				} else if offA1H8(sq1) == 0 && offA1H8(sq2) == 0 {
+					bothOnDiagonal = append(bothOnDiagonal, kingPair{idx, sq2})
+				} else {
+					mapKK[idx][sq2] = code
+					code++
+				}
+			}
+		}
+	}
+
+	for _, pair := range bothOnDiagonal {
+		mapKK[pair.idx][pair.sq] = code
+		code++
+	}
+
+	// binomial[k][n] is the number of ways to choose k elements from a set of n elements.
+	binomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < TBMaxPieces && k <= n; k++ {
+			binomial[k][n] = 0
+			if k > 0 {
+				binomial[k][n] += binomial[k-1][n-1]
+			}
+			if k < n {
+				binomial[k][n] += binomial[k][n-1]
+			}
+		}
+	}
+
+	// mapPawns maps the squares a2-h7 to 0..47, so that the pawn with the highest value is
+	// the leading pawn. This is the number of squares left for the other pawns when the leading
+	// pawn is on the square. leadPawnIdx and leadPawnsSize are used to encode the leading pawns,
+	// and are indexed by the number of leading pawns. Since the tables are split by the file of
+	// the leading pawn, the indexes restart at each file.
+	availableSquares := 47
+	for leadPawnsCnt := 1; leadPawnsCnt < TBMaxPieces; leadPawnsCnt++ {
+		for file := uint8(0); file < 4; file++ {
+			idx := uint64(0)
+
+			for rank := uint8(1); rank < 7; rank++ {
+				sq := rank*8 + file
+
+				if leadPawnsCnt == 1 {
+					mapPawns[sq] = availableSquares
+					availableSquares--
+					mapPawns[sq^7] = availableSquares
+					availableSquares--
+				}
+
+				leadPawnIdx[leadPawnsCnt][sq] = idx
+				idx += binomial[leadPawnsCnt-1][mapPawns[sq]]
+			}
+
+			leadPawnsSize[leadPawnsCnt][file] = idx
+		}
+	}
+}
+
+// Get the number of king moves needed to move between two squares.
+func kingDistance(sq1, sq2 uint8) int {
+	fileDistance := abs(int(FileOf(sq1)) - int(FileOf(sq2)))
+	rankDistance := abs(int(RankOf(sq1)) - int(RankOf(sq2)))
+	return max(fileDistance, rankDistance)
+}
@@ -0,0 +1,636 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// syzygy_gen_test.go implements a generator for the 3-piece Syzygy tables in
+// testdata/syzygy. Each table is solved by retrograde analysis, using the
+// engine's move generator, and written in the Syzygy format, using the same
+// encoding of positions the tables are probed with.
+//
+// The tables are written without recursive pairing, so every symbol is a single
+// value, coded with canonical Huffman codes. They're larger than the published
+// tables, but hold the same results, and can be probed by any Syzygy prober.
+//
+// The tables in testdata/syzygy can be written again with:
+//
+//	SYZYGY_WRITE_TESTDATA=1 go test -run TestSyzygyGenerator ./engine
+
+// The piece types of the tables written, in the order they need to be solved,
+// since the tables with pawns depend on the tables their promotions lead to.
+var tbGenPieceTypes = []uint8{Queen, Rook, Bishop, Knight, Pawn}
+
+const (
+	// The number of positions of a 3-piece table, including illegal ones.
+	tbGenStates = 2 * 64 * 64 * 64
+
+	// Flags and codes used to store the moves of a position. A move is stored as
+	// the position it leads to, or as the result of the position, if the move
+	// leaves the table.
+	tbGenZeroing      int32 = 1 << 30
+	tbGenUnknown      int8  = -128
+	tbGenInfinity           = 1 << 20
+	tbGenBlockSizeLog       = 5
+	tbGenSpanLog            = 10
+)
+
+// A solved 3-piece table, with a white king and a white piece against a black
+// king. The result of each position is stored from the perspective of the side
+// to move, along with the number of plies until a zeroing move or checkmate.
+type tbGenTable struct {
+	name      string
+	pieceType uint8
+	legal     []bool
+	wdl       []int8
+	dtz       []int
+}
+
+// Get the index of the position with the given side to move and squares.
+func tbGenState(stm, wk, piece, bk uint8) int {
+	return ((int(stm)*64+int(wk))*64+int(piece))*64 + int(bk)
+}
+
+// Get the side to move and squares of the position with the given index.
+func tbGenSquares(state int) (stm, wk, piece, bk uint8) {
+	return uint8(state >> 18), uint8(state>>12) & 63, uint8(state>>6) & 63, uint8(state) & 63
+}
+
+// Create the FEN string of a position of a table.
+func tbGenFEN(pieceType uint8, state int) string {
+	stm, wk, piece, bk := tbGenSquares(state)
+	var board [64]byte
+	board[wk] = 'K'
+	board[piece] = "PNBRQ"[pieceType]
+	board[bk] = 'k'
+
+	var fen strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			if char := board[rank*8+file]; char != 0 {
+				if empty > 0 {
+					fen.WriteByte(byte('0' + empty))
+					empty = 0
+				}
+				fen.WriteByte(char)
+			} else {
+				empty++
+			}
+		}
+		if empty > 0 {
+			fen.WriteByte(byte('0' + empty))
+		}
+		if rank > 0 {
+			fen.WriteByte('/')
+		}
+	}
+
+	if stm == White {
+		return fen.String() + " w - - 0 1"
+	}
+	return fen.String() + " b - - 0 1"
+}
+
+// Solve a table by retrograde analysis. The tables the promotions of a pawn lead
+// to must already be solved.
+func solveTBGenTable(pieceType uint8, solved map[uint8]*tbGenTable) *tbGenTable {
+	table := &tbGenTable{
+		name:      "K" + string("PNBRQ"[pieceType]) + "vK",
+		pieceType: pieceType,
+		legal:     make([]bool, tbGenStates),
+		wdl:       make([]int8, tbGenStates),
+		dtz:       make([]int, tbGenStates),
+	}
+
+	moveStart := make([]int, tbGenStates+1)
+	moves := []int32{}
+	var pos Position
+
+	for state := 0; state < tbGenStates; state++ {
+		moveStart[state] = len(moves)
+		table.wdl[state] = tbGenUnknown
+
+		_, wk, piece, bk := tbGenSquares(state)
+		if wk == piece || bk == piece || kingDistance(wk, bk) <= 1 ||
+			(pieceType == Pawn && (RankOf(piece) == Rank1 || RankOf(piece) == Rank8)) {
+			continue
+		}
+
+		pos.LoadFEN(tbGenFEN(pieceType, state))
+		if sqIsAttacked(&pos, pos.SideToMove^1, pos.Pieces[pos.SideToMove^1][King].Msb()) {
+			continue
+		}
+
+		table.legal[state] = true
+		legalMoves := GenLegalMoves(&pos)
+
+		for _, move := range legalMoves {
+			zeroing := isTBCapture(&pos, move) || pos.Squares[move.FromSq()].Type == Pawn
+			pos.DoMove(move)
+			moves = append(moves, tbGenMove(&pos, pieceType, zeroing, solved))
+			pos.UndoMove(move)
+		}
+
+		if len(legalMoves) == 0 {
+			table.wdl[state] = TBDraw
+			if pos.InCheck() {
+				table.wdl[state] = TBLoss
+				table.dtz[state] = 1
+			}
+		}
+	}
+	moveStart[tbGenStates] = len(moves)
+
+	// Get the result of the position a move leads to, from the perspective of
+	// the side to move in that position.
+	moveWDL := func(move int32) int8 {
+		if move < 0 {
+			return int8(-move - 3)
+		}
+		return table.wdl[move&^tbGenZeroing]
+	}
+
+	// A position is won if any move leads to a lost position, and lost if every
+	// move leads to a won position. Positions left once nothing changes are drawn.
+	for changed := true; changed; {
+		changed = false
+		for state := 0; state < tbGenStates; state++ {
+			if !table.legal[state] || table.wdl[state] != tbGenUnknown {
+				continue
+			}
+
+			win, loss := false, true
+			for _, move := range moves[moveStart[state]:moveStart[state+1]] {
+				wdl := moveWDL(move)
+				win = win || wdl == TBLoss
+				loss = loss && wdl == TBWin
+			}
+
+			if win {
+				table.wdl[state] = TBWin
+				changed = true
+			} else if loss {
+				table.wdl[state] = TBLoss
+				changed = true
+			}
+		}
+	}
+
+	for state := 0; state < tbGenStates; state++ {
+		if table.legal[state] && table.wdl[state] == tbGenUnknown {
+			table.wdl[state] = TBDraw
+		}
+		if table.wdl[state] != TBDraw && table.dtz[state] == 0 {
+			table.dtz[state] = tbGenInfinity
+		}
+	}
+
+	// The winning side picks the move reaching a zeroing move or checkmate the soonest,
+	// and the losing side the one reaching it the latest. A zeroing move counts as one ply,
+	// and so does a checkmating move.
+	for changed := true; changed; {
+		changed = false
+		for state := 0; state < tbGenStates; state++ {
+			if !table.legal[state] || table.wdl[state] == TBDraw || moveStart[state] == moveStart[state+1] {
+				continue
+			}
+
+			best := 0
+			if table.wdl[state] == TBWin {
+				best = tbGenInfinity
+			}
+
+			for _, move := range moves[moveStart[state]:moveStart[state+1]] {
+				if table.wdl[state] == TBWin && moveWDL(move) != TBLoss {
+					continue
+				}
+
+				plies := 1
+				if move >= 0 && move&tbGenZeroing == 0 {
+					next := int(move)
+					if moveStart[next] != moveStart[next+1] {
+						plies = Min(table.dtz[next]+1, tbGenInfinity)
+					}
+				}
+
+				if table.wdl[state] == TBWin {
+					best = Min(best, plies)
+				} else {
+					best = max(best, plies)
+				}
+			}
+
+			if best != table.dtz[state] {
+				table.dtz[state] = best
+				changed = true
+			}
+		}
+	}
+
+	return table
+}
+
+// Store a move of a position as the position it leads to, or as the
+// result of the position if the move leaves the table.
+func tbGenMove(pos *Position, pieceType uint8, zeroing bool, solved map[uint8]*tbGenTable) int32 {
+	if pieceCount(pos) == 2 {
+		return -int32(TBDraw) - 3
+	}
+
+	// A promotion leads to the table of the piece promoted to.
+	var table *tbGenTable
+	if pos.Pieces[White][pieceType] == 0 {
+		for promoted := Knight; promoted <= Queen; promoted++ {
+			if pos.Pieces[White][promoted] != 0 {
+				table, pieceType = solved[promoted], promoted
+			}
+		}
+	}
+
+	state := tbGenState(
+		pos.SideToMove,
+		pos.Pieces[White][King].Msb(),
+		pos.Pieces[White][pieceType].Msb(),
+		pos.Pieces[Black][King].Msb(),
+	)
+
+	if table != nil {
+		return -int32(table.wdl[state]) - 3
+	}
+
+	if zeroing {
+		return int32(state) | tbGenZeroing
+	}
+	return int32(state)
+}
+
+// Write the WDL or DTZ file of a solved table into the given directory.
+func writeTBGenTable(dir string, solution *tbGenTable, isDTZ bool) error {
+	table := newTBTable(solution.name, isDTZ)
+	pieces := [TBMaxPieces]uint8{tbPiece(Piece{King, White}), tbPiece(Piece{solution.pieceType, White}), tbPiece(Piece{King, Black})}
+	if table.hasPawns {
+		pieces[0], pieces[1] = pieces[1], pieces[0]
+	}
+
+	sides, files := 2, 1
+	if isDTZ {
+		sides = 1
+	}
+	if table.hasPawns {
+		files = 4
+	}
+
+	// The DTZ tables store positions with white to move, and store their values in plies.
+	var values [2][4][]int
+	for file := 0; file < files; file++ {
+		for side := 0; side < sides; side++ {
+			data := table.get(side, file)
+			data.pieces = pieces
+			table.setGroups(data, [2]int{0, 0xF}, file)
+			if isDTZ {
+				data.flags = tbFlagWinPlies | tbFlagLossPlies
+			}
+
+			values[side][file] = make([]int, data.groupIdx[tbGenNumGroups(data)])
+			for idx := range values[side][file] {
+				values[side][file][idx] = -1
+			}
+		}
+	}
+
+	var pos Position
+	for state, legal := range solution.legal {
+		stm, _, _, _ := tbGenSquares(state)
+		if !legal || (isDTZ && (stm != White || solution.wdl[state] == TBDraw)) {
+			continue
+		}
+
+		pos.LoadFEN(tbGenFEN(solution.pieceType, state))
+		_, file, idx, ok := table.encode(&pos, table.key)
+		if !ok {
+			return fmt.Errorf("position %s can't be encoded", pos.GenFEN())
+		}
+
+		value := int(solution.wdl[state]) + 2
+		if isDTZ {
+			if solution.dtz[state] > 100 {
+				return fmt.Errorf("position %s needs more than 100 plies to win", pos.GenFEN())
+			}
+			value = solution.dtz[state] - 1
+		}
+
+		side := int(stm^1) % sides
+
+		if stored := values[side][file][idx]; stored != -1 && stored != value {
+			return fmt.Errorf("position %s encoded to the same index as a position with a different value", pos.GenFEN())
+		}
+		values[side][file][idx] = value
+	}
+
+	header := append([]byte{}, tbMagic[0][:]...)
+	if isDTZ {
+		header = append([]byte{}, tbMagic[1][:]...)
+	}
+
+	flags := byte(1)
+	if table.hasPawns {
+		flags |= 2
+	}
+	header = append(header, flags)
+
+	for file := 0; file < files; file++ {
+		header = append(header, 0)
+		for _, piece := range pieces[:table.pieceCount] {
+			header = append(header, piece|piece<<4)
+		}
+	}
+	header = append(header, make([]byte, len(header)&1)...)
+
+	var sparseIndexes, blockLengths, blocks [][]byte
+	for file := 0; file < files; file++ {
+		for side := 0; side < sides; side++ {
+			sizes, sparseIndex, blockLength, data := tbGenCompress(values[side][file], table.get(side, file).flags)
+			header = append(header, sizes...)
+			sparseIndexes = append(sparseIndexes, sparseIndex)
+			blockLengths = append(blockLengths, blockLength)
+			blocks = append(blocks, data)
+		}
+	}
+
+	if isDTZ {
+		header = append(header, make([]byte, len(header)&1)...)
+	}
+
+	file := header
+	for _, sparseIndex := range sparseIndexes {
+		file = append(file, sparseIndex...)
+	}
+	for _, blockLength := range blockLengths {
+		file = append(file, blockLength...)
+	}
+	for _, data := range blocks {
+		file = append(file, make([]byte, -len(file)&0x3F)...)
+		file = append(file, data...)
+	}
+
+	// The file ends with a 16 byte checksum, which isn't checked when probing, so it's left empty.
+	file = append(file, make([]byte, -len(file)&0x3F+16)...)
+
+	suffix := WDLSuffix
+	if isDTZ {
+		suffix = DTZSuffix
+	}
+	return os.WriteFile(filepath.Join(dir, solution.name+suffix), file, 0644)
+}
+
+// Get the number of groups of pieces of a subtable.
+func tbGenNumGroups(data *pairsData) int {
+	numGroups := 0
+	for data.groupLen[numGroups] != 0 {
+		numGroups++
+	}
+	return numGroups
+}
+
+// Compress the values of a subtable, and return its sizes and Huffman codes, its
+// sparse index, the lengths of its blocks, and its blocks. Positions without a
+// value are given the most common value, since their value is never probed.
+func tbGenCompress(values []int, flags uint8) (sizes, sparseIndex, blockLength, blocks []byte) {
+	freqs := map[int]int{}
+	for _, value := range values {
+		if value != -1 {
+			freqs[value]++
+		}
+	}
+
+	common := 0
+	for value, freq := range freqs {
+		if freq > freqs[common] || (freq == freqs[common] && value < common) {
+			common = value
+		}
+	}
+
+	for idx := range values {
+		if values[idx] == -1 {
+			values[idx] = common
+		}
+	}
+
+	if len(freqs) <= 1 {
+		return []byte{flags | tbFlagSingleValue, byte(common)}, nil, nil, nil
+	}
+
+	// Find the length of the Huffman code of each value, by repeatedly
+	// merging the two least common groups of values.
+	symbols := []int{}
+	for value := range freqs {
+		symbols = append(symbols, value)
+	}
+	sortInts(symbols, func(a, b int) bool { return a < b })
+
+	lengths := map[int]int{}
+	groups := [][]int{}
+	weights := []int{}
+	for _, value := range symbols {
+		groups = append(groups, []int{value})
+		weights = append(weights, freqs[value])
+	}
+
+	for len(groups) > 1 {
+		merged := []int{}
+		weight := 0
+		for n := 0; n < 2; n++ {
+			least := 0
+			for index := range weights {
+				if weights[index] < weights[least] {
+					least = index
+				}
+			}
+
+			for _, value := range groups[least] {
+				lengths[value]++
+			}
+			merged = append(merged, groups[least]...)
+			weight += weights[least]
+
+			groups = append(groups[:least], groups[least+1:]...)
+			weights = append(weights[:least], weights[least+1:]...)
+		}
+		groups = append(groups, merged)
+		weights = append(weights, weight)
+	}
+
+	// Number the symbols from the longest codes to the shortest, and give the symbols
+	// of each length consecutive codes, with the longest codes starting at zero.
+	sortInts(symbols, func(a, b int) bool {
+		return lengths[a] > lengths[b] || (lengths[a] == lengths[b] && a < b)
+	})
+
+	minLen, maxLen := lengths[symbols[len(symbols)-1]], lengths[symbols[0]]
+	if maxLen > 32 {
+		panic("Huffman code too long")
+	}
+
+	lowestSym := make([]int, maxLen-minLen+1)
+	counts := make([]int, maxLen-minLen+1)
+	for _, value := range symbols {
+		counts[lengths[value]-minLen]++
+		for length := minLen; length < lengths[value]; length++ {
+			lowestSym[length-minLen]++
+		}
+	}
+
+	base := make([]int, maxLen-minLen+1)
+	for index := len(base) - 2; index >= 0; index-- {
+		base[index] = (base[index+1] + counts[index+1]) / 2
+	}
+
+	codes := map[int]int{}
+	for sym, value := range symbols {
+		index := lengths[value] - minLen
+		codes[value] = base[index] + sym - lowestSym[index]
+	}
+
+	// Pack the codes into blocks, starting a new block when the next code doesn't fit.
+	blockBits := 8 << tbGenBlockSizeLog
+	blockStarts := []int{}
+	var bits []byte
+
+	for idx, value := range values {
+		if len(blockStarts) == 0 || len(bits)+lengths[value] > blockBits {
+			if len(blockStarts) > 0 {
+				blocks = append(blocks, tbGenPackBits(bits, blockBits)...)
+				binary.LittleEndian.PutUint16(tbGenGrow(&blockLength, 2), uint16(idx-blockStarts[len(blockStarts)-1]-1))
+			}
+			blockStarts = append(blockStarts, idx)
+			bits = bits[:0]
+		}
+
+		for bit := lengths[value] - 1; bit >= 0; bit-- {
+			bits = append(bits, byte(codes[value]>>bit&1))
+		}
+	}
+	blocks = append(blocks, tbGenPackBits(bits, blockBits)...)
+	binary.LittleEndian.PutUint16(tbGenGrow(&blockLength, 2), uint16(len(values)-blockStarts[len(blockStarts)-1]-1))
+
+	// The sparse index stores the block and offset of every value at an index k * span + span / 2.
+	span := 1 << tbGenSpanLog
+	for target, block := span/2, 0; target-span/2 < len(values); target += span {
+		for block+1 < len(blockStarts) && blockStarts[block+1] <= target {
+			block++
+		}
+		entry := tbGenGrow(&sparseIndex, 6)
+		binary.LittleEndian.PutUint32(entry, uint32(block))
+		binary.LittleEndian.PutUint16(entry[4:], uint16(target-blockStarts[block]))
+	}
+
+	sizes = []byte{flags, tbGenBlockSizeLog, tbGenSpanLog, 0}
+	binary.LittleEndian.PutUint32(tbGenGrow(&sizes, 4), uint32(len(blockStarts)))
+	sizes = append(sizes, byte(maxLen), byte(minLen))
+	for _, sym := range lowestSym {
+		binary.LittleEndian.PutUint16(tbGenGrow(&sizes, 2), uint16(sym))
+	}
+
+	// Every symbol is a single value, so it's stored as a leaf of the tree of symbols.
+	binary.LittleEndian.PutUint16(tbGenGrow(&sizes, 2), uint16(len(symbols)))
+	for _, value := range symbols {
+		sizes = append(sizes, byte(value), byte(value>>8)|0xF0, 0xFF)
+	}
+	sizes = append(sizes, make([]byte, len(symbols)&1)...)
+
+	return sizes, sparseIndex, blockLength, blocks
+}
+
+// Pack a block of bits into bytes, with the first bit as the highest bit
+// of the first byte, and pad the block to the given number of bits.
+func tbGenPackBits(bits []byte, blockBits int) []byte {
+	block := make([]byte, blockBits/8)
+	for index, bit := range bits {
+		block[index/8] |= bit << (7 - index%8)
+	}
+	return block
+}
+
+// Grow a slice of bytes by the given size, and return the new bytes.
+func tbGenGrow(buf *[]byte, size int) []byte {
+	*buf = append(*buf, make([]byte, size)...)
+	return (*buf)[len(*buf)-size:]
+}
+
+// Sort a small slice of integers using insertion sort.
+func sortInts(values []int, less func(a, b int) bool) {
+	for i := 1; i < len(values); i++ {
+		for j := i; j > 0 && less(values[j], values[j-1]); j-- {
+			values[j], values[j-1] = values[j-1], values[j]
+		}
+	}
+}
+
+// Solve and write the 3-piece tables, and make sure the tables in testdata/syzygy
+// are the ones written, and that every position of the tables is probed correctly.
+func TestSyzygyGenerator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping solving the 3-piece tables in short mode")
+	}
+
+	testdata := filepath.Join("testdata", "syzygy")
+	dir := t.TempDir()
+	if os.Getenv("SYZYGY_WRITE_TESTDATA") != "" {
+		dir = testdata
+	}
+
+	solved := map[uint8]*tbGenTable{}
+	for _, pieceType := range tbGenPieceTypes {
+		solved[pieceType] = solveTBGenTable(pieceType, solved)
+		for _, isDTZ := range []bool{false, true} {
+			if err := writeTBGenTable(dir, solved[pieceType], isDTZ); err != nil {
+				t.Fatalf("Writing %s failed: %v", solved[pieceType].name, err)
+			}
+		}
+	}
+
+	for _, solution := range solved {
+		for _, suffix := range []string{WDLSuffix, DTZSuffix} {
+			written, err := os.ReadFile(filepath.Join(dir, solution.name+suffix))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			committed, err := os.ReadFile(filepath.Join(testdata, solution.name+suffix))
+			if err != nil || !bytes.Equal(written, committed) {
+				t.Errorf("%s%s in %s isn't the table written by the generator", solution.name, suffix, testdata)
+			}
+		}
+	}
+
+	Syzygy.Init(dir)
+	defer Syzygy.Close()
+
+	var pos Position
+	for _, solution := range solved {
+		for state, legal := range solution.legal {
+			if !legal {
+				continue
+			}
+
+			pos.LoadFEN(tbGenFEN(solution.pieceType, state))
+			wdl, ok := Syzygy.ProbeWDL(&pos)
+			if !ok || wdl != solution.wdl[state] {
+				t.Fatalf("Probing the WDL tables failed for position %s. Got %d instead of %d", pos.GenFEN(), wdl, solution.wdl[state])
+			}
+
+			expectedDTZ := solution.dtz[state] * signOf(int(solution.wdl[state]))
+			dtz, ok := Syzygy.ProbeDTZ(&pos)
+			if !ok || dtz != expectedDTZ {
+				t.Fatalf("Probing the DTZ tables failed for position %s. Got %d instead of %d", pos.GenFEN(), dtz, expectedDTZ)
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// syzygy_test.go provides tests to ensure positions are encoded into Syzygy
+// tables correctly, and that the tables are probed correctly. The probing
+// tests use the tables the SYZYGY_PATH environment variable points to, or
+// else the 3-piece tables in testdata/syzygy.
+
+type SyzygyPosition struct {
+	Fen string
+	WDL int8
+	DTZ int
+}
+
+var SyzygyTestPositions []SyzygyPosition = []SyzygyPosition{
+	{"7k/8/6K1/8/8/8/8/1Q6 w - - 0 1", TBWin, 1},
+	{"7k/8/6K1/8/8/8/8/Q7 b - - 0 1", TBLoss, -2},
+	{"4k3/8/8/8/8/8/8/3QK3 b - - 0 1", TBLoss, -16},
+	{"3qk3/8/8/8/8/8/8/4K3 w - - 0 1", TBLoss, -16},
+	{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", TBDraw, 0},
+	{"8/8/8/8/8/8/3Qk3/7K b - - 0 1", TBDraw, 0},
+	{"4k3/8/4K3/8/8/8/8/R7 w - - 0 1", TBWin, 1},
+	{"4k3/8/4K3/8/8/8/8/R7 b - - 0 1", TBLoss, -4},
+	{"R6k/8/6K1/8/8/8/8/8 b - - 0 1", TBLoss, -1},
+	{"k7/8/2K5/8/8/8/8/7R w - - 0 1", TBWin, 3},
+	{"k7/8/1K6/8/8/8/8/7R b - - 0 1", TBLoss, -2},
+	{"8/8/8/8/8/8/3k4/R3K3 w - - 0 1", TBWin, 23},
+	{"8/8/8/8/8/8/1k6/R3K3 b - - 0 1", TBDraw, 0},
+	{"8/8/4k3/8/8/3K4/3B4/8 w - - 0 1", TBDraw, 0},
+	{"8/8/4k3/8/8/3K4/3B4/8 b - - 0 1", TBDraw, 0},
+	{"8/4P3/8/8/8/8/k7/4K3 w - - 0 1", TBWin, 1},
+	{"8/4P3/8/8/8/8/k7/4K3 b - - 0 1", TBLoss, -2},
+	{"4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", TBWin, 3},
+	{"4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", TBLoss, -4},
+	{"8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", TBDraw, 0},
+	{"k7/8/8/8/8/8/P7/K7 w - - 0 1", TBDraw, 0},
+}
+
+// Create a position holding only the pieces on the given squares,
+// with the given side to move.
+func newTBTestPosition(sideToMove uint8, pieces []Piece, squares []uint8) *Position {
+	pos := Position{SideToMove: sideToMove}
+	for sq := range pos.Squares {
+		pos.Squares[sq] = Piece{NoType, NoColor}
+	}
+
+	for index, piece := range pieces {
+		sq := squares[index]
+		pos.Pieces[piece.Color][piece.Type].SetBit(sq)
+		pos.Sides[piece.Color].SetBit(sq)
+		pos.Squares[sq] = piece
+	}
+
+	return &pos
+}
+
+// Apply one of the eight symmetries of the board to a square.
+func transformSquare(sq uint8, symmetry int) uint8 {
+	if symmetry&1 != 0 {
+		sq ^= 7
+	}
+	if symmetry&2 != 0 {
+		sq ^= 56
+	}
+	if symmetry&4 != 0 {
+		sq = ((sq >> 3) | (sq << 3)) & 63
+	}
+	return sq
+}
+
+func TestSyzygyEncodingTables(t *testing.T) {
+	maxCode := 0
+	for idx := 0; idx < 10; idx++ {
+		for sq := uint8(0); sq < 64; sq++ {
+			maxCode = max(maxCode, mapKK[idx][sq])
+		}
+	}
+
+	if maxCode != 461 {
+		t.Errorf("Expected 462 legal king placements, got %d", maxCode+1)
+	}
+
+	if mapPawns[A2] != 47 || mapPawns[H2] != 46 || mapPawns[E7] != 0 {
+		t.Errorf("Pawn squares mapped incorrectly")
+	}
+
+	for file := 0; file < 4; file++ {
+		if leadPawnsSize[1][file] != 6 {
+			t.Errorf("Expected 6 placements for a leading pawn on file %d, got %d", file, leadPawnsSize[1][file])
+		}
+	}
+}
+
+// Make sure every KQvK position is encoded to an index inside of the table, that
+// positions which are mirrors of each other are encoded to the same index, and
+// that positions which aren't are encoded to different indexes.
+func TestSyzygyEncodingPieces(t *testing.T) {
+	table := newTBTable("KQvK", false)
+	data := table.get(0, 0)
+	data.pieces = [TBMaxPieces]uint8{
+		tbPiece(Piece{King, White}),
+		tbPiece(Piece{Queen, White}),
+		tbPiece(Piece{King, Black}),
+	}
+	table.setGroups(data, [2]int{0, 0xF}, 0)
+
+	pieces := []Piece{{King, White}, {Queen, White}, {King, Black}}
+	tableSize := data.groupIdx[1]
+	indexes := make(map[uint64][3]uint8)
+
+	for wk := uint8(0); wk < 64; wk++ {
+		for wq := uint8(0); wq < 64; wq++ {
+			for bk := uint8(0); bk < 64; bk++ {
+				if wq == wk || wq == bk || kingDistance(wk, bk) <= 1 {
+					continue
+				}
+
+				// Find the mirror of the position which every other
+				// mirror is encoded as the same position as.
+				canonical := [3]uint8{64, 64, 64}
+				for symmetry := 0; symmetry < 8; symmetry++ {
+					squares := [3]uint8{
+						transformSquare(wk, symmetry),
+						transformSquare(wq, symmetry),
+						transformSquare(bk, symmetry),
+					}
+
+					for index := range squares {
+						if squares[index] != canonical[index] {
+							if squares[index] < canonical[index] {
+								canonical = squares
+							}
+							break
+						}
+					}
+				}
+
+				pos := newTBTestPosition(White, pieces, []uint8{wk, wq, bk})
+				_, _, idx, _ := table.encode(pos, table.key)
+
+				if idx >= tableSize {
+					t.Fatalf("Position %v encoded outside of the table: %d >= %d", pos.GenFEN(), idx, tableSize)
+				}
+
+				if other, ok := indexes[idx]; ok && other != canonical {
+					t.Fatalf("Position %v encoded to the same index as a different position", pos.GenFEN())
+				}
+				indexes[idx] = canonical
+			}
+		}
+	}
+}
+
+// Make sure every KPvK position is encoded to an index inside of the table for the file
+// of the pawn, that positions which are mirrors of each other along the middle of the
+// board are encoded to the same index, and that other positions aren't.
+func TestSyzygyEncodingPawns(t *testing.T) {
+	table := newTBTable("KPvK", false)
+	for file := 0; file < 4; file++ {
+		data := table.get(0, file)
+		data.pieces = [TBMaxPieces]uint8{
+			tbPiece(Piece{Pawn, White}),
+			tbPiece(Piece{King, White}),
+			tbPiece(Piece{King, Black}),
+		}
+		table.setGroups(data, [2]int{0, 0xF}, file)
+	}
+
+	pieces := []Piece{{Pawn, White}, {King, White}, {King, Black}}
+	indexes := [4]map[uint64][3]uint8{{}, {}, {}, {}}
+
+	for wp := uint8(A2); wp <= H7; wp++ {
+		for wk := uint8(0); wk < 64; wk++ {
+			for bk := uint8(0); bk < 64; bk++ {
+				if wk == wp || bk == wp || kingDistance(wk, bk) <= 1 {
+					continue
+				}
+
+				canonical := [3]uint8{wp, wk, bk}
+				if FileOf(wp) > 3 {
+					canonical = [3]uint8{wp ^ 7, wk ^ 7, bk ^ 7}
+				}
+
+				pos := newTBTestPosition(White, pieces, []uint8{wp, wk, bk})
+				data, tbFile, idx, _ := table.encode(pos, table.key)
+
+				if tbFile != int(FileOf(canonical[0])) {
+					t.Fatalf("Position %v probed in the wrong subtable: %d", pos.GenFEN(), tbFile)
+				}
+
+				if idx >= data.groupIdx[3] {
+					t.Fatalf("Position %v encoded outside of the table: %d >= %d", pos.GenFEN(), idx, data.groupIdx[3])
+				}
+
+				if other, ok := indexes[tbFile][idx]; ok && other != canonical {
+					t.Fatalf("Position %v encoded to the same index as a different position", pos.GenFEN())
+				}
+				indexes[tbFile][idx] = canonical
+			}
+		}
+	}
+}
+
+// Make sure the tables are found by their names, and that a corrupted table
+// fails to be probed, rather than crashing the engine.
+func TestSyzygyInit(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"KQvK.rtbw", "KRPvKB.rtbw", "KQvK.rtbz"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Syzygy.Init(dir)
+	defer Syzygy.Close()
+
+	if Syzygy.MaxPieces != 5 {
+		t.Errorf("Expected to find 5-piece tablebases, found %d-piece tablebases", Syzygy.MaxPieces)
+	}
+
+	var pos Position
+	pos.LoadFEN("4k3/8/8/8/8/8/8/3QK3 b - - 0 1")
+
+	if _, ok := Syzygy.ProbeWDL(&pos); ok {
+		t.Errorf("Probing a corrupted table should fail")
+	}
+
+	pos.LoadFEN("4k3/8/8/8/8/8/8/3RK3 b - - 0 1")
+	if _, ok := Syzygy.ProbeWDL(&pos); ok {
+		t.Errorf("Probing a table which wasn't found should fail")
+	}
+}
+
+func TestSyzygyProbing(t *testing.T) {
+	path := os.Getenv("SYZYGY_PATH")
+	if path == "" {
+		path = filepath.Join("testdata", "syzygy")
+	}
+
+	Syzygy.Init(path)
+	defer Syzygy.Close()
+
+	if Syzygy.MaxPieces < 3 {
+		t.Fatalf("No Syzygy tables were found in %s", path)
+	}
+
+	var pos Position
+	for _, tbPos := range SyzygyTestPositions {
+		pos.LoadFEN(tbPos.Fen)
+
+		wdl, ok := Syzygy.ProbeWDL(&pos)
+		if !ok || wdl != tbPos.WDL {
+			t.Errorf("Probing the WDL tables failed for position %s. Got %d instead of %d", tbPos.Fen, wdl, tbPos.WDL)
+		}
+
+		dtz, ok := Syzygy.ProbeDTZ(&pos)
+		if !ok || dtz != tbPos.DTZ {
+			t.Errorf("Probing the DTZ tables failed for position %s. Got %d instead of %d", tbPos.Fen, dtz, tbPos.DTZ)
+		}
+	}
+
+	// When a pawn can promote, only the promotions to a queen or a rook keep the win.
+	pos.LoadFEN("8/4P3/8/8/8/8/k7/4K3 w - - 0 1")
+	bestMoves, _, ok := Syzygy.RankRootMoves(&pos, GenLegalMoves(&pos), false)
+	if !ok || !moveInList(moveFromCoord(&pos, "e7e8q"), bestMoves) || moveInList(moveFromCoord(&pos, "e7e8n"), bestMoves) {
+		t.Errorf("Expected e7e8q and not e7e8n to be ranked best, got %v", bestMoves)
+	}
+}
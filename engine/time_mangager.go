@@ -6,6 +6,7 @@ package engine
 // as well as dynamic factors of the search.
 
 import (
+	"sync/atomic"
 	"time"
 )
 
@@ -24,10 +25,20 @@ type TimeManager struct {
 	MaxNodeCount uint64
 	MaxDepth     uint8
 
-	// Fields to calculate when the search should be stopped.
-	Stop        bool
+	// Fields to calculate when the search should be stopped. The stop flag is
+	// set by other goroutines, like the UCI loop or the main search stopping its
+	// helpers, so it's only accessed atomically, through Stop and Stopped.
+	stop        uint32
 	TimeForMove int64
 	stopTime    time.Time
+
+	// While pondering, the search runs until the GUI tells us whether the
+	// expected move was played, so the clock is ignored until then. The time
+	// of the ponderhit, in nanoseconds since the Unix epoch, is set by the UCI
+	// loop while the search is running, so it's only accessed atomically, and
+	// the search starts its clock from it the next time it checks the time.
+	pondering     bool
+	ponderHitTime int64
 }
 
 // Setup the interals of the timer given the "go" command arguments.
@@ -45,7 +56,7 @@ func (tm *TimeManager) Setup(timeLeft, increment, moveTime int64,
 // Start the timer, setting up the internal state.
 func (tm *TimeManager) Start(gamePly uint16) {
 	// Reset the flag time's up flag to false for a new search
-	tm.Stop = false
+	atomic.StoreUint32(&tm.stop, 0)
 
 	// Prioritize the "movetime" argument if a value is given and use that.
 	if tm.MoveTime != NoValue {
@@ -122,14 +133,64 @@ func (tm *TimeManager) Update(newTimeForMove int64) {
 	tm.TimeForMove = newTimeForMove
 }
 
+// Set whether the next search is pondering. This should be called before the
+// search is started, so a ponderhit sent right after it isn't missed.
+func (tm *TimeManager) SetPondering(pondering bool) {
+	tm.pondering = pondering
+	atomic.StoreInt64(&tm.ponderHitTime, 0)
+}
+
+// Check if the search is still pondering, and hasn't been told the move
+// it's pondering on was played.
+func (tm *TimeManager) Pondering() bool {
+	return tm.pondering && atomic.LoadInt64(&tm.ponderHitTime) == 0
+}
+
+// Tell the search the move we were pondering on was played, so it should switch
+// to searching on the real clock, with the time alloted for the move counting
+// from now. This is safe to call from any goroutine.
+func (tm *TimeManager) PonderHit() {
+	atomic.StoreInt64(&tm.ponderHitTime, time.Now().UnixNano())
+}
+
+// Start the clock from the time of the ponderhit, and stop pondering.
+func (tm *TimeManager) startClockAfterPonderHit() {
+	ponderHitTime := time.Unix(0, atomic.LoadInt64(&tm.ponderHitTime))
+	if tm.MoveTime != NoValue {
+		tm.stopTime = ponderHitTime.Add(time.Duration(tm.MoveTime) * time.Millisecond)
+	} else if tm.TimeLeft != InfiniteTime {
+		tm.stopTime = ponderHitTime.Add(time.Duration(tm.TimeForMove) * time.Millisecond)
+	}
+	tm.pondering = false
+}
+
+// Tell the search to stop. This is safe to call from any goroutine.
+func (tm *TimeManager) Stop() {
+	atomic.StoreUint32(&tm.stop, 1)
+}
+
+// Check if the search has been told to stop.
+func (tm *TimeManager) Stopped() bool {
+	return atomic.LoadUint32(&tm.stop) == 1
+}
+
 // Check if the time we alloted for picking this move has expired.
 func (tm *TimeManager) Check() {
 	// If we've already been told to stop before now,
 	// no more work needs to be done.
-	if tm.Stop {
+	if tm.Stopped() {
 		return
 	}
 
+	// If we're pondering, we don't need to check if our time is up, until
+	// we're told the move we're pondering on was played.
+	if tm.pondering {
+		if atomic.LoadInt64(&tm.ponderHitTime) == 0 {
+			return
+		}
+		tm.startClockAfterPonderHit()
+	}
+
 	// If we have infinite time, we don't need to check if our time is up.
 	if tm.TimeLeft == InfiniteTime {
 		return
@@ -137,6 +198,6 @@ func (tm *TimeManager) Check() {
 
 	// Otherwise check if our alloted time is over.
 	if time.Now().After(tm.stopTime) {
-		tm.Stop = true
+		tm.Stop()
 	}
 }
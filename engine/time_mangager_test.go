@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// time_mangager_test.go provides a test to ensure the timer ignores the clock while
+// pondering, and starts it once it's told about a ponderhit from another goroutine.
+
+func TestPonderHit(t *testing.T) {
+	timer := TimeManager{}
+	timer.Setup(InfiniteTime, NoValue, 50, int16(NoValue), MaxDepth, math.MaxUint64)
+	timer.SetPondering(true)
+	timer.Start(0)
+
+	time.Sleep(100 * time.Millisecond)
+	timer.Check()
+
+	if timer.Stopped() || !timer.Pondering() {
+		t.Fatal("Expected the timer to ignore the clock while pondering")
+	}
+
+	ponderHitTime := time.Now()
+	go timer.PonderHit()
+
+	for !timer.Stopped() {
+		timer.Check()
+		if time.Since(ponderHitTime) > 5*time.Second {
+			t.Fatal("Expected the timer to stop after the ponderhit")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if timer.Pondering() || time.Since(ponderHitTime) < 50*time.Millisecond {
+		t.Errorf("Expected the timer to stop 50ms after the ponderhit, not after %v", time.Since(ponderHitTime))
+	}
+}
@@ -3,6 +3,15 @@ package engine
 // transposition.go contains an implementation of a transposition table (TT) to use
 // in searching and perft.
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
 const (
 	// Default size of the transposition table, in MB.
 	DefaultTTSize = 64
@@ -28,9 +37,27 @@ const (
 	// must be below or above to be a checkmate score. The score assumes that the engine
 	// will not find mate in 100.
 	Checkmate = 9000
+
+	// The magic number and version at the start of every saved transposition table file,
+	// and the number of entries read or written at a time when loading or saving one.
+	// Version 2 files store the packed, lockless search entries.
+	TTFileMagic   = "BNTT"
+	TTFileVersion = 2
+	ttFileChunk   = 4096
 )
 
-// A struct for a transposition table entry used in the search.
+// The header of a saved transposition table file. The key fingerprint is the hash of the
+// starting position, which makes sure the file was saved with the same zobrist keys.
+type ttFileHeader struct {
+	Magic          [4]byte
+	Version        uint32
+	EntrySize      uint32
+	NumEntries     uint64
+	KeyFingerprint uint64
+}
+
+// A struct for the fields of a transposition table entry used in the search,
+// once they've been unpacked from the table.
 type SearchEntry struct {
 	Hash       uint64
 	Depth      uint8
@@ -39,6 +66,20 @@ type SearchEntry struct {
 	FlagAndAge uint8
 }
 
+// A struct for a transposition table entry used in the search, as it's stored in
+// the table. The depth, score, best move, flag, and age of the entry are packed into
+// one word of data, and its key is the hash of the position xor-ed with the data.
+//
+// The table is shared by every thread of the search, so both words are read and
+// written atomically. If two threads write to an entry at the same time, the entry
+// can end up with its key from one write and its data from the other. But then the
+// key xor-ed with the data won't give the hash of either position, so the torn entry
+// is ignored when it's probed, without needing a lock.
+type PackedSearchEntry struct {
+	Key  uint64
+	Data uint64
+}
+
 // A struct for a transposition table entry used in perft.
 type PerftEntry struct {
 	Hash  uint64
@@ -46,12 +87,42 @@ type PerftEntry struct {
 	Depth uint8
 }
 
-func (entry SearchEntry) GetHash() uint64 {
-	return entry.Hash
+// Load the fields of the entry from the table.
+func (entry *PackedSearchEntry) Load() (fields SearchEntry) {
+	key := atomic.LoadUint64(&entry.Key)
+	data := atomic.LoadUint64(&entry.Data)
+
+	fields.Hash = key ^ data
+	fields.Depth = uint8(data)
+	fields.Score = int16(data >> 8)
+	fields.Best = Move(data >> 24)
+	fields.FlagAndAge = uint8(data >> 56)
+	return fields
 }
 
-func (entry SearchEntry) GetDepth() uint8 {
-	return entry.Depth
+// Write the fields of an entry to the table.
+func (entry *PackedSearchEntry) Write(fields SearchEntry) {
+	data := uint64(fields.Depth) |
+		uint64(uint16(fields.Score))<<8 |
+		uint64(fields.Best)<<24 |
+		uint64(fields.FlagAndAge)<<56
+
+	atomic.StoreUint64(&entry.Key, fields.Hash^data)
+	atomic.StoreUint64(&entry.Data, data)
+}
+
+// Get the hash, depth, and age of the entry, loaded atomically, since other
+// threads can be writing it.
+func (entry *PackedSearchEntry) Info() (hash uint64, depth, age uint8) {
+	fields := entry.Load()
+	return fields.Hash, fields.Depth, fields.GetAge()
+}
+
+// Set the fields of the entry, as SearchEntry.Set does, and write them to the table.
+func (entry *PackedSearchEntry) Set(hash uint64, score int16, best Move, ply, depth, flag, age uint8) {
+	fields := SearchEntry{}
+	fields.Set(hash, score, best, ply, depth, flag, age)
+	entry.Write(fields)
 }
 
 func (entry SearchEntry) GetFlag() uint8 {
@@ -180,6 +251,10 @@ func (entry PerftEntry) GetDepth() uint8 {
 	return entry.Depth
 }
 
+func (entry *PerftEntry) Info() (hash uint64, depth, age uint8) {
+	return entry.GetHash(), entry.GetDepth(), entry.GetAge()
+}
+
 func (entry *PerftEntry) Get(hash uint64, depth uint8) (nodeCount uint64, ok bool) {
 	if entry.Hash == hash && entry.Depth == depth {
 		return entry.Nodes, true
@@ -193,26 +268,28 @@ func (entry *PerftEntry) Set(hash uint64, depth uint8, nodes uint64) {
 	entry.Nodes = nodes
 }
 
+// The entries a transposition table can hold. An entry gives its hash, depth,
+// and age through a pointer, so the entries of the search can be loaded atomically.
+type TTEntry[Entry any] interface {
+	*Entry
+	Info() (hash uint64, depth, age uint8)
+}
+
 // A struct for a transposition table.
-type TransTable[Entry interface {
-	SearchEntry | PerftEntry
-	GetHash() uint64
-	GetAge() uint8
-	GetDepth() uint8
-}] struct {
+type TransTable[Entry any, EntryPtr TTEntry[Entry]] struct {
 	entries []Entry
 	size    uint64
 }
 
 // Resize the transposition table given what the size should be in MB.
-func (tt *TransTable[Entry]) Resize(sizeInMB uint64, entrySize uint64) {
+func (tt *TransTable[Entry, EntryPtr]) Resize(sizeInMB uint64, entrySize uint64) {
 	size := (sizeInMB * 1024 * 1024) / entrySize
 	tt.entries = make([]Entry, size)
 	tt.size = size
 }
 
 // Get an entry from the table to use it.
-func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
+func (tt *TransTable[Entry, EntryPtr]) Probe(hash uint64) *Entry {
 	// Get the entry from the table, calculating an index by modulo-ing the hash of
 	// the position by the size of the table. A two-bucket system is used to
 	// more efficently make use of the table.
@@ -222,8 +299,7 @@ func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
 		return &tt.entries[index]
 	}
 
-	first := tt.entries[index]
-	if first.GetHash() == hash {
+	if firstHash, _, _ := EntryPtr(&tt.entries[index]).Info(); firstHash == hash {
 		return &tt.entries[index]
 	}
 
@@ -231,18 +307,13 @@ func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
 }
 
 // Get an entry from the table to store in it.
-func (tt *TransTable[Entry]) Store(hash uint64, depth uint8, currAge uint8) *Entry {
+func (tt *TransTable[Entry, EntryPtr]) Store(hash uint64, depth uint8, currAge uint8) *Entry {
 	index := hash % tt.size
 	if index+1 == tt.size {
 		return &tt.entries[index]
 	}
 
-	first := tt.entries[index]
-	if first.GetDepth() <= depth || first.GetAge() != currAge {
-		// Note that returning &first caused a bug where the transposition
-		// table entry was never modifed, and so the table was always empty.
-		// Have to figure out why that is, but for now return &tt.entries[index]
-		// directly.
+	if _, firstDepth, firstAge := EntryPtr(&tt.entries[index]).Info(); firstDepth <= depth || firstAge != currAge {
 		return &tt.entries[index]
 	}
 
@@ -250,14 +321,112 @@ func (tt *TransTable[Entry]) Store(hash uint64, depth uint8, currAge uint8) *Ent
 }
 
 // Unitialize the memory used by the transposition table
-func (tt *TransTable[Entry]) Unitialize() {
+func (tt *TransTable[Entry, EntryPtr]) Unitialize() {
 	tt.entries = nil
 	tt.size = 0
 }
 
 // Clear the transposition table
-func (tt *TransTable[Entry]) Clear() {
+func (tt *TransTable[Entry, EntryPtr]) Clear() {
 	for idx := uint64(0); idx < tt.size; idx++ {
 		tt.entries[idx] = *new(Entry)
 	}
 }
+
+// Create the header of a file to save the transposition table in.
+func (tt *TransTable[Entry, EntryPtr]) fileHeader() ttFileHeader {
+	var pos Position
+	pos.LoadFEN(FENStartPosition)
+
+	header := ttFileHeader{
+		Version:        TTFileVersion,
+		EntrySize:      uint32(binary.Size(*new(Entry))),
+		NumEntries:     tt.size,
+		KeyFingerprint: pos.Hash,
+	}
+	copy(header.Magic[:], TTFileMagic)
+	return header
+}
+
+// Save the transposition table. The file has a header, followed by every entry of the
+// table, and ends with a CRC-32 checksum of everything before it. Every value is stored
+// in little-endian byte order.
+func (tt *TransTable[Entry, EntryPtr]) Save(writer io.Writer) error {
+	checksum := crc32.NewIEEE()
+	writer = io.MultiWriter(writer, checksum)
+
+	header := tt.fileHeader()
+	if err := binary.Write(writer, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	for start := uint64(0); start < tt.size; start += ttFileChunk {
+		end := Min(start+ttFileChunk, tt.size)
+		if err := binary.Write(writer, binary.LittleEndian, tt.entries[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(writer, binary.LittleEndian, checksum.Sum32())
+}
+
+// Load a transposition table saved with Save. The table must be the same size as the
+// table that was saved. If the file can't be loaded, the table is left cleared.
+func (tt *TransTable[Entry, EntryPtr]) Load(reader io.Reader) (err error) {
+	defer func() {
+		if err != nil {
+			tt.Clear()
+		}
+	}()
+
+	checksum := crc32.NewIEEE()
+	teeReader := io.TeeReader(reader, checksum)
+
+	var header ttFileHeader
+	if err := binary.Read(teeReader, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+
+	expected := tt.fileHeader()
+	if header.Magic != expected.Magic {
+		return errors.New("not a transposition table file")
+	}
+
+	if header.Version != expected.Version {
+		return fmt.Errorf("unsupported file version %d", header.Version)
+	}
+
+	if header.EntrySize != expected.EntrySize {
+		return fmt.Errorf("file has %d byte entries, expected %d byte entries", header.EntrySize, expected.EntrySize)
+	}
+
+	if header.KeyFingerprint != expected.KeyFingerprint {
+		return errors.New("file was saved with different zobrist keys")
+	}
+
+	if header.NumEntries != expected.NumEntries {
+		return fmt.Errorf(
+			"file has %d entries (%d MB), but the table has %d entries (%d MB)",
+			header.NumEntries, header.NumEntries*uint64(header.EntrySize)/(1024*1024),
+			expected.NumEntries, expected.NumEntries*uint64(expected.EntrySize)/(1024*1024),
+		)
+	}
+
+	for start := uint64(0); start < tt.size; start += ttFileChunk {
+		end := Min(start+ttFileChunk, tt.size)
+		if err := binary.Read(teeReader, binary.LittleEndian, tt.entries[start:end]); err != nil {
+			return fmt.Errorf("reading entries: %w", err)
+		}
+	}
+
+	sum := checksum.Sum32()
+	var savedSum uint32
+	if err := binary.Read(reader, binary.LittleEndian, &savedSum); err != nil {
+		return fmt.Errorf("reading checksum: %w", err)
+	}
+
+	if sum != savedSum {
+		return errors.New("checksum mismatch, file is corrupted")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// transposition_test.go provides tests to ensure the transposition table
+// is saved and loaded correctly, and that incompatible or corrupted files
+// are rejected.
+
+// Create a search transposition table filled with random entries.
+func newRandomTransTable(sizeInMB uint64, seed int64) *TransTable[PackedSearchEntry, *PackedSearchEntry] {
+	random := rand.New(rand.NewSource(seed))
+	tt := &TransTable[PackedSearchEntry, *PackedSearchEntry]{}
+	tt.Resize(sizeInMB, SearchEntrySize)
+
+	for index := range tt.entries {
+		tt.entries[index].Write(SearchEntry{
+			Hash:       random.Uint64(),
+			Depth:      uint8(random.Intn(MaxDepth)),
+			Score:      int16(random.Intn(2*Checkmate) - Checkmate),
+			Best:       Move(random.Uint32()),
+			FlagAndAge: uint8(random.Intn(256)),
+		})
+	}
+	return tt
+}
+
+// Check if the table has no entries set.
+func isCleared(tt *TransTable[PackedSearchEntry, *PackedSearchEntry]) bool {
+	for _, entry := range tt.entries {
+		if entry != (PackedSearchEntry{}) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTransTableSaving(t *testing.T) {
+	saved := newRandomTransTable(1, 1)
+	buffer := bytes.Buffer{}
+
+	if err := saved.Save(&buffer); err != nil {
+		t.Fatalf("Saving the transposition table failed: %v", err)
+	}
+
+	loaded := &TransTable[PackedSearchEntry, *PackedSearchEntry]{}
+	loaded.Resize(1, SearchEntrySize)
+
+	if err := loaded.Load(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatalf("Loading the transposition table failed: %v", err)
+	}
+
+	for index := range saved.entries {
+		if saved.entries[index] != loaded.entries[index] {
+			t.Fatalf(
+				"Entry %d was loaded incorrectly: expected %+v, got %+v",
+				index, saved.entries[index], loaded.entries[index],
+			)
+		}
+	}
+}
+
+func TestTransTableLoadingErrors(t *testing.T) {
+	buffer := bytes.Buffer{}
+	newRandomTransTable(1, 2).Save(&buffer)
+	file := buffer.Bytes()
+
+	// Corrupt a copy of the saved file, by changing the given byte.
+	corrupt := func(offset int, value byte) []byte {
+		corrupted := append([]byte{}, file...)
+		corrupted[offset] = value
+		return corrupted
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+		size uint64
+	}{
+		{"bad magic", corrupt(0, 'X'), 1},
+		{"bad version", corrupt(4, TTFileVersion+1), 1},
+		{"bad entry size", corrupt(8, byte(SearchEntrySize+1)), 1},
+		{"bad key fingerprint", corrupt(20, file[20]^0xff), 1},
+		{"size mismatch", file, 2},
+		{"corrupted entry", corrupt(len(file)/2, file[len(file)/2]^0xff), 1},
+		{"corrupted checksum", corrupt(len(file)-1, file[len(file)-1]^0xff), 1},
+		{"truncated", file[:len(file)-100], 1},
+		{"empty", []byte{}, 1},
+	}
+
+	for _, test := range tests {
+		tt := newRandomTransTable(test.size, 3)
+		if err := tt.Load(bytes.NewReader(test.file)); err == nil {
+			t.Errorf("Loading a transposition table file with a %s should fail", test.name)
+		}
+
+		if !isCleared(tt) {
+			t.Errorf("The transposition table should be cleared after failing to load a file with a %s", test.name)
+		}
+	}
+}
+
+func TestPackedSearchEntry(t *testing.T) {
+	fields := SearchEntry{Hash: 0x123456789abcdef0, Depth: 17, Score: -Checkmate - 5, Best: Move(0xdeadbeef)}
+	fields.SetFlag(BetaFlag)
+	fields.SetAge(1)
+
+	entry := PackedSearchEntry{}
+	entry.Write(fields)
+
+	if loaded := entry.Load(); loaded != fields {
+		t.Fatalf("Expected the entry %+v to be loaded, got %+v", fields, loaded)
+	}
+
+	// An entry with its key from one write and its data from another
+	// shouldn't match the hash of either position.
+	other := PackedSearchEntry{}
+	other.Write(SearchEntry{Hash: 0x0fedcba987654321, Depth: 3, Score: 25, Best: Move(0x1234)})
+
+	torn := PackedSearchEntry{Key: entry.Key, Data: other.Data}
+	if hash := torn.Load().Hash; hash == fields.Hash || hash == 0x0fedcba987654321 {
+		t.Errorf("Expected a torn entry not to match the hash of either position, got %x", hash)
+	}
+}
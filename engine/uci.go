@@ -8,22 +8,63 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const DefaultBookMoveDelay = 2
 
+// The score in centipawns the final search of a game must be above, or
+// below the negative of, for book learning to count the game as a win, or
+// a loss, for the engine. Any score in between counts as a draw.
+const BookLearnScore = 200
+
+// The default largest game ply a book move is played at, which
+// is high enough to play every move in most books.
+const DefaultBookDepth = 255
+
+// The default file the transposition table is saved to and loaded from.
+const DefaultHashFile = "blunder.hash"
+
 type UCIInterface struct {
 	Search      Search
-	OpeningBook map[uint64][]PolyglotEntry
+	OpeningBook OpeningBook
 
 	OptionUseBook       bool
 	OptionBookPath      string
 	OptionBookMoveDelay int
+	OptionPonder        bool
+
+	// How a book move is selected from the entries of a position, the largest
+	// game ply a book move is played at, and the smallest weight an entry must
+	// have to be played.
+	OptionBookSelection string
+	OptionBookDepth     int
+	OptionBookMinWeight int
+
+	// Whether the learn field of the book entries is used to adjust their weights,
+	// and updated and saved to the book file after each game.
+	OptionBookLearning bool
+
+	// The book moves played in the current game, and the score of the last
+	// search in the current game, if there was one, used for book learning.
+	// They're set by the goroutine running the go command, and read by the
+	// UCI loop, so they're only accessed with the mutex held.
+	learnMutex      sync.Mutex
+	bookMovesPlayed []PolyglotEntry
+	lastScore       int16
+	searched        bool
+
+	// The file the transposition table is saved to and loaded from, and whether
+	// the table should be kept between games, so the work of earlier searches
+	// can be reused.
+	OptionHashFile       string
+	OptionNeverClearHash bool
 }
 
 func (inter *UCIInterface) Reset() {
 	*inter = UCIInterface{}
+	Chess960Notation = false
 }
 
 // Respond to the command "uci"
@@ -31,13 +72,43 @@ func (inter *UCIInterface) uciCommandResponse() {
 	fmt.Printf("\nid name %v\n", EngineName)
 	fmt.Printf("id author %v\n", EngineAuthor)
 	fmt.Printf("\noption name Hash type spin default 64 min 1 max 32000\n")
+	fmt.Printf("option name Threads type spin default 1 min 1 max %d\n", MaxThreads)
+	fmt.Printf("option name MultiPV type spin default 1 min 1 max %d\n", MaxMultiPV)
 	fmt.Print("option name Clear Hash type button\n")
+	fmt.Printf("option name HashFile type string default %s\n", DefaultHashFile)
+	fmt.Print("option name Save Hash type button\n")
+	fmt.Print("option name Load Hash type button\n")
+	fmt.Print("option name NeverClearHash type check default false\n")
 	fmt.Print("option name Clear History type button\n")
 	fmt.Print("option name Clear Killers type button\n")
 	fmt.Print("option name Clear Counters type button\n")
+	fmt.Print("option name Ponder type check default false\n")
+	fmt.Print("option name UCI_Chess960 type check default false\n")
 	fmt.Print("option name UseBook type check default false\n")
 	fmt.Print("option name BookPath type string default\n")
 	fmt.Print("option name BookMoveDelay type spin default 2 min 0 max 10\n")
+	fmt.Printf(
+		"option name BookSelection type combo default %s var %s var %s var %s\n",
+		BookSelectUniform, BookSelectUniform, BookSelectWeighted, BookSelectBest,
+	)
+	fmt.Printf("option name BookDepth type spin default %d min 0 max %d\n", DefaultBookDepth, DefaultBookDepth)
+	fmt.Print("option name BookMinWeight type spin default 0 min 0 max 65535\n")
+	fmt.Print("option name BookLearning type check default false\n")
+	fmt.Print("option name SyzygyPath type string default <empty>\n")
+	fmt.Printf("option name SyzygyProbeLimit type spin default %d min 0 max %d\n", TBMaxPieces, TBMaxPieces)
+	fmt.Print("option name EvalFile type string default <empty>\n")
+	fmt.Print("option name UseNNUE type check default false\n")
+	fmt.Print("option name EvalParamsFile type string default <empty>\n")
+
+	for _, param := range EvalParams {
+		if len(param.Values) > MaxListedEvalParamValues {
+			continue
+		}
+
+		for i, value := range param.Values {
+			fmt.Printf("option name %s type spin default %d min %d max %d\n", param.OptionName(i), *value, param.Min, param.Max)
+		}
+	}
 	fmt.Print("\nAvailable UCI commands:\n")
 
 	fmt.Print("    * uci\n    * isready\n    * ucinewgame")
@@ -51,9 +122,10 @@ func (inter *UCIInterface) uciCommandResponse() {
 	fmt.Print("\n\t* wtime <MILLISECONDS>\n\t* btime <MILLISECONDS>")
 	fmt.Print("\n\t* winc <MILLISECONDS>\n\t* binc <MILLISECONDS>")
 	fmt.Print("\n\t* movestogo <INTEGER>\n\t* depth <INTEGER>\n\t* nodes <INTEGER>\n\t* movetime <MILLISECONDS>")
-	fmt.Print("\n\t* infinite")
+	fmt.Print("\n\t* mate <INTEGER>\n\t* searchmoves <MOVE> ... <MOVE>")
+	fmt.Print("\n\t* infinite\n\t* ponder")
 
-	fmt.Print("\n    * stop\n    * quit\n\n")
+	fmt.Print("\n    * ponderhit\n    * stop\n    * quit\n\n")
 	fmt.Printf("uciok\n\n")
 }
 
@@ -122,14 +194,48 @@ func (inter *UCIInterface) setOptionCommandResponse(command string) {
 			inter.Search.TT.Unitialize()
 			inter.Search.TT.Resize(uint64(size), SearchEntrySize)
 		}
+	case "Threads":
+		numThreads, err := strconv.Atoi(value)
+		if err == nil {
+			inter.Search.SetThreads(numThreads)
+		}
+	case "MultiPV":
+		multiPV, err := strconv.Atoi(value)
+		if err == nil {
+			inter.Search.SetMultiPV(multiPV)
+		}
 	case "Clear Hash":
 		inter.Search.TT.Clear()
+	case "HashFile":
+		inter.OptionHashFile = value
+	case "Save Hash":
+		inter.saveHash()
+	case "Load Hash":
+		inter.loadHash()
+	case "NeverClearHash":
+		if value == "true" {
+			inter.OptionNeverClearHash = true
+		} else if value == "false" {
+			inter.OptionNeverClearHash = false
+		}
 	case "Clear History":
 		inter.Search.ClearHistoryTable()
 	case "Clear Killers":
 		inter.Search.ClearKillers()
 	case "Clear Counters":
 		inter.Search.ClearCounterMoves()
+	case "Ponder":
+		if value == "true" {
+			inter.OptionPonder = true
+		} else if value == "false" {
+			inter.OptionPonder = false
+		}
+	case "UCI_Chess960":
+		if value == "true" {
+			Chess960Notation = true
+		} else if value == "false" {
+			Chess960Notation = false
+		}
 	case "UseBook":
 		if value == "true" {
 			inter.OptionUseBook = true
@@ -137,12 +243,18 @@ func (inter *UCIInterface) setOptionCommandResponse(command string) {
 			inter.OptionUseBook = false
 		}
 	case "BookPath":
+		if inter.OpeningBook != nil {
+			inter.OpeningBook.Close()
+		}
+
 		var err error
-		inter.OpeningBook, err = LoadPolyglotFile(value)
+		inter.OpeningBook, err = OpenPolyglotBook(value)
 
 		if err == nil {
+			inter.OptionBookPath = value
 			fmt.Println("Opening book loaded...")
 		} else {
+			inter.OpeningBook = nil
 			fmt.Println("Failed to load opening book...")
 		}
 	case "BookMoveDelay":
@@ -150,20 +262,137 @@ func (inter *UCIInterface) setOptionCommandResponse(command string) {
 		if err == nil {
 			inter.OptionBookMoveDelay = size
 		}
+	case "BookSelection":
+		if value == BookSelectUniform || value == BookSelectWeighted || value == BookSelectBest {
+			inter.OptionBookSelection = value
+		}
+	case "BookDepth":
+		depth, err := strconv.Atoi(value)
+		if err == nil {
+			inter.OptionBookDepth = depth
+		}
+	case "BookMinWeight":
+		weight, err := strconv.Atoi(value)
+		if err == nil {
+			inter.OptionBookMinWeight = max(0, Min(weight, math.MaxUint16))
+		}
+	case "BookLearning":
+		if value == "true" {
+			inter.OptionBookLearning = true
+		} else if value == "false" {
+			inter.OptionBookLearning = false
+		}
+	case "SyzygyPath":
+		Syzygy.Init(value)
+		fmt.Printf("info string found %d-piece tablebases\n", Syzygy.MaxPieces)
+	case "SyzygyProbeLimit":
+		probeLimit, err := strconv.Atoi(value)
+		if err == nil {
+			Syzygy.ProbeLimit = max(0, Min(probeLimit, TBMaxPieces))
+		}
+	case "EvalFile":
+		network, err := LoadNetwork(value)
+		if err == nil {
+			SetNetwork(network)
+			inter.Search.Pos.RefreshAccumulators()
+			fmt.Printf("info string loaded network %s\n", value)
+		} else {
+			fmt.Printf("info string failed to load network: %v\n", err)
+		}
+	case "UseNNUE":
+		if value == "true" {
+			SetUseNNUE(true)
+		} else if value == "false" {
+			SetUseNNUE(false)
+		}
+		inter.Search.Pos.RefreshAccumulators()
+	case "EvalParamsFile":
+		if err := LoadEvalParams(value); err == nil {
+			inter.evalParamsChanged()
+			fmt.Printf("info string loaded eval params %s\n", value)
+		} else {
+			fmt.Printf("info string failed to load eval params: %v\n", err)
+		}
+	default:
+		if found, err := SetEvalParamOption(option, value); found && err == nil {
+			inter.evalParamsChanged()
+		} else if found {
+			fmt.Printf("info string failed to set %s: %v\n", option, err)
+		}
 	}
 }
 
+// Update the search after the parameters of the evaluation have changed. The
+// scores of the current position and those in the transposition table were
+// computed with the old parameters, so they're recomputed and cleared.
+func (inter *UCIInterface) evalParamsChanged() {
+	inter.Search.Pos.RefreshScores()
+	inter.Search.TT.Clear()
+}
+
+// Save the transposition table to the hash file.
+func (inter *UCIInterface) saveHash() {
+	file, err := os.Create(inter.OptionHashFile)
+	if err == nil {
+		writer := bufio.NewWriter(file)
+		err = inter.Search.TT.Save(writer)
+		if err == nil {
+			err = writer.Flush()
+		}
+		file.Close()
+	}
+
+	if err == nil {
+		fmt.Printf("info string saved hash to %s\n", inter.OptionHashFile)
+	} else {
+		fmt.Printf("info string failed to save hash: %v\n", err)
+	}
+}
+
+// Load the transposition table from the hash file.
+func (inter *UCIInterface) loadHash() {
+	file, err := os.Open(inter.OptionHashFile)
+	if err == nil {
+		err = inter.Search.TT.Load(bufio.NewReader(file))
+		file.Close()
+	}
+
+	if err == nil {
+		fmt.Printf("info string loaded hash from %s\n", inter.OptionHashFile)
+	} else {
+		fmt.Printf("info string failed to load hash: %v\n", err)
+	}
+}
+
+// Find the move matching the given coordinate notation in a list of moves.
+func findMove(moves []Move, moveAsString string) (Move, bool) {
+	for _, move := range moves {
+		if move.String() == moveAsString {
+			return move, true
+		}
+	}
+	return NullMove, false
+}
+
 // Respond to the command "go"
 func (inter *UCIInterface) goCommandResponse(command string) {
-	if inter.OptionUseBook {
-		if entries, ok := inter.OpeningBook[GenPolyglotHash(&inter.Search.Pos)]; ok {
-
-			// To allow opening variety, randomly select a move from an entry matching
-			// the current position.
-			entry := entries[rand.Intn(len(entries))]
+	// Don't play a book move while pondering, since we're not
+	// allowed to report a best move until the GUI tells us to.
+	if inter.OptionUseBook && inter.OpeningBook != nil &&
+		!inter.Search.Timer.Pondering() && int(inter.Search.Pos.Ply) < inter.OptionBookDepth {
+		entries := inter.OpeningBook.Probe(GenPolyglotHash(&inter.Search.Pos))
+
+		// Select a move from the entries matching the current position, which
+		// for the uniform and weighted policies allows for opening variety.
+		selection, minWeight := inter.OptionBookSelection, uint16(inter.OptionBookMinWeight)
+		if entry, ok := SelectBookEntry(entries, selection, minWeight, inter.OptionBookLearning); ok {
 			move := moveFromCoord(&inter.Search.Pos, entry.Move)
 
 			if inter.Search.Pos.MoveIsPseduoLegal(move) {
+				inter.learnMutex.Lock()
+				inter.bookMovesPlayed = append(inter.bookMovesPlayed, entry)
+				inter.learnMutex.Unlock()
+
 				time.Sleep(time.Duration(inter.OptionBookMoveDelay) * time.Second)
 				fmt.Printf("bestmove %v\n", move)
 				return
@@ -183,8 +412,23 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 	// Parse the go command arguments.
 	timeLeft, increment, movesToGo := int(InfiniteTime), int(NoValue), int(NoValue)
 	maxDepth, maxNodeCount, moveTime := uint64(MaxDepth), uint64(math.MaxUint64), uint64(NoValue)
+	mateDepth := uint64(0)
+
+	inter.Search.SearchMoves = nil
+	legalMoves := inter.Search.rootMoves()
+	parsingSearchMoves := false
 
 	for index, field := range fields {
+		// The moves following "searchmoves" are parsed until
+		// another go command argument is reached.
+		if parsingSearchMoves {
+			if move, ok := findMove(legalMoves, field); ok {
+				inter.Search.SearchMoves = append(inter.Search.SearchMoves, move)
+				continue
+			}
+			parsingSearchMoves = false
+		}
+
 		if strings.HasPrefix(field, colorPrefix) {
 			if strings.HasSuffix(field, "time") {
 				timeLeft, _ = strconv.Atoi(fields[index+1])
@@ -199,9 +443,15 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 			maxNodeCount, _ = strconv.ParseUint(fields[index+1], 10, 64)
 		} else if field == "movetime" {
 			moveTime, _ = strconv.ParseUint(fields[index+1], 10, 64)
+		} else if field == "mate" {
+			mateDepth, _ = strconv.ParseUint(fields[index+1], 10, 8)
+		} else if field == "searchmoves" {
+			parsingSearchMoves = true
 		}
 	}
 
+	inter.Search.MateDepth = uint8(mateDepth)
+
 	// Setup the timer with the go command time control information.
 	inter.Search.Timer.Setup(
 		int64(timeLeft),
@@ -212,12 +462,64 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 		maxNodeCount,
 	)
 
-	// Report the best move found by the engine to the GUI.
 	bestMove := inter.Search.Search()
-	fmt.Printf("bestmove %v\n", bestMove)
+	inter.learnMutex.Lock()
+	inter.lastScore, inter.searched = inter.Search.BestScore(), true
+	inter.learnMutex.Unlock()
+
+	// If we finished searching while still pondering, wait until the
+	// GUI tells us to stop, or that the move we pondered on was played,
+	// before reporting the best move.
+	for inter.Search.Timer.Pondering() && !inter.Search.Timer.Stopped() {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	// Report the best move found by the engine to the GUI, and the
+	// move we expect to be played in reply if we're allowed to ponder.
+	ponderMove := inter.Search.PonderMove()
+	if inter.OptionPonder && ponderMove != NullMove {
+		fmt.Printf("bestmove %v ponder %v\n", bestMove, ponderMove)
+	} else {
+		fmt.Printf("bestmove %v\n", bestMove)
+	}
+}
+
+// Update the learn field of the book moves played in the game which just finished,
+// using the score of the last search to decide how the game went, and write the
+// updated book back to its file.
+func (inter *UCIInterface) learnFromGame() {
+	inter.learnMutex.Lock()
+	bookMovesPlayed, lastScore, searched := inter.bookMovesPlayed, inter.lastScore, inter.searched
+	inter.bookMovesPlayed, inter.searched = nil, false
+	inter.learnMutex.Unlock()
+
+	if !inter.OptionBookLearning || inter.OpeningBook == nil || len(bookMovesPlayed) == 0 || !searched {
+		return
+	}
+
+	halfPoints := uint32(1)
+	if lastScore >= BookLearnScore {
+		halfPoints = 2
+	} else if lastScore <= -BookLearnScore {
+		halfPoints = 0
+	}
+
+	for _, played := range bookMovesPlayed {
+		inter.OpeningBook.AddResult(played, halfPoints)
+	}
+
+	if err := inter.OpeningBook.Save(); err == nil {
+		fmt.Printf("info string updated book %s\n", inter.OptionBookPath)
+	} else {
+		fmt.Printf("info string failed to update book: %v\n", err)
+	}
 }
 
 func (inter *UCIInterface) quitCommandResponse() {
+	inter.learnFromGame()
+	if inter.OpeningBook != nil {
+		inter.OpeningBook.Close()
+	}
 	inter.Search.TT.Unitialize()
 }
 
@@ -231,8 +533,10 @@ func (inter *UCIInterface) UCILoop() {
 	inter.Search.TT.Resize(DefaultTTSize, SearchEntrySize)
 	inter.Search.Setup(FENStartPosition)
 
-	inter.OpeningBook = make(map[uint64][]PolyglotEntry)
 	inter.OptionBookMoveDelay = DefaultBookMoveDelay
+	inter.OptionBookSelection = BookSelectUniform
+	inter.OptionBookDepth = DefaultBookDepth
+	inter.OptionHashFile = DefaultHashFile
 
 	for {
 		command, _ := reader.ReadString('\n')
@@ -245,13 +549,29 @@ func (inter *UCIInterface) UCILoop() {
 		} else if strings.HasPrefix(command, "setoption") {
 			inter.setOptionCommandResponse(command)
 		} else if strings.HasPrefix(command, "ucinewgame") {
-			inter.Search.Reset()
+			inter.learnFromGame()
+			if inter.OptionNeverClearHash {
+				inter.Search.ResetHeuristics()
+			} else {
+				inter.Search.Reset()
+			}
 		} else if strings.HasPrefix(command, "position") {
 			inter.positionCommandResponse(command)
 		} else if strings.HasPrefix(command, "go") {
+			// Set whether we're pondering before starting the search, so
+			// a "ponderhit" sent right after the "go" command isn't missed.
+			pondering := false
+			for _, field := range strings.Fields(command) {
+				if field == "ponder" {
+					pondering = true
+				}
+			}
+			inter.Search.Timer.SetPondering(pondering)
 			go inter.goCommandResponse(command)
+		} else if strings.HasPrefix(command, "ponderhit") {
+			inter.Search.Timer.PonderHit()
 		} else if strings.HasPrefix(command, "stop") {
-			inter.Search.Timer.Stop = true
+			inter.Search.Timer.Stop()
 		} else if command == "quit\n" {
 			inter.quitCommandResponse()
 			break
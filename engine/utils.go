@@ -95,14 +95,10 @@ func (prng *PseduoRandomGenerator) SparseRandom64() uint64 {
 // Convert a move in short algebraic notation, to the long algebraic notation used
 // by the UCI protocol.
 func ConvertSANToLAN(pos *Position, moveStr string) Move {
-	if moveStr == "O-O" && pos.SideToMove == White {
-		return NewMove(E1, G1, Castle, NoFlag)
-	} else if moveStr == "O-O" && pos.SideToMove == Black {
-		return NewMove(E8, G8, Castle, NoFlag)
-	} else if moveStr == "O-O-O" && pos.SideToMove == White {
-		return NewMove(E1, C1, Castle, NoFlag)
-	} else if moveStr == "O-O-O" && pos.SideToMove == Black {
-		return NewMove(E8, C8, Castle, NoFlag)
+	if moveStr == "O-O" {
+		return pos.castlingMove(pos.SideToMove, Kingside)
+	} else if moveStr == "O-O-O" {
+		return pos.castlingMove(pos.SideToMove, Queenside)
 	}
 
 	coords := ""
@@ -187,3 +183,73 @@ func ConvertSANToLAN(pos *Position, moveStr string) Move {
 
 	return matchingMove
 }
+
+// Convert a legal move into short algebraic notation, including whether
+// it gives check or checkmate.
+func ConvertMoveToSAN(pos *Position, move Move) string {
+	from, to := move.FromSq(), move.ToSq()
+	moved := pos.Squares[from].Type
+	san := ""
+
+	if move.MoveType() == Castle {
+		san = "O-O"
+		if to < from {
+			san = "O-O-O"
+		}
+	} else if moved == Pawn {
+		if move.MoveType() == Attack {
+			san = string(rune('a'+FileOf(from))) + "x"
+		}
+		san += posToCoordinate(to)
+
+		if move.MoveType() == Promotion {
+			if pos.Squares[to].Type != NoType {
+				san = string(rune('a'+FileOf(from))) + "x" + san
+			}
+			san += "=" + string("NBRQ"[move.Flag()])
+		}
+	} else {
+		san = string(unicode.ToUpper(PieceTypeToChar[moved]))
+
+		// Disambiguate the move if another piece of the same type
+		// can also legally move to the same square.
+		sameFile, sameRank, ambiguous := false, false, false
+		for _, other := range GenLegalMoves(pos) {
+			otherFrom := other.FromSq()
+			if other.ToSq() != to || otherFrom == from || pos.Squares[otherFrom].Type != moved {
+				continue
+			}
+
+			ambiguous = true
+			sameFile = sameFile || FileOf(otherFrom) == FileOf(from)
+			sameRank = sameRank || RankOf(otherFrom) == RankOf(from)
+		}
+
+		if ambiguous {
+			if !sameFile {
+				san += posToCoordinate(from)[:1]
+			} else if !sameRank {
+				san += posToCoordinate(from)[1:]
+			} else {
+				san += posToCoordinate(from)
+			}
+		}
+
+		if move.MoveType() == Attack {
+			san += "x"
+		}
+		san += posToCoordinate(to)
+	}
+
+	pos.DoMove(move)
+	if pos.InCheck() {
+		if len(GenLegalMoves(pos)) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	pos.UndoMove(move)
+
+	return san
+}
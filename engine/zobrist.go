@@ -27,16 +27,20 @@ type _Zobrist struct {
 	// that will be xor-ed together with other unique random numbers from the positions
 	// other aspects to create a unique zobrist hash.
 	//
-	// Blunder uses 794 unique random numbers:
+	// Blunder uses 841 unique random numbers:
 	// * 12x64 for each type of piece on each possible square.
 	// * 8 for each possible en passant file
-	// * 16 for each possible permutation of the castling right bits.
+	// * 64 for each square a rook with a castling right can be on.
 	// * 1 for when it's white to move
-
-	pieceSqRand64        [768]uint64
-	epFileRand64         [9]uint64
-	castlingRightsRand64 [16]uint64
-	sideToMoveRand64     uint64
+	//
+	// Castling rights are hashed using the squares of the rooks they belong
+	// to, rather than just which rights are left, since in Chess960 the same
+	// castling right can belong to rooks on different squares.
+
+	pieceSqRand64      [768]uint64
+	epFileRand64       [9]uint64
+	castlingRookRand64 [64]uint64
+	sideToMoveRand64   uint64
 }
 
 // Populate the zobrist arrays with random 64-bit numbers.
@@ -54,8 +58,8 @@ func (zobrist *_Zobrist) init() {
 
 	zobrist.epFileRand64[NoEPFile] = 0
 
-	for index := 0; index < 16; index++ {
-		zobrist.castlingRightsRand64[index] = prng.Random64()
+	for index := 0; index < 64; index++ {
+		zobrist.castlingRookRand64[index] = prng.Random64()
 	}
 
 	zobrist.sideToMoveRand64 = prng.Random64()
@@ -74,9 +78,14 @@ func (zobrist *_Zobrist) EPNumber(epSq uint8) uint64 {
 }
 
 // Get the unique random number corresponding to castling bits permutation
-// given.
-func (zobrist *_Zobrist) CastlingNumber(castlingRights uint8) uint64 {
-	return zobrist.castlingRightsRand64[castlingRights]
+// given, and the squares of the rooks each castling right belongs to.
+func (zobrist *_Zobrist) CastlingNumber(castlingRights uint8, castlingRookSqs [4]uint8) (number uint64) {
+	for index, rookSq := range castlingRookSqs {
+		if castlingRights&(1<<index) != 0 {
+			number ^= zobrist.castlingRookRand64[rookSq]
+		}
+	}
+	return number
 }
 
 // Get the unique random number corresponding to the side to move given.
@@ -96,7 +105,7 @@ func (zobrist *_Zobrist) GenHash(pos *Position) (hash uint64) {
 	}
 
 	hash ^= zobrist.EPNumber(pos.EPSq)
-	hash ^= zobrist.CastlingNumber(pos.CastlingRights)
+	hash ^= zobrist.CastlingNumber(pos.CastlingRights, pos.castlingRookSqs)
 
 	if pos.SideToMove == White {
 		hash ^= zobrist.SideToMoveNumber(pos.SideToMove)
@@ -0,0 +1,277 @@
+package match
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// engine.go implements running a UCI engine as a subprocess, and talking to it.
+
+const (
+	// How long an engine is given to start up, to respond to "isready",
+	// and to quit, before it's considered unresponsive.
+	StartupTimeout = 30 * time.Second
+	ReadyTimeout   = 10 * time.Second
+	QuitTimeout    = 2 * time.Second
+)
+
+var errEngineTimeout = errors.New("engine timed out")
+var errEngineExited = errors.New("engine exited")
+var errEngineStalled = errors.New("engine stopped responding")
+
+// The settings used to run an engine.
+type EngineConfig struct {
+	// The name of the engine used in the results and PGNs. If it's
+	// empty, the name the engine reports is used.
+	Name string
+
+	// The command and arguments used to start the engine.
+	Command string
+	Args    []string
+
+	// The UCI options set after the engine is started, in order.
+	Options [][2]string
+}
+
+// A UCI engine running as a subprocess.
+type uciEngine struct {
+	config EngineConfig
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string
+}
+
+// The information an engine gave about the last move it searched.
+type searchInfo struct {
+	Depth   int
+	Score   string
+	Elapsed time.Duration
+}
+
+// Start an engine, initialize UCI mode, and set its options.
+func startEngine(config EngineConfig) (*uciEngine, error) {
+	cmd := exec.Command(config.Command, config.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	engine := &uciEngine{
+		config: config,
+		name:   config.Name,
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan string, 256),
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for scanner.Scan() {
+			engine.lines <- scanner.Text()
+		}
+		close(engine.lines)
+	}()
+
+	engine.send("uci")
+	for {
+		line, err := engine.readLine(time.Now().Add(StartupTimeout))
+		if err != nil {
+			engine.kill()
+			return nil, fmt.Errorf("%s: waiting for uciok: %w", config.Command, err)
+		}
+
+		if strings.HasPrefix(line, "id name ") && engine.name == "" {
+			engine.name = strings.TrimPrefix(line, "id name ")
+		} else if line == "uciok" {
+			break
+		}
+	}
+
+	if engine.name == "" {
+		engine.name = config.Command
+	}
+
+	for _, option := range config.Options {
+		engine.send("setoption name %s value %s", option[0], option[1])
+	}
+
+	if err := engine.isReady(); err != nil {
+		engine.kill()
+		return nil, fmt.Errorf("%s: %w", engine.name, err)
+	}
+	return engine, nil
+}
+
+// Send a command to the engine.
+func (engine *uciEngine) send(format string, args ...interface{}) {
+	fmt.Fprintf(engine.stdin, format+"\n", args...)
+}
+
+// Read the next line the engine outputs, waiting until the given deadline.
+func (engine *uciEngine) readLine(deadline time.Time) (string, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case line, ok := <-engine.lines:
+		if !ok {
+			return "", errEngineExited
+		}
+		return strings.TrimSpace(line), nil
+	case <-timer.C:
+		return "", errEngineTimeout
+	}
+}
+
+// Wait until the engine is ready to receive more commands.
+func (engine *uciEngine) isReady() error {
+	engine.send("isready")
+	deadline := time.Now().Add(ReadyTimeout)
+
+	for {
+		line, err := engine.readLine(deadline)
+		if err != nil {
+			return err
+		}
+
+		if line == "readyok" {
+			return nil
+		}
+	}
+}
+
+// Tell the engine a new game is starting.
+func (engine *uciEngine) newGame() error {
+	engine.send("ucinewgame")
+	return engine.isReady()
+}
+
+// Have the engine search the given position with the given "go" command, and
+// return the move it picks. The engine has until the deadline to respond.
+func (engine *uciEngine) bestMove(position, goCommand string, deadline time.Time) (move string, info searchInfo, err error) {
+	engine.send(position)
+	engine.send(goCommand)
+	start := time.Now()
+
+	for {
+		line, err := engine.readLine(deadline)
+		info.Elapsed = time.Since(start)
+
+		if err == errEngineTimeout && !engine.stop() {
+			return "", info, errEngineStalled
+		} else if err != nil {
+			return "", info, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "info" {
+			parseSearchInfo(fields, &info)
+		} else if fields[0] == "bestmove" && len(fields) > 1 {
+			return fields[1], info, nil
+		}
+	}
+}
+
+// Stop an engine which is still searching after its time has run out, and
+// wait a moment for it to give its best move so it's ready for the next game.
+// Return false if it never does.
+func (engine *uciEngine) stop() bool {
+	engine.send("stop")
+	deadline := time.Now().Add(QuitTimeout)
+
+	for {
+		line, err := engine.readLine(deadline)
+		if err != nil {
+			return false
+		} else if strings.HasPrefix(line, "bestmove") {
+			return true
+		}
+	}
+}
+
+// Tell the engine to quit, and kill it if it doesn't quit in time.
+func (engine *uciEngine) quit() {
+	engine.send("quit")
+
+	exited := make(chan struct{})
+	go func() {
+		engine.cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(QuitTimeout):
+		engine.cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// Kill the engine's process.
+func (engine *uciEngine) kill() {
+	engine.cmd.Process.Kill()
+	engine.cmd.Wait()
+}
+
+// Update the search information from an info line.
+func parseSearchInfo(fields []string, info *searchInfo) {
+	if len(fields) > 1 && fields[1] == "string" {
+		return
+	}
+
+	// Ignore the info lines of a MultiPV search other than the first line.
+	for index := 0; index < len(fields)-1; index++ {
+		if fields[index] == "multipv" && fields[index+1] != "1" {
+			return
+		}
+	}
+
+	for index := 0; index < len(fields)-1; index++ {
+		switch fields[index] {
+		case "depth":
+			if depth, err := strconv.Atoi(fields[index+1]); err == nil {
+				info.Depth = depth
+			}
+		case "score":
+			if index+2 >= len(fields) {
+				continue
+			}
+
+			value, err := strconv.Atoi(fields[index+2])
+			if err != nil {
+				continue
+			}
+
+			if fields[index+1] == "cp" {
+				info.Score = fmt.Sprintf("%+.2f", float64(value)/100)
+			} else if fields[index+1] == "mate" {
+				if value > 0 {
+					info.Score = fmt.Sprintf("+M%d", value)
+				} else {
+					info.Score = fmt.Sprintf("-M%d", -value)
+				}
+			}
+		}
+	}
+}
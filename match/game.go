@@ -0,0 +1,356 @@
+package match
+
+import (
+	"blunder/engine"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// game.go implements playing a single game between two engines, and writing it out as a PGN.
+
+// The time control of a game. If MoveTime is set, each move is given a fixed amount of time.
+// Otherwise, each side starts with Time on their clock, and gets Increment after each move.
+// If Moves is set, Time is added to a side's clock again after every Moves moves.
+type TimeControl struct {
+	Moves     int
+	Time      time.Duration
+	Increment time.Duration
+	MoveTime  time.Duration
+}
+
+// Parse a time control in the format "[moves/]time[+increment]", where the
+// times are given in seconds, such as "40/60", "10+0.1", or "40/120+1".
+func ParseTimeControl(str string) (tc TimeControl, err error) {
+	if moves, rest, found := strings.Cut(str, "/"); found {
+		if tc.Moves, err = strconv.Atoi(moves); err != nil || tc.Moves <= 0 {
+			return tc, fmt.Errorf("invalid time control %s", str)
+		}
+		str = rest
+	}
+
+	base, increment, found := strings.Cut(str, "+")
+	if tc.Time, err = parseSeconds(base); err != nil || tc.Time <= 0 {
+		return tc, fmt.Errorf("invalid time control %s", str)
+	}
+
+	if found {
+		if tc.Increment, err = parseSeconds(increment); err != nil || tc.Increment < 0 {
+			return tc, fmt.Errorf("invalid time control %s", str)
+		}
+	}
+	return tc, nil
+}
+
+// Format the time control the way it's given in the TimeControl tag of a PGN.
+func (tc TimeControl) String() string {
+	if tc.MoveTime > 0 {
+		return fmt.Sprintf("1/%g", tc.MoveTime.Seconds())
+	}
+
+	str := fmt.Sprintf("%g", tc.Time.Seconds())
+	if tc.Moves > 0 {
+		str = fmt.Sprintf("%d/%s", tc.Moves, str)
+	}
+	if tc.Increment > 0 {
+		str += fmt.Sprintf("+%g", tc.Increment.Seconds())
+	}
+	return str
+}
+
+// Parse a number of seconds into a duration.
+func parseSeconds(str string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(str, 64)
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// A move played in a game, and the comment shown after it in the PGN.
+type gameMove struct {
+	SAN     string
+	Comment string
+}
+
+// A game played between two engines.
+type Game struct {
+	Round   int
+	Date    time.Time
+	White   string
+	Black   string
+	Opening Opening
+	TC      TimeControl
+
+	Moves       []gameMove
+	Result      string
+	Termination string
+	Reason      string
+}
+
+// The result of a game in PGN notation, for a game won by the given color.
+func winResult(color uint8) string {
+	if color == engine.White {
+		return "1-0"
+	}
+	return "0-1"
+}
+
+// The name of the given color, as used in describing how a game ended.
+func colorName(color uint8) string {
+	if color == engine.White {
+		return "White"
+	}
+	return "Black"
+}
+
+// Play a game between two engines, where players[engine.White] plays white, from the given
+// opening. The engines are given margin extra time for each move before losing on time.
+// Return the game, and whether each engine should be restarted before its next game,
+// because it crashed or stopped responding.
+func playGame(players [2]*uciEngine, opening Opening, tc TimeControl, margin time.Duration) (game Game, failed [2]bool) {
+	game = Game{
+		Date:    time.Now(),
+		White:   players[engine.White].name,
+		Black:   players[engine.Black].name,
+		Opening: opening,
+		TC:      tc,
+		Result:  "*",
+	}
+
+	for color, player := range players {
+		if err := player.newGame(); err != nil {
+			failed[color] = true
+			game.Result = winResult(uint8(color) ^ 1)
+			game.Termination = "abandoned"
+			game.Reason = fmt.Sprintf("%s disconnects", colorName(uint8(color)))
+			return game, failed
+		}
+	}
+
+	var pos engine.Position
+	pos.LoadFEN(opening.FEN)
+	hashes := []uint64{pos.Hash}
+	uciMoves := []string{}
+
+	// Play the book moves of the opening.
+	for _, moveStr := range opening.Moves {
+		move, ok := findLegalMove(&pos, moveStr)
+		if !ok {
+			break
+		}
+
+		game.Moves = append(game.Moves, gameMove{engine.ConvertMoveToSAN(&pos, move), "book"})
+		uciMoves = append(uciMoves, moveStr)
+		makeMove(&pos, move)
+		hashes = append(hashes, pos.Hash)
+	}
+
+	clocks := [2]time.Duration{tc.Time, tc.Time}
+	movesPlayed := [2]int{}
+
+	for {
+		if result, reason, over := gameOver(&pos, hashes); over {
+			game.Result, game.Termination, game.Reason = result, "normal", reason
+			return game, failed
+		}
+
+		side := pos.SideToMove
+		player := players[side]
+
+		positionCommand := "position fen " + opening.FEN
+		if len(uciMoves) > 0 {
+			positionCommand += " moves " + strings.Join(uciMoves, " ")
+		}
+
+		goCommand := ""
+		deadline := time.Now().Add(margin)
+
+		if tc.MoveTime > 0 {
+			goCommand = fmt.Sprintf("go movetime %d", tc.MoveTime.Milliseconds())
+			deadline = deadline.Add(tc.MoveTime)
+		} else {
+			goCommand = fmt.Sprintf(
+				"go wtime %d btime %d winc %d binc %d",
+				clocks[engine.White].Milliseconds(), clocks[engine.Black].Milliseconds(),
+				tc.Increment.Milliseconds(), tc.Increment.Milliseconds(),
+			)
+			if tc.Moves > 0 {
+				goCommand += fmt.Sprintf(" movestogo %d", tc.Moves-movesPlayed[side]%tc.Moves)
+			}
+			deadline = deadline.Add(clocks[side])
+		}
+
+		moveStr, info, err := player.bestMove(positionCommand, goCommand, deadline)
+		if err != nil {
+			game.Result = winResult(side ^ 1)
+			if err == errEngineTimeout || err == errEngineStalled {
+				game.Termination = "time forfeit"
+				game.Reason = fmt.Sprintf("%s loses on time", colorName(side))
+			} else {
+				game.Termination = "abandoned"
+				game.Reason = fmt.Sprintf("%s disconnects", colorName(side))
+			}
+
+			// Restart an engine which crashed, or didn't stop searching when told to.
+			failed[side] = err != errEngineTimeout
+			return game, failed
+		}
+
+		move, ok := findLegalMove(&pos, moveStr)
+		if !ok {
+			game.Result = winResult(side ^ 1)
+			game.Termination = "rules infraction"
+			game.Reason = fmt.Sprintf("%s makes an illegal move: %s", colorName(side), moveStr)
+			return game, failed
+		}
+
+		if tc.MoveTime == 0 {
+			clocks[side] -= info.Elapsed
+			if clocks[side] < 0 {
+				clocks[side] = 0
+			}
+			clocks[side] += tc.Increment
+
+			movesPlayed[side]++
+			if tc.Moves > 0 && movesPlayed[side]%tc.Moves == 0 {
+				clocks[side] += tc.Time
+			}
+		}
+
+		comment := fmt.Sprintf("%.3fs", info.Elapsed.Seconds())
+		if info.Score != "" {
+			comment = fmt.Sprintf("%s/%d %s", info.Score, info.Depth, comment)
+		}
+
+		game.Moves = append(game.Moves, gameMove{engine.ConvertMoveToSAN(&pos, move), comment})
+		uciMoves = append(uciMoves, moveStr)
+		makeMove(&pos, move)
+		hashes = append(hashes, pos.Hash)
+	}
+}
+
+// Determine if the game is over, and if so, its result and the reason it ended.
+func gameOver(pos *engine.Position, hashes []uint64) (result, reason string, over bool) {
+	if len(engine.GenLegalMoves(pos)) == 0 {
+		if pos.InCheck() {
+			return winResult(pos.SideToMove ^ 1), fmt.Sprintf("%s mates", colorName(pos.SideToMove^1)), true
+		}
+		return "1/2-1/2", "Draw by stalemate", true
+	}
+
+	if pos.Rule50 >= 100 {
+		return "1/2-1/2", "Draw by fifty moves rule", true
+	}
+
+	repetitions := 0
+	for _, hash := range hashes {
+		if hash == pos.Hash {
+			repetitions++
+		}
+	}
+
+	if repetitions >= 3 {
+		return "1/2-1/2", "Draw by 3-fold repetition", true
+	}
+
+	if pos.InsufficientMaterial() {
+		return "1/2-1/2", "Draw by insufficient mating material", true
+	}
+
+	return "", "", false
+}
+
+// Find the legal move in the position matching a move in UCI notation.
+func findLegalMove(pos *engine.Position, moveStr string) (engine.Move, bool) {
+	for _, move := range engine.GenLegalMoves(pos) {
+		if move.String() == moveStr {
+			return move, true
+		}
+	}
+	return engine.NullMove, false
+}
+
+// Make a move in the game, which will never be undone.
+func makeMove(pos *engine.Position, move engine.Move) {
+	pos.DoMove(move)
+
+	// Make sure no state is saved on the position's history
+	// stack, since this move will never be undone.
+	pos.StatePly--
+}
+
+// Write the game as a PGN.
+func (game *Game) WritePGN(writer io.Writer) error {
+	var pgn strings.Builder
+
+	fmt.Fprintf(&pgn, "[Event \"Blunder match\"]\n")
+	fmt.Fprintf(&pgn, "[Site \"?\"]\n")
+	fmt.Fprintf(&pgn, "[Date \"%s\"]\n", game.Date.Format("2006.01.02"))
+	fmt.Fprintf(&pgn, "[Round \"%d\"]\n", game.Round)
+	fmt.Fprintf(&pgn, "[White \"%s\"]\n", game.White)
+	fmt.Fprintf(&pgn, "[Black \"%s\"]\n", game.Black)
+	fmt.Fprintf(&pgn, "[Result \"%s\"]\n", game.Result)
+
+	if game.Opening.FEN != engine.FENStartPosition {
+		fmt.Fprintf(&pgn, "[FEN \"%s\"]\n", game.Opening.FEN)
+		fmt.Fprintf(&pgn, "[SetUp \"1\"]\n")
+	}
+
+	fmt.Fprintf(&pgn, "[PlyCount \"%d\"]\n", len(game.Moves))
+	fmt.Fprintf(&pgn, "[TimeControl \"%s\"]\n", game.TC)
+	fmt.Fprintf(&pgn, "[Termination \"%s\"]\n\n", game.Termination)
+
+	// Number the moves starting from the move counter of the opening position.
+	fields := strings.Fields(game.Opening.FEN)
+	moveNumber, sideToMove := 1, uint8(engine.White)
+
+	if len(fields) > 1 && fields[1] == "b" {
+		sideToMove = engine.Black
+	}
+	if len(fields) > 5 {
+		if fullMove, err := strconv.Atoi(fields[5]); err == nil && fullMove > 0 {
+			moveNumber = fullMove
+		}
+	}
+
+	tokens := []string{}
+	for index, move := range game.Moves {
+		if sideToMove == engine.White {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber))
+		} else if index == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber))
+		}
+
+		tokens = append(tokens, move.SAN)
+		tokens = append(tokens, strings.Fields("{"+move.Comment+"}")...)
+
+		if sideToMove == engine.Black {
+			moveNumber++
+		}
+		sideToMove ^= 1
+	}
+
+	if game.Reason != "" {
+		tokens = append(tokens, strings.Fields("{"+game.Reason+"}")...)
+	}
+	tokens = append(tokens, game.Result)
+
+	// Wrap the moves so no line is longer than 80 characters.
+	lineLength := 0
+	for index, token := range tokens {
+		if index > 0 && lineLength+1+len(token) > 80 {
+			pgn.WriteString("\n")
+			lineLength = 0
+		} else if index > 0 {
+			pgn.WriteString(" ")
+			lineLength++
+		}
+
+		pgn.WriteString(token)
+		lineLength += len(token)
+	}
+	pgn.WriteString("\n\n")
+
+	_, err := io.WriteString(writer, pgn.String())
+	return err
+}
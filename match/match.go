@@ -0,0 +1,357 @@
+package match
+
+// match.go implements running a match between two UCI engines, so changes to Blunder
+// can be tested without needing an external tool like cutechess-cli. Each opening is
+// played twice, with the engines swapping colors, and several games can be played at
+// once. The results are reported after every game, and the match can be stopped early
+// using a sequential probability ratio test.
+
+import (
+	"blunder/engine"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The settings of a match.
+type Config struct {
+	Engines     [2]EngineConfig
+	TimeControl TimeControl
+
+	// The openings to play the games from, in order. If there are no openings,
+	// every game starts from the standard starting position.
+	Openings []Opening
+
+	// The number of games to play, which is rounded up to a whole number of
+	// pairs of games, and the number of games played at the same time.
+	Games       int
+	Concurrency int
+
+	// The extra time an engine is given for each move before it loses on time.
+	TimeMargin time.Duration
+
+	// The file to append the PGN of every game to, if any.
+	PGNOut string
+
+	// The SPRT used to stop the match early, if any.
+	SPRT *SPRT
+}
+
+// A game to be played in the match.
+type job struct {
+	round   int
+	opening Opening
+
+	// Whether the first engine plays white.
+	firstIsWhite bool
+}
+
+// A game which has been played, and which engine played white.
+type playedGame struct {
+	game         Game
+	firstIsWhite bool
+}
+
+// Run a match, reporting the results to the given writer after each game. Return the
+// results of the match from the perspective of the first engine, and an error if any
+// games couldn't be played, because an engine couldn't be started or restarted.
+func Run(config Config, out io.Writer) (Results, error) {
+	// Make sure the engines can be started before starting the match.
+	for _, engineConfig := range config.Engines {
+		uciEngine, err := startEngine(engineConfig)
+		if err != nil {
+			return Results{}, err
+		}
+		uciEngine.quit()
+	}
+
+	var pgnFile *os.File
+	if config.PGNOut != "" {
+		var err error
+		pgnFile, err = os.OpenFile(config.PGNOut, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return Results{}, err
+		}
+		defer pgnFile.Close()
+	}
+
+	openings := config.Openings
+	if len(openings) == 0 {
+		openings = []Opening{{FEN: engine.FENStartPosition}}
+	}
+
+	jobs := make(chan job)
+	games := make(chan playedGame)
+	stop := make(chan struct{})
+	workersGroup := sync.WaitGroup{}
+
+	// The first error of a worker which had to stop, since the games it
+	// didn't play are left to the other workers, if there are any left.
+	var workerErr error
+	workerErrOnce := sync.Once{}
+
+	for i := 0; i < config.Concurrency || i == 0; i++ {
+		workersGroup.Add(1)
+		go func() {
+			defer workersGroup.Done()
+			if err := playGames(&config, jobs, games); err != nil {
+				fmt.Fprintf(out, "Failed to start engine: %v\n", err)
+				workerErrOnce.Do(func() { workerErr = err })
+			}
+		}()
+	}
+
+	// Play each opening twice in a row, with the engines swapping colors.
+	go func() {
+		defer close(jobs)
+		for round := 1; round <= config.Games || round%2 == 0; round++ {
+			newJob := job{
+				round:        round,
+				opening:      openings[((round-1)/2)%len(openings)],
+				firstIsWhite: round%2 == 1,
+			}
+
+			select {
+			case jobs <- newJob:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workersGroup.Wait()
+		close(games)
+	}()
+
+	results := Results{}
+	stopped := false
+	rounds := config.Games + config.Games%2
+
+	for played := range games {
+		game := played.game
+		if pgnFile != nil {
+			if err := game.WritePGN(pgnFile); err != nil {
+				fmt.Fprintf(out, "Failed to write PGN: %v\n", err)
+			}
+		}
+
+		firstWon := "0-1"
+		names := [2]string{game.Black, game.White}
+		if played.firstIsWhite {
+			firstWon = "1-0"
+			names = [2]string{game.White, game.Black}
+		}
+
+		switch game.Result {
+		case "1/2-1/2":
+			results.Draws++
+		case firstWon:
+			results.Wins++
+		default:
+			results.Losses++
+		}
+
+		fmt.Fprintf(out, "Finished game %d (%s vs %s): %s {%s}\n", game.Round, game.White, game.Black, game.Result, game.Reason)
+		fmt.Fprintf(out, "Score of %s vs %s: %s\n", names[0], names[1], results)
+
+		if config.SPRT != nil {
+			report := config.SPRT.Report(results)
+			status := config.SPRT.Status(results)
+
+			if status == SPRTAcceptH1 {
+				report += " - H1 was accepted"
+			} else if status == SPRTAcceptH0 {
+				report += " - H0 was accepted"
+			}
+			fmt.Fprintln(out, report)
+
+			// Let the games already being played finish, but don't start any more.
+			if status != SPRTContinue && !stopped {
+				stopped = true
+				close(stop)
+			}
+		}
+	}
+
+	if !stopped {
+		close(stop)
+	}
+
+	if workerErr != nil {
+		if played := results.Games(); !stopped && played < rounds {
+			return results, fmt.Errorf("only %d of %d games were played: %w", played, rounds, workerErr)
+		}
+		return results, workerErr
+	}
+
+	fmt.Fprintln(out, "Finished match")
+	return results, nil
+}
+
+// Play the games sent until there are none left, sending back each game played.
+// If an engine can't be started, stop playing, and return the error.
+func playGames(config *Config, jobs <-chan job, games chan<- playedGame) error {
+	uciEngines := [2]*uciEngine{}
+	defer func() {
+		for _, uciEngine := range uciEngines {
+			if uciEngine != nil {
+				uciEngine.quit()
+			}
+		}
+	}()
+
+	for job := range jobs {
+		// Start any engine which hasn't been started yet, or which
+		// had to be restarted after its last game.
+		for index := range uciEngines {
+			if uciEngines[index] != nil {
+				continue
+			}
+
+			uciEngine, err := startEngine(config.Engines[index])
+			if err != nil {
+				return err
+			}
+			uciEngines[index] = uciEngine
+		}
+
+		players := [2]*uciEngine{uciEngines[1], uciEngines[0]}
+		if !job.firstIsWhite {
+			players = [2]*uciEngine{uciEngines[0], uciEngines[1]}
+		}
+
+		game, failed := playGame(players, job.opening, config.TimeControl, config.TimeMargin)
+		game.Round = job.round
+
+		for color, player := range players {
+			if failed[color] {
+				player.kill()
+				for index := range uciEngines {
+					if uciEngines[index] == player {
+						uciEngines[index] = nil
+					}
+				}
+			}
+		}
+
+		games <- playedGame{game, job.firstIsWhite}
+	}
+	return nil
+}
+
+// A flag for an engine, given as "cmd=<command> [name=<name>] [arg=<arg>]... [option.<name>=<value>]...".
+type engineFlag struct {
+	configs []EngineConfig
+}
+
+func (flag *engineFlag) String() string {
+	return ""
+}
+
+func (flag *engineFlag) Set(value string) error {
+	config := EngineConfig{}
+	for _, field := range strings.Fields(value) {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return fmt.Errorf("invalid engine setting %s", field)
+		}
+
+		switch {
+		case key == "cmd":
+			config.Command = value
+		case key == "name":
+			config.Name = value
+		case key == "arg":
+			config.Args = append(config.Args, value)
+		case strings.HasPrefix(key, "option."):
+			config.Options = append(config.Options, [2]string{strings.TrimPrefix(key, "option."), value})
+		default:
+			return fmt.Errorf("unknown engine setting %s", key)
+		}
+	}
+
+	if config.Command == "" {
+		return errors.New("an engine needs a command, given as cmd=<command>")
+	}
+
+	flag.configs = append(flag.configs, config)
+	return nil
+}
+
+// Run the "match" command with the given command-line arguments, and return the exit code.
+func RunCommand(args []string) int {
+	flags := flag.NewFlagSet("match", flag.ContinueOnError)
+	engines := engineFlag{}
+
+	flags.Var(&engines, "engine", "an engine to play, given twice, as \"cmd=<command> [name=<name>] [arg=<arg>]... [option.<name>=<value>]...\"")
+	tc := flags.String("tc", "10+0.1", "the time control, as [moves/]seconds[+increment]")
+	moveTime := flags.Float64("st", 0, "a fixed time per move in seconds, used instead of the time control")
+	openingsPath := flags.String("openings", "", "an EPD or PGN file of openings to play")
+	shuffle := flags.Bool("shuffle", false, "play the openings in a random order")
+	games := flags.Int("games", 100, "the number of games to play")
+	concurrency := flags.Int("concurrency", 1, "the number of games to play at the same time")
+	pgnOut := flags.String("pgnout", "", "a file to append the PGN of every game to")
+	sprtSettings := flags.String("sprt", "", "stop the match early using an SPRT, given as \"elo0=0 elo1=5 alpha=0.05 beta=0.05\"")
+	timeMargin := flags.Int("timemargin", 50, "the extra time in milliseconds an engine is given before it loses on time")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if len(engines.configs) != 2 {
+		fmt.Fprintln(os.Stderr, "Exactly two engines must be given, using -engine")
+		return 2
+	}
+
+	config := Config{
+		Games:       *games,
+		Concurrency: *concurrency,
+		PGNOut:      *pgnOut,
+		TimeMargin:  time.Duration(*timeMargin) * time.Millisecond,
+	}
+	copy(config.Engines[:], engines.configs)
+
+	var err error
+	if *moveTime > 0 {
+		config.TimeControl.MoveTime = time.Duration(*moveTime * float64(time.Second))
+	} else if config.TimeControl, err = ParseTimeControl(*tc); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *sprtSettings != "" {
+		sprt, err := ParseSPRT(*sprtSettings)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		config.SPRT = &sprt
+	}
+
+	if *openingsPath != "" {
+		if config.Openings, err = LoadOpenings(*openingsPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if *shuffle {
+			rand.Seed(time.Now().UnixNano())
+			rand.Shuffle(len(config.Openings), func(i, j int) {
+				config.Openings[i], config.Openings[j] = config.Openings[j], config.Openings[i]
+			})
+		}
+	}
+
+	if _, err := Run(config, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,179 @@
+package match
+
+import (
+	"blunder/engine"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	engine.InitBitboards()
+	engine.InitTables()
+	engine.InitZobrist()
+	engine.InitEvalBitboards()
+	engine.InitSearchTables()
+}
+
+func TestElo(t *testing.T) {
+	results := Results{Wins: 300, Losses: 200, Draws: 500}
+	elo, margin := results.Elo()
+
+	if math.Abs(elo-34.86) > 0.01 {
+		t.Errorf("Expected an Elo difference of 34.86, got %.2f", elo)
+	}
+
+	if math.Abs(margin-15.24) > 0.01 {
+		t.Errorf("Expected an error margin of 15.24, got %.2f", margin)
+	}
+
+	if los := results.LOS(); math.Abs(los-0.99999) > 0.00001 {
+		t.Errorf("Expected a LOS of 99.999%%, got %.3f%%", los*100)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	sprt, err := ParseSPRT("elo0=0 elo1=5 alpha=0.05 beta=0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, upper := sprt.Bounds()
+	if math.Abs(lower+2.944) > 0.001 || math.Abs(upper-2.944) > 0.001 {
+		t.Errorf("Expected SPRT bounds of -2.944 and 2.944, got %.3f and %.3f", lower, upper)
+	}
+
+	tests := []struct {
+		results Results
+		status  int
+	}{
+		{Results{Wins: 10, Losses: 10, Draws: 10}, SPRTContinue},
+		{Results{Wins: 1200, Losses: 1000, Draws: 2000}, SPRTAcceptH1},
+		{Results{Wins: 1000, Losses: 1200, Draws: 2000}, SPRTAcceptH0},
+	}
+
+	for _, test := range tests {
+		if status := sprt.Status(test.results); status != test.status {
+			t.Errorf("Expected SPRT status %d for results %v, got %d (llr %.3f)", test.status, test.results, status, sprt.LLR(test.results))
+		}
+	}
+
+	if _, err := ParseSPRT("elo0=5 elo1=0"); err == nil {
+		t.Errorf("An SPRT with elo0 >= elo1 should be rejected")
+	}
+}
+
+func TestParseTimeControl(t *testing.T) {
+	tests := map[string]TimeControl{
+		"10+0.1":   {Time: 10 * time.Second, Increment: 100 * time.Millisecond},
+		"40/60":    {Moves: 40, Time: 60 * time.Second},
+		"40/120+1": {Moves: 40, Time: 120 * time.Second, Increment: time.Second},
+	}
+
+	for str, expected := range tests {
+		tc, err := ParseTimeControl(str)
+		if err != nil || tc != expected {
+			t.Errorf("Parsing time control %s failed, got %+v (%v)", str, tc, err)
+		}
+
+		if tc.String() != str {
+			t.Errorf("Time control %s formatted as %s", str, tc)
+		}
+	}
+
+	for _, str := range []string{"", "0", "x/10", "10+y"} {
+		if _, err := ParseTimeControl(str); err == nil {
+			t.Errorf("Parsing time control %q should fail", str)
+		}
+	}
+}
+
+func TestLoadOpenings(t *testing.T) {
+	dir := t.TempDir()
+
+	epd := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 bm e5; id \"1\";\n\n" +
+		"r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3\n"
+	pgn := "[Event \"?\"]\n[Result \"*\"]\n\n1. e4 e5 {comment} 2. Nf3 (2. f4 exf4) Nc6 3. Bb5 a6 *\n"
+	illegal := "[Event \"?\"]\n[Result \"*\"]\n\n1. e4 e5 2. O-O-O *\n"
+
+	os.WriteFile(filepath.Join(dir, "openings.epd"), []byte(epd), 0644)
+	os.WriteFile(filepath.Join(dir, "openings.pgn"), []byte(pgn), 0644)
+	os.WriteFile(filepath.Join(dir, "illegal.pgn"), []byte(illegal), 0644)
+
+	openings, err := LoadOpenings(filepath.Join(dir, "openings.epd"))
+	expected := []Opening{
+		{FEN: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"},
+		{FEN: "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3"},
+	}
+	if err != nil || !reflect.DeepEqual(openings, expected) {
+		t.Errorf("Loading EPD openings failed, got %v (%v)", openings, err)
+	}
+
+	openings, err = LoadOpenings(filepath.Join(dir, "openings.pgn"))
+	expected = []Opening{
+		{FEN: engine.FENStartPosition, Moves: []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5", "a7a6"}},
+	}
+	if err != nil || !reflect.DeepEqual(openings, expected) {
+		t.Errorf("Loading PGN openings failed, got %v (%v)", openings, err)
+	}
+
+	if _, err := LoadOpenings(filepath.Join(dir, "illegal.pgn")); err == nil {
+		t.Errorf("Loading a PGN opening with an illegal move should fail")
+	}
+}
+
+func TestGameOver(t *testing.T) {
+	tests := []struct {
+		fen    string
+		result string
+	}{
+		{"rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3", "0-1"},
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", "1/2-1/2"},
+		{"8/8/4k3/8/8/3K4/3B4/8 w - - 0 1", "1/2-1/2"},
+		{"8/8/4k3/8/8/3K4/3R4/8 w - - 100 80", "1/2-1/2"},
+		{"8/8/4k3/8/8/3K4/3R4/8 w - - 99 80", ""},
+	}
+
+	var pos engine.Position
+	for _, test := range tests {
+		pos.LoadFEN(test.fen)
+		result, _, over := gameOver(&pos, []uint64{pos.Hash})
+
+		if over != (test.result != "") || result != test.result {
+			t.Errorf("Expected result %q for position %s, got %q", test.result, test.fen, result)
+		}
+	}
+}
+
+func TestRunDroppedGames(t *testing.T) {
+	// An engine which can only be started once, so it starts when the match checks
+	// it can be, but not when a worker tries to play a game with it.
+	script := filepath.Join(t.TempDir(), "engine.sh")
+	os.WriteFile(script, []byte(`#!/bin/sh
+[ -e "$1" ] && exit 1
+touch "$1"
+while read line; do
+	case "$line" in
+		uci) echo uciok ;;
+		isready) echo readyok ;;
+		quit) exit 0 ;;
+	esac
+done
+`), 0755)
+
+	config := Config{Games: 4, Concurrency: 2}
+	config.TimeControl.MoveTime = 10 * time.Millisecond
+	for index := range config.Engines {
+		marker := filepath.Join(t.TempDir(), "started")
+		config.Engines[index] = EngineConfig{Command: script, Args: []string{marker}}
+	}
+
+	var out strings.Builder
+	if results, err := Run(config, &out); err == nil || results.Games() != 0 {
+		t.Errorf("Expected an error when no games could be played, got %v after %d games", err, results.Games())
+	}
+}
@@ -0,0 +1,165 @@
+package match
+
+import (
+	"blunder/engine"
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// openings.go implements loading the openings games of a match start from, either
+// from an EPD file of positions, or from a PGN file of opening lines.
+
+// Comments, NAGs, and (non-nested) variations are skipped in the moves of a PGN.
+var pgnCommentPattern = regexp.MustCompile(`\{[^}]*\}|;[^\n]*|\$\d+|\([^()]*\)`)
+var pgnResultPattern = regexp.MustCompile(`^(1-0|0-1|1/2-1/2|\*)$`)
+var pgnMoveNumberPattern = regexp.MustCompile(`^\d+\.+`)
+
+// An opening a game starts from, as a starting position and
+// the moves played from it in UCI notation.
+type Opening struct {
+	FEN   string
+	Moves []string
+}
+
+// Load the openings from a file. Files ending in ".pgn" are read as PGNs, and
+// every other file is read as an EPD file with a position on each line.
+func LoadOpenings(path string) ([]Opening, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var openings []Opening
+	if strings.HasSuffix(strings.ToLower(path), ".pgn") {
+		openings, err = readPGNOpenings(bufio.NewScanner(file))
+	} else {
+		openings, err = readEPDOpenings(bufio.NewScanner(file))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(openings) == 0 {
+		return nil, fmt.Errorf("%s: no openings found", path)
+	}
+	return openings, nil
+}
+
+// Read the positions of an EPD file. Only the first four fields of each line are
+// needed, and the move counters are used if they follow them, as in a FEN string.
+func readEPDOpenings(scanner *bufio.Scanner) (openings []Opening, err error) {
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: invalid position", lineNumber)
+		}
+
+		fen := strings.Join(fields[:4], " ")
+		if len(fields) >= 6 && isNumber(fields[4]) && isNumber(fields[5]) {
+			fen += " " + fields[4] + " " + fields[5]
+		} else {
+			fen += " 0 1"
+		}
+
+		openings = append(openings, Opening{FEN: fen})
+	}
+
+	return openings, scanner.Err()
+}
+
+// Read the opening lines of a PGN file. The SAN moves of each game are converted
+// into UCI notation, and the FEN tag is used as the starting position if it's given.
+func readPGNOpenings(scanner *bufio.Scanner) (openings []Opening, err error) {
+	opening := Opening{FEN: engine.FENStartPosition}
+	moveText := ""
+
+	addOpening := func() error {
+		if moveText == "" && opening.FEN == engine.FENStartPosition {
+			return nil
+		}
+
+		var pos engine.Position
+		pos.LoadFEN(opening.FEN)
+
+		for _, token := range strings.Fields(pgnCommentPattern.ReplaceAllString(moveText, " ")) {
+			token = pgnMoveNumberPattern.ReplaceAllString(token, "")
+			if token == "" || pgnResultPattern.MatchString(token) {
+				continue
+			}
+
+			move, ok := parseSAN(&pos, token)
+			if !ok {
+				return fmt.Errorf("game %d: invalid move %s", len(openings)+1, token)
+			}
+
+			opening.Moves = append(opening.Moves, move.String())
+			pos.DoMove(move)
+			pos.StatePly--
+		}
+
+		openings = append(openings, opening)
+		opening = Opening{FEN: engine.FENStartPosition}
+		moveText = ""
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			// A tag after the moves of a game starts the next game.
+			if moveText != "" {
+				if err := addOpening(); err != nil {
+					return nil, err
+				}
+			}
+
+			if strings.HasPrefix(line, "[FEN ") {
+				opening.FEN = strings.Trim(strings.TrimPrefix(line, "[FEN "), "\"]")
+			}
+			continue
+		}
+
+		// Keep line comments from running into the next line.
+		moveText += " " + line + "\n"
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := addOpening(); err != nil {
+		return nil, err
+	}
+	return openings, nil
+}
+
+// Find the legal move matching a move in SAN.
+func parseSAN(pos *engine.Position, san string) (engine.Move, bool) {
+	san = strings.TrimRight(san, "+#!?")
+	for _, move := range engine.GenLegalMoves(pos) {
+		if strings.TrimRight(engine.ConvertMoveToSAN(pos, move), "+#") == san {
+			return move, true
+		}
+	}
+	return engine.NullMove, false
+}
+
+// Determine if a string is a non-negative integer.
+func isNumber(str string) bool {
+	_, err := strconv.ParseUint(str, 10, 16)
+	return err == nil
+}
@@ -0,0 +1,209 @@
+package match
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stats.go implements computing the Elo difference between two engines from the results
+// of a match, and a sequential probability ratio test (SPRT) to decide when a match can
+// be stopped.
+//
+// The SPRT uses the generalized SPRT approximation of the log-likelihood ratio for the
+// trinomial (win/draw/loss) distribution, as used by Fishtest and OpenBench:
+// https://www.chessprogramming.org/Sequential_Probability_Ratio_Test
+
+// The results of a match, from the perspective of the first engine.
+type Results struct {
+	Wins   int
+	Losses int
+	Draws  int
+}
+
+// Get the number of games played.
+func (results Results) Games() int {
+	return results.Wins + results.Losses + results.Draws
+}
+
+// Get the average score per game, between zero and one.
+func (results Results) Score() float64 {
+	if results.Games() == 0 {
+		return 0.5
+	}
+	return (float64(results.Wins) + float64(results.Draws)/2) / float64(results.Games())
+}
+
+// Get the variance of the score of a single game.
+func (results Results) variance() float64 {
+	games := float64(results.Games())
+	if games == 0 {
+		return 0
+	}
+
+	score := results.Score()
+	return (float64(results.Wins)*math.Pow(1-score, 2) +
+		float64(results.Draws)*math.Pow(0.5-score, 2) +
+		float64(results.Losses)*math.Pow(score, 2)) / games
+}
+
+// Get the Elo difference between the engines, and the margin of error
+// of the 95% confidence interval around it.
+func (results Results) Elo() (elo, margin float64) {
+	games := float64(results.Games())
+	if games == 0 {
+		return 0, 0
+	}
+
+	score := results.Score()
+	deviation := math.Sqrt(results.variance() / games)
+
+	// Keep the bounds of the interval from being infinite.
+	clamp := func(score float64) float64 {
+		return math.Max(0.001, math.Min(score, 0.999))
+	}
+
+	lower := scoreToElo(clamp(score - 1.959964*deviation))
+	upper := scoreToElo(clamp(score + 1.959964*deviation))
+	return scoreToElo(score), (upper - lower) / 2
+}
+
+// Get the likelihood of superiority: the probability the first engine is stronger.
+func (results Results) LOS() float64 {
+	if results.Wins+results.Losses == 0 {
+		return 0.5
+	}
+	return 0.5 * (1 + math.Erf(float64(results.Wins-results.Losses)/math.Sqrt(2*float64(results.Wins+results.Losses))))
+}
+
+// Get the ratio of drawn games.
+func (results Results) DrawRatio() float64 {
+	if results.Games() == 0 {
+		return 0
+	}
+	return float64(results.Draws) / float64(results.Games())
+}
+
+// Format the results the way they're reported after each game.
+func (results Results) String() string {
+	elo, margin := results.Elo()
+	return fmt.Sprintf(
+		"%d - %d - %d  [%.3f] %d\nElo difference: %s +/- %.1f, LOS: %.1f %%, DrawRatio: %.1f %%",
+		results.Wins, results.Losses, results.Draws, results.Score(), results.Games(),
+		formatElo(elo), margin, results.LOS()*100, results.DrawRatio()*100,
+	)
+}
+
+// The hypotheses and error rates of a sequential probability ratio test.
+// Elo0 is the Elo difference of the null hypothesis, and Elo1 of the
+// alternative hypothesis.
+type SPRT struct {
+	Elo0  float64
+	Elo1  float64
+	Alpha float64
+	Beta  float64
+}
+
+// The possible outcomes of a sequential probability ratio test.
+const (
+	SPRTContinue = iota
+	SPRTAcceptH0
+	SPRTAcceptH1
+)
+
+// Parse the settings of an SPRT, given as "elo0=0 elo1=5 alpha=0.05 beta=0.05".
+func ParseSPRT(str string) (SPRT, error) {
+	sprt := SPRT{Elo0: 0, Elo1: 5, Alpha: 0.05, Beta: 0.05}
+	for _, field := range strings.Fields(str) {
+		key, value, _ := strings.Cut(field, "=")
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return sprt, fmt.Errorf("invalid SPRT setting %s", field)
+		}
+
+		switch key {
+		case "elo0":
+			sprt.Elo0 = number
+		case "elo1":
+			sprt.Elo1 = number
+		case "alpha":
+			sprt.Alpha = number
+		case "beta":
+			sprt.Beta = number
+		default:
+			return sprt, fmt.Errorf("unknown SPRT setting %s", key)
+		}
+	}
+
+	if sprt.Elo0 >= sprt.Elo1 || sprt.Alpha <= 0 || sprt.Alpha >= 1 || sprt.Beta <= 0 || sprt.Beta >= 1 {
+		return sprt, fmt.Errorf("invalid SPRT settings")
+	}
+	return sprt, nil
+}
+
+// Get the lower and upper bounds of the log-likelihood ratio, at which
+// the null and alternative hypotheses are accepted respectively.
+func (sprt SPRT) Bounds() (lower, upper float64) {
+	return math.Log(sprt.Beta / (1 - sprt.Alpha)), math.Log((1 - sprt.Beta) / sprt.Alpha)
+}
+
+// Get the log-likelihood ratio of the results.
+func (sprt SPRT) LLR(results Results) float64 {
+	// The ratio can't be estimated until each kind of result has happened,
+	// since the variance of the results is too unreliable before then.
+	if results.Wins == 0 || results.Losses == 0 || results.Draws == 0 {
+		return 0
+	}
+
+	score0 := eloToScore(sprt.Elo0)
+	score1 := eloToScore(sprt.Elo1)
+	score := results.Score()
+
+	return float64(results.Games()) * (score1 - score0) * (2*score - score0 - score1) / (2 * results.variance())
+}
+
+// Determine if either hypothesis can be accepted from the results.
+func (sprt SPRT) Status(results Results) int {
+	lower, upper := sprt.Bounds()
+	llr := sprt.LLR(results)
+
+	if llr <= lower {
+		return SPRTAcceptH0
+	} else if llr >= upper {
+		return SPRTAcceptH1
+	}
+	return SPRTContinue
+}
+
+// Format the state of the test the way it's reported after each game.
+func (sprt SPRT) Report(results Results) string {
+	lower, upper := sprt.Bounds()
+	return fmt.Sprintf(
+		"SPRT: llr %.3f (%.1f%%), lbound %.2f, ubound %.2f",
+		sprt.LLR(results), sprt.LLR(results)/upper*100, lower, upper,
+	)
+}
+
+// Convert an average score into an Elo difference.
+func scoreToElo(score float64) float64 {
+	return -400 * math.Log10(1/score-1)
+}
+
+// Convert an Elo difference into an expected average score.
+func eloToScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Format an Elo difference, which is infinite if one engine won or lost every game.
+func formatElo(elo float64) string {
+	if math.IsInf(elo, 1) {
+		return "inf"
+	} else if math.IsInf(elo, -1) {
+		return "-inf"
+	} else if math.Abs(elo) < 0.05 {
+		// Don't show a negative zero.
+		return "0.0"
+	}
+	return fmt.Sprintf("%.1f", elo)
+}
@@ -0,0 +1,70 @@
+package tuner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// checkpoint.go implements saving the progress of a tuning session to a JSON file, so
+// a long session can be stopped and resumed later from where it left off.
+
+// The progress of a tuning session.
+type Checkpoint struct {
+	// The number of epochs completed, the weights after them, and the
+	// scaling factor used.
+	Epoch         int       `json:"epoch"`
+	Weights       []float64 `json:"weights"`
+	ScalingFactor float64   `json:"scaling_factor"`
+
+	// The seed the order of the positions in each epoch is picked with.
+	Seed int64 `json:"seed"`
+
+	// The optimizer used, and the state it keeps between steps.
+	Optimizer      string               `json:"optimizer"`
+	OptimizerState map[string][]float64 `json:"optimizer_state"`
+
+	// The error rates recorded so far.
+	Errors []float64 `json:"errors"`
+
+	// The weights with the lowest validation error so far, the epoch they're from, and
+	// their error, and whether tuning was stopped early since the error stopped improving.
+	BestWeights         []float64 `json:"best_weights,omitempty"`
+	BestEpoch           int       `json:"best_epoch"`
+	BestValidationError float64   `json:"best_validation_error"`
+	Stopped             bool      `json:"stopped"`
+}
+
+// Save a checkpoint to the given file. The checkpoint is first written to a
+// temporary file, so an interrupted save doesn't destroy the last checkpoint.
+func SaveCheckpoint(path string, checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// Load a checkpoint from the given file, checking it has the right number of weights.
+func LoadCheckpoint(path string) (checkpoint Checkpoint, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+
+	if len(checkpoint.Weights) != NumWeights {
+		return checkpoint, fmt.Errorf(
+			"checkpoint %s has %d weights, but %d are tuned", path, len(checkpoint.Weights), NumWeights,
+		)
+	}
+	return checkpoint, nil
+}
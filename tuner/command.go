@@ -0,0 +1,140 @@
+package tuner
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command.go implements the tune and gendata commands, which can be given as
+// command-line arguments to run the tuner and the data generators.
+
+// Run a data generation function, turning any panic from a missing or invalid
+// file into an error message and an exit code of 1.
+func runWithExitCode(run func()) (code int) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}()
+	run()
+	return 0
+}
+
+// Run the "tune" command with the given command-line arguments, and return the exit code.
+// The settings are read from the JSON config file given, and any of them given as flags
+// override the ones from the file.
+func RunTuneCommand(args []string) int {
+	flags := flag.NewFlagSet("tune", flag.ContinueOnError)
+	configPath := flags.String("config", "", "the JSON file of tuning settings")
+	infile := flags.String("data", "", "the file of training positions to tune with, instead of the ones in the config")
+	epochs := flags.Int("epochs", 0, "the number of epochs to tune for")
+	numPositions := flags.Int("positions", 0, "the number of training positions to load, or zero for every position")
+	workers := flags.Int("workers", 0, "the number of workers to compute the gradient with, or zero for one for each CPU")
+	outputPath := flags.String("out", "", "the JSON file to write the tuned weights to")
+	errorsPath := flags.String("errors", "", "the file to record the error rate during tuning in")
+	useDefaultWeights := flags.Bool("default-weights", false, "start from default weights instead of the current evaluation")
+	resume := flags.Bool("resume", false, "resume tuning from the checkpoint in the config")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := DefaultTunerConfig
+	if *configPath != "" {
+		var err error
+		if config, err = LoadTunerConfig(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "data":
+			config.DataFiles = []string{*infile}
+		case "epochs":
+			config.Epochs = *epochs
+		case "positions":
+			config.NumPositions = *numPositions
+		case "workers":
+			config.Workers = *workers
+		case "out":
+			config.OutputPath = *outputPath
+		case "errors":
+			config.ErrorsPath = *errorsPath
+		case "default-weights":
+			config.DefaultWeights = *useDefaultWeights
+		case "resume":
+			config.Resume = *resume
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := Tune(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Run the "gendata" command with the given command-line arguments, and return the exit code.
+// Training data for the texel tuner is extracted from the games in a PGN file, while NNUE
+// training data is generated by self-play.
+func RunGenDataCommand(args []string) int {
+	flags := flag.NewFlagSet("gendata", flag.ContinueOnError)
+	dataType := flags.String("type", "texel", "the type of data to generate, either texel or selfplay")
+	outfile := flags.String("out", "", "the file to append the generated data to")
+
+	pgnFile := flags.String("pgn", "", "texel: the PGN file of games to extract positions from")
+	samples := flags.Int("samples", 10, "texel: the number of positions sampled from each game")
+
+	config := DefaultSelfPlayConfig
+	flags.IntVar(&config.NumGames, "games", config.NumGames, "selfplay: the number of games to play")
+	flags.IntVar(&config.Workers, "workers", config.Workers, "selfplay: the number of games to play at the same time")
+	depth := flags.Int("depth", int(config.Depth), "selfplay: the depth of each search, or zero for no limit")
+	flags.Uint64Var(&config.Nodes, "nodes", config.Nodes, "selfplay: the nodes of each search, or zero for no limit")
+	flags.Uint64Var(&config.HashSize, "hash", config.HashSize, "selfplay: the size of the transposition table of each worker in MB")
+	flags.IntVar(&config.RandomPlies, "random-plies", config.RandomPlies, "selfplay: the number of random moves played at the start of each game")
+	flags.Int64Var(&config.Seed, "seed", config.Seed, "selfplay: the seed used to pick the opening moves, or zero to use the current time")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *outfile == "" {
+		fmt.Fprintln(os.Stderr, "A file to write the data to must be given, using -out")
+		return 2
+	}
+
+	switch *dataType {
+	case "texel":
+		if *pgnFile == "" {
+			fmt.Fprintln(os.Stderr, "A PGN file of games must be given, using -pgn")
+			return 2
+		}
+
+		return runWithExitCode(func() {
+			GenTrainingData(*pgnFile, *outfile, *samples)
+		})
+	case "selfplay":
+		if *depth < 0 || *depth > 255 || (*depth == 0 && config.Nodes == 0) {
+			fmt.Fprintln(os.Stderr, "A valid limit for each search must be given, using -depth or -nodes")
+			return 2
+		}
+
+		config.Depth = uint8(*depth)
+		config.Outfile = *outfile
+		return runWithExitCode(func() {
+			GenSelfPlayData(config)
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown type of data %q, it should be texel or selfplay\n", *dataType)
+		return 2
+	}
+}
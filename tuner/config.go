@@ -0,0 +1,251 @@
+package tuner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// config.go implements the settings of a tuning session, which are read from a JSON
+// file, for example:
+//
+//	{
+//	    "data_files": ["positions-1.txt", "positions-2.txt"],
+//	    "num_positions": 1000000,
+//	    "validation_split": 0.1,
+//	    "validation_interval": 10,
+//	    "patience": 5,
+//	    "epochs": 10000,
+//	    "batch_size": 16384,
+//	    "shuffle": true,
+//	    "workers": 8,
+//	    "optimizer": "adam",
+//	    "learning_rate": 1.0,
+//	    "lr_schedule": "step",
+//	    "lr_decay": 0.5,
+//	    "lr_decay_epochs": 1000,
+//	    "fit_scaling_factor": true,
+//	    "frozen_groups": ["material", "psqt"],
+//	    "output_path": "weights.json",
+//	    "checkpoint_path": "checkpoint.json",
+//	    "checkpoint_interval": 100
+//	}
+
+// The settings of a tuning session.
+type TunerConfig struct {
+	// The files of training positions, and the most positions loaded from
+	// all of them together. Zero means every position is loaded.
+	DataFiles    []string `json:"data_files"`
+	NumPositions int      `json:"num_positions"`
+
+	// The positions held out from training to measure how well the weights generalize,
+	// given either as files of positions, or as the fraction of the positions loaded
+	// from the data files which is held out, taken from the end of the last file.
+	ValidationFiles []string `json:"validation_files"`
+	ValidationSplit float64  `json:"validation_split"`
+
+	// How often, in epochs, the error of the validation positions is computed, and
+	// how many of these checks in a row without the best error improving tuning is
+	// stopped after. A patience of zero means tuning is never stopped early.
+	ValidationInterval int `json:"validation_interval"`
+	Patience           int `json:"patience"`
+
+	// The number of passes over the training positions, and the number of positions
+	// used to compute the gradient of each step. Zero means every position is used.
+	Epochs    int `json:"epochs"`
+	BatchSize int `json:"batch_size"`
+
+	// Whether the positions are shuffled before each epoch is split into batches, and
+	// the seed the order of the positions is picked with. If the seed is zero, the
+	// current time is used.
+	Shuffle bool  `json:"shuffle"`
+	Seed    int64 `json:"seed"`
+
+	// The number of workers the gradient and the error are computed with.
+	// Zero means one for each CPU.
+	Workers int `json:"workers"`
+
+	// The optimizer used to update the weights, and its learning rate. The momentum
+	// is used by SGD, and the decay rates of the moving averages, beta1 and beta2,
+	// are used by Adam.
+	Optimizer    string  `json:"optimizer"`
+	LearningRate float64 `json:"learning_rate"`
+	Momentum     float64 `json:"momentum"`
+	Beta1        float64 `json:"beta1"`
+	Beta2        float64 `json:"beta2"`
+
+	// How the learning rate changes with each epoch: it stays constant, is multiplied
+	// by the decay every DecayEpochs epochs with the step schedule, decays smoothly by the
+	// same amount with the exponential schedule, or follows half a cosine wave down to
+	// zero at the last epoch with the cosine schedule.
+	Schedule    string  `json:"lr_schedule"`
+	Decay       float64 `json:"lr_decay"`
+	DecayEpochs int     `json:"lr_decay_epochs"`
+
+	// The scaling factor K of the sigmoid which maps a score in centipawns to an
+	// expected outcome, and whether it's instead fitted to the training positions
+	// before tuning starts, by minimizing the error of the starting weights.
+	ScalingFactor    float64 `json:"scaling_factor"`
+	FitScalingFactor bool    `json:"fit_scaling_factor"`
+
+	// The groups of weights which keep their starting values, and whether tuning
+	// starts from reasonable default weights instead of the current evaluation.
+	FrozenGroups   []string `json:"frozen_groups"`
+	DefaultWeights bool     `json:"default_weights"`
+
+	// The file the tuned weights are written to, as a JSON file of evaluation parameters the
+	// engine can load with the EvalParamsFile option. If it's empty, they're printed to stdout.
+	// With validation positions, the weights with the lowest validation error are written,
+	// and are also written to the file each time a new lowest error is found.
+	OutputPath string `json:"output_path"`
+
+	// The file the error rates recorded during tuning are written to. If it's
+	// empty, they aren't recorded.
+	ErrorsPath string `json:"errors_path"`
+
+	// The file the progress of tuning is saved to every CheckpointInterval epochs, and
+	// whether tuning resumes from the last checkpoint saved there. If the path is empty,
+	// no checkpoints are saved.
+	CheckpointPath     string `json:"checkpoint_path"`
+	CheckpointInterval int    `json:"checkpoint_interval"`
+	Resume             bool   `json:"resume"`
+}
+
+// The default settings of a tuning session.
+var DefaultTunerConfig = TunerConfig{
+	Epochs:             Iterations,
+	Shuffle:            true,
+	Optimizer:          OptimizerAdaGrad,
+	LearningRate:       LearningRate,
+	Momentum:           0.9,
+	Beta1:              0.9,
+	Beta2:              0.999,
+	ValidationInterval: 10,
+	Schedule:           ScheduleConstant,
+	Decay:              0.5,
+	DecayEpochs:        1000,
+	ScalingFactor:      ScalingFactor,
+	FitScalingFactor:   true,
+	CheckpointInterval: 100,
+}
+
+// Load the settings of a tuning session from a JSON file. Any setting not in
+// the file keeps its default value.
+func LoadTunerConfig(path string) (config TunerConfig, err error) {
+	config = DefaultTunerConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid tuner config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Check the settings of a tuning session are valid.
+func (config *TunerConfig) Validate() error {
+	if len(config.DataFiles) == 0 {
+		return errors.New("no files of training positions were given")
+	}
+
+	if config.Epochs < 1 {
+		return errors.New("the number of epochs should be positive")
+	}
+
+	if config.NumPositions < 0 || config.BatchSize < 0 || config.Workers < 0 {
+		return errors.New("the number of positions, the batch size, and the number of workers can't be negative")
+	}
+
+	if config.ValidationSplit < 0 || config.ValidationSplit >= 1 {
+		return errors.New("the validation split should be at least zero and less than one")
+	}
+
+	if config.ValidationSplit > 0 && len(config.ValidationFiles) > 0 {
+		return errors.New("validation positions should be given either as files or as a split, not both")
+	}
+
+	if config.ValidationInterval < 1 || config.Patience < 0 {
+		return errors.New("the validation interval should be positive, and the patience can't be negative")
+	}
+
+	if config.LearningRate <= 0 || config.ScalingFactor <= 0 {
+		return errors.New("the learning rate and scaling factor should be positive")
+	}
+
+	if config.Resume && config.CheckpointPath == "" {
+		return errors.New("resuming needs a checkpoint path")
+	}
+
+	if config.Momentum < 0 || config.Momentum >= 1 || config.Beta1 < 0 || config.Beta1 >= 1 || config.Beta2 < 0 || config.Beta2 >= 1 {
+		return errors.New("the momentum, beta1, and beta2 should be at least zero and less than one")
+	}
+
+	if config.Decay <= 0 || config.DecayEpochs < 1 {
+		return errors.New("the learning rate decay and the epochs it's applied over should be positive")
+	}
+
+	if _, err := NewOptimizer(config, NumWeights); err != nil {
+		return err
+	}
+
+	if _, err := learningRate(config, 0); err != nil {
+		return err
+	}
+
+	_, err := frozenWeights(config.FrozenGroups, Indexes{})
+	return err
+}
+
+// Get the ranges of the weights in each group of evaluation terms, given as the
+// start and end of each range.
+func parameterGroups(indexes Indexes) map[string][][2]uint16 {
+	return map[string][][2]uint16{
+		"psqt":           {{0, 768}},
+		"material":       {{indexes.MG_Material_StartIndex, indexes.MG_Material_StartIndex + 10}},
+		"bishop_pair":    {{indexes.MG_BishopPairIndex, indexes.MG_BishopPairIndex + 2}},
+		"mobility":       {{indexes.MG_MobilityStartIndex, indexes.MG_MobilityStartIndex + 8}},
+		"passed_pawns":   {{indexes.MG_PassedPawn_PSQT_StartIndex, indexes.MG_PassedPawn_PSQT_StartIndex + 128}},
+		"pawn_structure": {{indexes.MG_DoubledPawnIndex, indexes.MG_DoubledPawnIndex + 4}},
+		"seventh_rank":   {{indexes.EG_RookOrQueenOnSeventhIndex, indexes.EG_RookOrQueenOnSeventhIndex + 1}},
+		"outposts": {
+			{indexes.MG_KnightOutpostIndex, indexes.MG_KnightOutpostIndex + 2},
+			{indexes.MG_BishopOutpostIndex, indexes.MG_BishopOutpostIndex + 2},
+		},
+		"open_file":   {{indexes.MG_RookOnOpenFileIndex, indexes.MG_RookOnOpenFileIndex + 1}},
+		"tempo":       {{indexes.TempoBonusIndex, indexes.TempoBonusIndex + 1}},
+		"king_safety": {{indexes.KingSafteyStartIndex, NumWeights}},
+	}
+}
+
+// Get the names of the groups of evaluation terms which can be frozen.
+func ParameterGroupNames() (names []string) {
+	for name := range parameterGroups(Indexes{}) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get which weights are frozen, given the names of the frozen groups.
+func frozenWeights(groups []string, indexes Indexes) (frozen []bool, err error) {
+	frozen = make([]bool, NumWeights)
+	ranges := parameterGroups(indexes)
+
+	for _, group := range groups {
+		groupRanges, ok := ranges[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter group %q, it should be one of %v", group, ParameterGroupNames())
+		}
+
+		for _, weightRange := range groupRanges {
+			for i := weightRange[0]; i < weightRange[1]; i++ {
+				frozen[i] = true
+			}
+		}
+	}
+	return frozen, nil
+}
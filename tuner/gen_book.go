@@ -0,0 +1,132 @@
+package tuner
+
+import (
+	"blunder/engine"
+	"log"
+	"math"
+)
+
+// gen_book.go builds polyglot opening books from the PGNs of games played.
+
+// The settings used to build an opening book.
+type BookConfig struct {
+	// The file containing the PGNs, and the polyglot file the book is written to.
+	Infile  string
+	Outfile string
+
+	// The number of plies from the start of each game which are added to the book.
+	MaxPly int
+
+	// The number of games a move must have been played in to be added to the book.
+	MinGames int
+
+	// The weight a move gets for each game won, drawn, or lost by the side
+	// which played it.
+	WinWeight  int
+	DrawWeight int
+	LossWeight int
+
+	// Only add the moves played by this color, or by both colors if it's
+	// engine.NoColor.
+	Color uint8
+}
+
+// The default settings used to build an opening book, which give moves the
+// same weights as the polyglot book maker.
+var DefaultBookConfig = BookConfig{
+	MaxPly:     20,
+	MinGames:   3,
+	WinWeight:  2,
+	DrawWeight: 1,
+	LossWeight: 0,
+	Color:      engine.NoColor,
+}
+
+// The key used to merge the statistics of the same move played in the same position.
+type bookKey struct {
+	hash uint64
+	move string
+}
+
+// The statistics of a move in the book, from the perspective of the side which played it.
+type bookStats struct {
+	games  int
+	weight int
+}
+
+// Build an opening book from the games in the infile, and write it to the outfile.
+func GenPolyglotBook(config BookConfig) error {
+	pgns := parsePGNs(config.Infile)
+	stats := map[bookKey]*bookStats{}
+	keys := []bookKey{}
+
+	for i, pgn := range pgns {
+		if (i+1)%10000 == 0 {
+			log.Printf("Adding moves from game %d\n", i+1)
+		}
+
+		var pos engine.Position
+		pos.LoadFEN(pgn.Fen)
+
+		for ply, move := range pgn.Moves {
+			if ply >= config.MaxPly || move == engine.NullMove {
+				break
+			}
+
+			if config.Color == engine.NoColor || config.Color == pos.SideToMove {
+				key := bookKey{engine.GenPolyglotHash(&pos), engine.PolyglotNotation(move)}
+				if _, ok := stats[key]; !ok {
+					stats[key] = &bookStats{}
+					keys = append(keys, key)
+				}
+
+				stats[key].games++
+				stats[key].weight += resultWeight(&config, pgn.Outcome, pos.SideToMove)
+			}
+
+			pos.DoMove(move)
+			pos.StatePly--
+		}
+	}
+
+	// Find the largest weight, so the weights can be scaled down to fit in
+	// the 16 bits polyglot gives them if needed.
+	maxWeight := 0
+	for _, key := range keys {
+		if stats[key].games >= config.MinGames && stats[key].weight > maxWeight {
+			maxWeight = stats[key].weight
+		}
+	}
+
+	scale := 1.0
+	if maxWeight > math.MaxUint16 {
+		scale = float64(math.MaxUint16) / float64(maxWeight)
+	}
+
+	// Moves with no weight would never be played, so leave them out of the book.
+	entries := []engine.PolyglotEntry{}
+	for _, key := range keys {
+		moveStats := stats[key]
+		if moveStats.games < config.MinGames || moveStats.weight <= 0 {
+			continue
+		}
+
+		weight := uint16(math.Max(1, math.Round(float64(moveStats.weight)*scale)))
+		entries = append(entries, engine.PolyglotEntry{Hash: key.hash, Move: key.move, Weight: weight})
+	}
+
+	log.Printf("Writing %d entries from %d games to %s\n", len(entries), len(pgns), config.Outfile)
+	return engine.WritePolyglotFile(config.Outfile, entries)
+}
+
+// Get the weight a game's outcome gives a move played by the given color.
+func resultWeight(config *BookConfig, outcome uint8, color uint8) int {
+	switch {
+	case outcome == Drawn:
+		return config.DrawWeight
+	case (outcome == WhiteWon) == (color == engine.White):
+		return config.WinWeight
+	default:
+		return config.LossWeight
+	}
+}
@@ -0,0 +1,121 @@
+package tuner
+
+import (
+	"blunder/engine"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var BookTestPGNs = `[Event "1"]
+[Result "1-0"]
+
+1. e4 e5 2. Nf3 Nc6 3. Bc4 Bc5 4. O-O Nf6 1-0
+
+[Event "2"]
+[Result "1/2-1/2"]
+
+1. e4 e5 2. Nf3 Nf6 1/2-1/2
+
+[Event "3"]
+[Result "0-1"]
+
+1. e4 c5 2. Nf3 d6 0-1
+
+[Event "4"]
+[Result "1-0"]
+
+1. d4 d5 2. c4 e6 1-0
+`
+
+// Build a book from the test games using the given settings, and load it back in.
+func buildTestBook(t *testing.T, config BookConfig) map[uint64][]engine.PolyglotEntry {
+	dir := t.TempDir()
+	config.Infile = filepath.Join(dir, "games.pgn")
+	config.Outfile = filepath.Join(dir, "book.bin")
+	os.WriteFile(config.Infile, []byte(BookTestPGNs), 0644)
+
+	if err := GenPolyglotBook(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the entries are sorted by their hash, so the book can be binary searched.
+	data, _ := os.ReadFile(config.Outfile)
+	for offset := engine.EntryByteLength; offset < len(data); offset += engine.EntryByteLength {
+		previous := binary.BigEndian.Uint64(data[offset-engine.EntryByteLength:])
+		if binary.BigEndian.Uint64(data[offset:]) < previous {
+			t.Fatalf("The entries of the book aren't sorted by their hash")
+		}
+	}
+
+	book, err := engine.LoadPolyglotFile(config.Outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// Get the weight of a move in the position with the given FEN, or zero if it isn't in the book.
+func bookWeight(book map[uint64][]engine.PolyglotEntry, fen, move string) uint16 {
+	var pos engine.Position
+	pos.LoadFEN(fen)
+
+	for _, entry := range book[engine.GenPolyglotHash(&pos)] {
+		if entry.Move == move {
+			return entry.Weight
+		}
+	}
+	return 0
+}
+
+func TestGenPolyglotBook(t *testing.T) {
+	afterE4 := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+	beforeCastling := "r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQK2R w KQkq - 4 4"
+
+	config := DefaultBookConfig
+	config.MinGames = 1
+	book := buildTestBook(t, config)
+
+	// 1. e4 was played in a win, a draw, and a loss for white, and
+	// 1. d4 in a single win for white.
+	if weight := bookWeight(book, engine.FENStartPosition, "e2e4"); weight != 3 {
+		t.Errorf("Expected 1. e4 to have a weight of 3, got %d", weight)
+	}
+
+	if weight := bookWeight(book, engine.FENStartPosition, "d2d4"); weight != 2 {
+		t.Errorf("Expected 1. d4 to have a weight of 2, got %d", weight)
+	}
+
+	// 1... c5 was played in a win for black, and 1... e5 in a loss
+	// and a draw.
+	if weight := bookWeight(book, afterE4, "c7c5"); weight != 2 {
+		t.Errorf("Expected 1... c5 to have a weight of 2, got %d", weight)
+	}
+
+	if weight := bookWeight(book, afterE4, "e7e5"); weight != 1 {
+		t.Errorf("Expected 1... e5 to have a weight of 1, got %d", weight)
+	}
+
+	// Castling is written as the king capturing its own rook.
+	if weight := bookWeight(book, beforeCastling, "e1h1"); weight != 2 {
+		t.Errorf("Expected 4. O-O to be in the book as e1h1 with a weight of 2, got %d", weight)
+	}
+
+	// Only moves played in at least two games should be added.
+	config.MinGames = 2
+	book = buildTestBook(t, config)
+
+	if len(book) != 3 || bookWeight(book, engine.FENStartPosition, "d2d4") != 0 {
+		t.Errorf("Expected only 1. e4, 1... e5, and 2. Nf3 in the book, got %v", book)
+	}
+
+	// Only moves played by black should be added.
+	config.MinGames = 1
+	config.Color = engine.Black
+	book = buildTestBook(t, config)
+
+	if bookWeight(book, engine.FENStartPosition, "e2e4") != 0 || bookWeight(book, afterE4, "c7c5") != 2 {
+		t.Errorf("Expected only black's moves in the book, got %v", book)
+	}
+}
@@ -88,7 +88,7 @@ func GenTrainingData(infile, outfile string, samplingSizePerGame int) {
 
 	rand.Shuffle(len(fens), func(i, j int) { fens[i], fens[j] = fens[j], fens[i] })
 
-	file, err := os.OpenFile(outfile, os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,465 @@
+package tuner
+
+import (
+	"blunder/engine"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gen_selfplay.go generates training data for NNUE networks by having Blunder play
+// games against itself. Each quiet position reached during a game is written out
+// as a packed binary record, together with the score of the search and the result
+// of the game. The format of the records is documented in docs/nnue.md.
+
+const (
+	// The size of a packed position record in bytes.
+	PackedPositionSize = 32
+
+	// Scores of the search beyond this are mate or tablebase scores, and
+	// positions with them aren't recorded.
+	MaxRecordedScore = engine.TBWinScore - engine.MaxDepth
+
+	// The most random openings tried for a game before giving up, since with
+	// too many random plies or too small a maximum opening score, every
+	// opening might be rejected.
+	MaxOpeningTries = 100
+)
+
+// A position from a self-play game, packed into 32 bytes. Every value is stored in
+// little-endian byte order when written out.
+type PackedPosition struct {
+	// A bitboard of the occupied squares, where bit n is set if square n
+	// (a1 = 0, b1 = 1, ..., h8 = 63) is occupied.
+	Occupancy uint64
+
+	// The pieces on the occupied squares, from the lowest square to the highest,
+	// with one piece in each nibble, starting from the low nibble of the first byte.
+	// Each piece is stored as color<<3 | type, using the engine's constants.
+	Pieces [16]uint8
+
+	// The score of the search from white's perspective, the result of the game
+	// (WhiteWon, BlackWon, or Drawn), the side to move, and the ply of the game.
+	Score      int16
+	Result     uint8
+	SideToMove uint8
+	Ply        uint16
+	_          uint16
+}
+
+// Pack a position, along with its score from white's perspective, the result of
+// the game it's from, and its ply in the game.
+func PackPosition(pos *engine.Position, score int16, result uint8, ply uint16) (packed PackedPosition) {
+	pieceIndex := 0
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		if piece.Type == engine.NoType {
+			continue
+		}
+
+		packed.Occupancy |= 1 << sq
+		packed.Pieces[pieceIndex/2] |= (piece.Color<<3 | piece.Type) << (4 * (pieceIndex % 2))
+		pieceIndex++
+	}
+
+	packed.Score = score
+	packed.Result = result
+	packed.SideToMove = pos.SideToMove
+	packed.Ply = ply
+	return packed
+}
+
+// Unpack the position into a FEN string. Castling rights and en passant
+// squares aren't recorded, so they're always left empty.
+func (packed PackedPosition) FEN() string {
+	var board [64]engine.Piece
+	for sq := range board {
+		board[sq] = engine.Piece{Type: engine.NoType, Color: engine.NoColor}
+	}
+
+	pieceIndex := 0
+	for sq := uint8(0); sq < 64; sq++ {
+		if packed.Occupancy&(1<<sq) == 0 {
+			continue
+		}
+
+		nibble := (packed.Pieces[pieceIndex/2] >> (4 * (pieceIndex % 2))) & 0xf
+		board[sq] = engine.Piece{Type: nibble & 7, Color: nibble >> 3}
+		pieceIndex++
+	}
+
+	var fen strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			piece := board[rank*8+file]
+			if piece.Type == engine.NoType {
+				empty++
+				continue
+			}
+
+			if empty > 0 {
+				fmt.Fprint(&fen, empty)
+				empty = 0
+			}
+
+			char := "pnbrqk"[piece.Type]
+			if piece.Color == engine.White {
+				char -= 'a' - 'A'
+			}
+			fen.WriteByte(char)
+		}
+
+		if empty > 0 {
+			fmt.Fprint(&fen, empty)
+		}
+		if rank > 0 {
+			fen.WriteByte('/')
+		}
+	}
+
+	sideToMove := "b"
+	if packed.SideToMove == engine.White {
+		sideToMove = "w"
+	}
+
+	return fmt.Sprintf("%s %s - - 0 %d", fen.String(), sideToMove, packed.Ply/2+1)
+}
+
+// Read all of the packed positions from a reader.
+func ReadPackedPositions(reader io.Reader) (positions []PackedPosition, err error) {
+	for {
+		var packed PackedPosition
+		err := binary.Read(reader, binary.LittleEndian, &packed)
+		if err == io.EOF {
+			return positions, nil
+		} else if err != nil {
+			return positions, err
+		}
+		positions = append(positions, packed)
+	}
+}
+
+// The settings used to generate self-play data.
+type SelfPlayConfig struct {
+	// The file the positions are appended to, the number of games to play,
+	// and the number of games played at the same time.
+	Outfile  string
+	NumGames int
+	Workers  int
+
+	// The limits of each search. A limit of zero means no limit, but
+	// at least one of them should be set.
+	Depth uint8
+	Nodes uint64
+
+	// The size of the transposition table of each worker in MB.
+	HashSize uint64
+
+	// The number of random moves played from the starting position at the start of
+	// each game, and the largest score from the first search after them which is
+	// accepted as a balanced enough opening.
+	RandomPlies     int
+	MaxOpeningScore int16
+
+	// A game is adjudicated as a win once the score is at least WinScore for
+	// WinPlies plies in a row, and as a draw once the score is at most DrawScore
+	// for DrawPlies plies in a row, starting at DrawMinPly. Games still going at
+	// MaxPly are drawn.
+	WinScore   int16
+	WinPlies   int
+	DrawScore  int16
+	DrawPlies  int
+	DrawMinPly int
+	MaxPly     int
+
+	// The seed of the random number generator used to pick the opening moves.
+	// If it's zero, the current time is used.
+	Seed int64
+}
+
+// The default settings used to generate self-play data.
+var DefaultSelfPlayConfig = SelfPlayConfig{
+	NumGames:        1000,
+	Workers:         1,
+	Depth:           8,
+	Nodes:           5000,
+	HashSize:        16,
+	RandomPlies:     8,
+	MaxOpeningScore: 300,
+	WinScore:        1500,
+	WinPlies:        6,
+	DrawScore:       10,
+	DrawPlies:       12,
+	DrawMinPly:      80,
+	MaxPly:          400,
+}
+
+// Play games of Blunder against itself, and append the quiet positions from each
+// game to the outfile as packed position records. If no usable opening can be
+// found for a game, no more games are started, and the games already played are
+// written before panicking.
+func GenSelfPlayData(config SelfPlayConfig) {
+	file, err := os.OpenFile(config.Outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	games := make(chan int64)
+	records := make(chan []PackedPosition)
+	stop := make(chan struct{})
+	workersGroup := sync.WaitGroup{}
+
+	var workerErr error
+	workerErrOnce := sync.Once{}
+
+	numWorkers := config.Workers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		workersGroup.Add(1)
+		go func() {
+			defer workersGroup.Done()
+			if err := playSelfPlayGames(&config, games, records); err != nil {
+				workerErrOnce.Do(func() {
+					workerErr = err
+					close(stop)
+				})
+			}
+		}()
+	}
+
+	// Give each game its own seed, so the games played
+	// only depend on the seed and not on the workers.
+	go func() {
+		defer close(games)
+		random := rand.New(rand.NewSource(seed))
+		for i := 0; i < config.NumGames; i++ {
+			select {
+			case games <- random.Int63():
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workersGroup.Wait()
+		close(records)
+	}()
+
+	writer := bufio.NewWriter(file)
+	numGames, numPositions := 0, 0
+	start := time.Now()
+
+	for gameRecords := range records {
+		if err := binary.Write(writer, binary.LittleEndian, gameRecords); err != nil {
+			panic(err)
+		}
+
+		numGames++
+		numPositions += len(gameRecords)
+
+		if numGames%100 == 0 || numGames == config.NumGames {
+			log.Printf(
+				"%d games played, %d positions written (%.0f positions/s)\n",
+				numGames, numPositions, float64(numPositions)/time.Since(start).Seconds(),
+			)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		panic(err)
+	}
+	file.Close()
+
+	if workerErr != nil {
+		panic(fmt.Errorf("only %d of %d games were played: %w", numGames, config.NumGames, workerErr))
+	}
+}
+
+// Play games for each seed sent, and send back the records of each game. If a
+// game can't be played, stop playing, and return the error.
+func playSelfPlayGames(config *SelfPlayConfig, games <-chan int64, records chan<- []PackedPosition) error {
+	search := engine.Search{Silent: true}
+	hashSize := config.HashSize
+	if hashSize == 0 {
+		hashSize = engine.DefaultTTSize
+	}
+	search.TT.Resize(hashSize, engine.SearchEntrySize)
+
+	depth := uint8(engine.MaxDepth)
+	if config.Depth > 0 {
+		depth = config.Depth
+	}
+
+	nodes := uint64(1<<64 - 1)
+	if config.Nodes > 0 {
+		nodes = config.Nodes
+	}
+
+	search.Timer.Setup(engine.InfiniteTime, engine.NoValue, engine.NoValue, int16(engine.NoValue), depth, nodes)
+
+	for seed := range games {
+		gameRecords, err := playSelfPlayGame(config, &search, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			return err
+		}
+		records <- gameRecords
+	}
+	return nil
+}
+
+// Play a single game, and return the records of the quiet positions from it, or an
+// error if no usable opening was found for it.
+func playSelfPlayGame(config *SelfPlayConfig, search *engine.Search, random *rand.Rand) (records []PackedPosition, err error) {
+	search.Reset()
+
+	// Keep trying new random openings until a balanced enough one is found.
+	for tries := 1; !playRandomOpening(config, search, random); tries++ {
+		if tries == MaxOpeningTries {
+			return nil, fmt.Errorf(
+				"no opening of %d random plies scored within %d after %d tries",
+				config.RandomPlies, config.MaxOpeningScore, MaxOpeningTries,
+			)
+		}
+	}
+
+	result := Drawn
+	winPlies, lossPlies, drawPlies := 0, 0, 0
+	repetitions := map[uint64]int{search.Pos.Hash: 1}
+
+	for ply := config.RandomPlies; ; ply++ {
+		pos := &search.Pos
+		moves := engine.GenLegalMoves(pos)
+
+		if len(moves) == 0 {
+			if pos.InCheck() {
+				result = winnerResult(pos.SideToMove ^ 1)
+			}
+			break
+		}
+
+		if ply >= config.MaxPly || ply >= engine.MaxGamePly-1 || pos.Rule50 >= 100 || repetitions[pos.Hash] >= 3 || pos.InsufficientMaterial() {
+			break
+		}
+
+		bestMove := search.Search()
+		if bestMove == engine.NullMove {
+			break
+		}
+
+		// Keep track of the score from white's perspective.
+		score := search.BestScore()
+		if pos.SideToMove == engine.Black {
+			score = -score
+		}
+
+		if isQuietMove(bestMove) && !pos.InCheck() && abs(score) < MaxRecordedScore {
+			records = append(records, PackPosition(pos, score, Drawn, uint16(ply)))
+		}
+
+		if score >= config.WinScore {
+			winPlies, lossPlies = winPlies+1, 0
+		} else if score <= -config.WinScore {
+			winPlies, lossPlies = 0, lossPlies+1
+		} else {
+			winPlies, lossPlies = 0, 0
+		}
+
+		if ply >= config.DrawMinPly && abs(score) <= config.DrawScore {
+			drawPlies++
+		} else {
+			drawPlies = 0
+		}
+
+		if config.WinPlies > 0 && winPlies >= config.WinPlies {
+			result = WhiteWon
+			break
+		} else if config.WinPlies > 0 && lossPlies >= config.WinPlies {
+			result = BlackWon
+			break
+		} else if config.DrawPlies > 0 && drawPlies >= config.DrawPlies {
+			break
+		}
+
+		playSelfPlayMove(search, bestMove)
+		repetitions[pos.Hash]++
+	}
+
+	for index := range records {
+		records[index].Result = result
+	}
+	return records, nil
+}
+
+// Play random moves from the starting position to open the game, and make sure the
+// opening isn't too unbalanced. Return false if it isn't a usable opening.
+func playRandomOpening(config *SelfPlayConfig, search *engine.Search, random *rand.Rand) bool {
+	search.Setup(engine.FENStartPosition)
+
+	for ply := 0; ply < config.RandomPlies; ply++ {
+		moves := engine.GenLegalMoves(&search.Pos)
+		if len(moves) == 0 {
+			return false
+		}
+		playSelfPlayMove(search, moves[random.Intn(len(moves))])
+	}
+
+	if len(engine.GenLegalMoves(&search.Pos)) == 0 {
+		return false
+	}
+
+	if config.MaxOpeningScore > 0 {
+		search.Search()
+		if abs(search.BestScore()) > config.MaxOpeningScore {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Play a move in the game, which will never be undone.
+func playSelfPlayMove(search *engine.Search, move engine.Move) {
+	search.Pos.DoMove(move)
+	search.AddHistory(search.Pos.Hash)
+
+	// Make sure no state is saved on the position's history
+	// stack, since this move will never be undone.
+	search.Pos.StatePly--
+}
+
+// Determine if a move doesn't capture or promote a piece.
+func isQuietMove(move engine.Move) bool {
+	return move.MoveType() != engine.Attack && move.MoveType() != engine.Promotion
+}
+
+// Get the result of a game won by the given side.
+func winnerResult(color uint8) uint8 {
+	if color == engine.White {
+		return WhiteWon
+	}
+	return BlackWon
+}
+
+func abs(n int16) int16 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package tuner
+
+import (
+	"blunder/engine"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var PackingTestFENs = []string{
+	"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1",
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R b - - 0 1",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+	"8/8/8/4k3/8/8/8/4K3 b - - 0 1",
+}
+
+func TestPackPosition(t *testing.T) {
+	var pos engine.Position
+	for _, fen := range PackingTestFENs {
+		pos.LoadFEN(fen)
+		packed := PackPosition(&pos, -37, BlackWon, 60)
+
+		unpacked := packed.FEN()
+		expected := strings.Join(strings.Fields(fen)[:4], " ") + " 0 31"
+		if unpacked != expected {
+			t.Errorf("Packing position %s failed, unpacked as %s", fen, unpacked)
+		}
+
+		if packed.Score != -37 || packed.Result != BlackWon || packed.Ply != 60 {
+			t.Errorf("Packing the score, result, or ply of position %s failed", fen)
+		}
+	}
+}
+
+// Make sure a couple of quick games can be played, and that every position
+// written out is a legal, quiet position.
+func TestGenSelfPlayData(t *testing.T) {
+	config := DefaultSelfPlayConfig
+	config.Outfile = filepath.Join(t.TempDir(), "data.bin")
+	config.NumGames = 2
+	config.Workers = 2
+	config.Depth = 2
+	config.MaxPly = 60
+	config.Seed = 1
+
+	GenSelfPlayData(config)
+
+	file, err := os.Open(config.Outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	positions, err := ReadPackedPositions(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(positions) == 0 {
+		t.Fatal("No positions were written")
+	}
+
+	var pos engine.Position
+	for _, packed := range positions {
+		pos.LoadFEN(packed.FEN())
+		if pos.InCheck() || int(packed.Ply) < config.RandomPlies || int(packed.Ply) >= config.MaxPly {
+			t.Errorf("Position %s shouldn't have been written", packed.FEN())
+		}
+	}
+}
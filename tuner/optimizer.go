@@ -0,0 +1,184 @@
+package tuner
+
+import (
+	"fmt"
+	"math"
+)
+
+// optimizer.go implements the optimizers which update the weights during tuning,
+// using the gradient of the error of each batch of training positions.
+
+const (
+	OptimizerAdaGrad = "adagrad"
+	OptimizerAdam    = "adam"
+	OptimizerSGD     = "sgd"
+
+	ScheduleConstant    = "constant"
+	ScheduleStep        = "step"
+	ScheduleExponential = "exponential"
+	ScheduleCosine      = "cosine"
+)
+
+// An optimizer which updates the weights using the gradient of the error. Its
+// state can be saved and restored, so tuning can be resumed from a checkpoint.
+type Optimizer interface {
+	// Update the weights which aren't frozen, given the gradient of the error.
+	Step(weights, gradients []float64, frozen []bool, learningRate float64)
+
+	// Get and set the state the optimizer keeps between steps.
+	State() map[string][]float64
+	SetState(state map[string][]float64) error
+}
+
+// Create the optimizer given in the settings of a tuning session, for the given
+// number of weights.
+func NewOptimizer(config *TunerConfig, numWeights int) (Optimizer, error) {
+	switch config.Optimizer {
+	case OptimizerAdaGrad:
+		return &AdaGrad{GradientsSumsSquared: make([]float64, numWeights)}, nil
+	case OptimizerAdam:
+		return &Adam{
+			Beta1:     config.Beta1,
+			Beta2:     config.Beta2,
+			Moments:   make([]float64, numWeights),
+			Variances: make([]float64, numWeights),
+			Steps:     make([]float64, 1),
+		}, nil
+	case OptimizerSGD:
+		return &SGD{Momentum: config.Momentum, Velocities: make([]float64, numWeights)}, nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown optimizer %q, it should be %s, %s, or %s", config.Optimizer, OptimizerAdaGrad, OptimizerAdam, OptimizerSGD,
+		)
+	}
+}
+
+// Get the learning rate for the given epoch, using the learning rate schedule
+// given in the settings of a tuning session.
+func learningRate(config *TunerConfig, epoch int) (float64, error) {
+	switch config.Schedule {
+	case ScheduleConstant, "":
+		return config.LearningRate, nil
+	case ScheduleStep:
+		return config.LearningRate * math.Pow(config.Decay, float64(epoch/config.DecayEpochs)), nil
+	case ScheduleExponential:
+		return config.LearningRate * math.Pow(config.Decay, float64(epoch)/float64(config.DecayEpochs)), nil
+	case ScheduleCosine:
+		return config.LearningRate * (1 + math.Cos(math.Pi*float64(epoch)/float64(config.Epochs))) / 2, nil
+	default:
+		return 0, fmt.Errorf(
+			"unknown learning rate schedule %q, it should be %s, %s, %s, or %s",
+			config.Schedule, ScheduleConstant, ScheduleStep, ScheduleExponential, ScheduleCosine,
+		)
+	}
+}
+
+// Copy the saved state of an optimizer into the slices it keeps between steps,
+// checking each of them is there and has the right size.
+func restoreState(state map[string][]float64, slices map[string][]float64) error {
+	for name, slice := range slices {
+		saved, ok := state[name]
+		if !ok || len(saved) != len(slice) {
+			return fmt.Errorf("the optimizer state %q is missing or has the wrong size", name)
+		}
+		copy(slice, saved)
+	}
+	return nil
+}
+
+// AdaGrad scales the learning rate of each weight by the inverse square root of the
+// sum of the squares of its past gradients, so weights with large gradients are
+// updated slower.
+type AdaGrad struct {
+	GradientsSumsSquared []float64
+}
+
+func (adagrad *AdaGrad) Step(weights, gradients []float64, frozen []bool, learningRate float64) {
+	for k, gradient := range gradients {
+		if frozen[k] {
+			continue
+		}
+
+		adagrad.GradientsSumsSquared[k] += gradient * gradient
+		weights[k] -= gradient * learningRate / math.Sqrt(adagrad.GradientsSumsSquared[k]+Epsilon)
+	}
+}
+
+func (adagrad *AdaGrad) State() map[string][]float64 {
+	return map[string][]float64{"gradients_sums_squared": adagrad.GradientsSumsSquared}
+}
+
+func (adagrad *AdaGrad) SetState(state map[string][]float64) error {
+	return restoreState(state, adagrad.State())
+}
+
+// Adam keeps a moving average of the gradient of each weight, its moment, and of the
+// square of the gradient, its variance, and updates the weight by the ratio of the two,
+// so each weight moves at about the learning rate in the direction its gradient points
+// in on average.
+type Adam struct {
+	Beta1     float64
+	Beta2     float64
+	Moments   []float64
+	Variances []float64
+
+	// The number of steps taken, kept in a slice so it's saved along
+	// with the rest of the state.
+	Steps []float64
+}
+
+func (adam *Adam) Step(weights, gradients []float64, frozen []bool, learningRate float64) {
+	adam.Steps[0]++
+
+	// Correct the bias of the moving averages towards zero in the first steps.
+	momentCorrection := 1 - math.Pow(adam.Beta1, adam.Steps[0])
+	varianceCorrection := 1 - math.Pow(adam.Beta2, adam.Steps[0])
+
+	for k, gradient := range gradients {
+		if frozen[k] {
+			continue
+		}
+
+		adam.Moments[k] = adam.Beta1*adam.Moments[k] + (1-adam.Beta1)*gradient
+		adam.Variances[k] = adam.Beta2*adam.Variances[k] + (1-adam.Beta2)*gradient*gradient
+
+		moment := adam.Moments[k] / momentCorrection
+		variance := adam.Variances[k] / varianceCorrection
+		weights[k] -= learningRate * moment / (math.Sqrt(variance) + Epsilon)
+	}
+}
+
+func (adam *Adam) State() map[string][]float64 {
+	return map[string][]float64{"moments": adam.Moments, "variances": adam.Variances, "steps": adam.Steps}
+}
+
+func (adam *Adam) SetState(state map[string][]float64) error {
+	return restoreState(state, adam.State())
+}
+
+// SGD updates each weight against its gradient, with momentum, so the
+// velocity of a weight builds up while its gradient keeps pointing in
+// the same direction.
+type SGD struct {
+	Momentum   float64
+	Velocities []float64
+}
+
+func (sgd *SGD) Step(weights, gradients []float64, frozen []bool, learningRate float64) {
+	for k, gradient := range gradients {
+		if frozen[k] {
+			continue
+		}
+
+		sgd.Velocities[k] = sgd.Momentum*sgd.Velocities[k] + gradient
+		weights[k] -= learningRate * sgd.Velocities[k]
+	}
+}
+
+func (sgd *SGD) State() map[string][]float64 {
+	return map[string][]float64{"velocities": sgd.Velocities}
+}
+
+func (sgd *SGD) SetState(state map[string][]float64) error {
+	return restoreState(state, sgd.State())
+}
@@ -0,0 +1,84 @@
+package tuner
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// optimizer_test.go provides tests to ensure each optimizer lowers the error of
+// the test positions, and that the learning rate schedules decay as expected.
+
+func TestOptimizers(t *testing.T) {
+	dataFile := writeTestTrainingFile(t)
+	learningRates := map[string]float64{OptimizerAdaGrad: 0.5, OptimizerAdam: 0.5, OptimizerSGD: 500}
+
+	for optimizer, rate := range learningRates {
+		dir := t.TempDir()
+		config := DefaultTunerConfig
+		config.DataFiles = []string{dataFile}
+		config.Epochs = 10
+		config.BatchSize = 32
+		config.Seed = 1
+		config.Optimizer = optimizer
+		config.LearningRate = rate
+		config.Schedule = ScheduleCosine
+		config.OutputPath = filepath.Join(dir, "weights.json")
+		config.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+		config.ErrorsPath = filepath.Join(dir, "errors.txt")
+
+		if err := Tune(config); err != nil {
+			t.Fatal(err)
+		}
+
+		checkpoint, err := LoadCheckpoint(config.CheckpointPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		before, after := checkpoint.Errors[0], checkpoint.Errors[len(checkpoint.Errors)-1]
+		if after >= before {
+			t.Errorf("Expected %s to lower the error from %f, got %f", optimizer, before, after)
+		}
+	}
+}
+
+func TestLearningRateSchedules(t *testing.T) {
+	config := DefaultTunerConfig
+	config.Epochs = 100
+	config.LearningRate = 1
+	config.Decay = 0.5
+	config.DecayEpochs = 10
+
+	tests := []struct {
+		schedule string
+		epoch    int
+		expected float64
+	}{
+		{ScheduleConstant, 50, 1},
+		{ScheduleStep, 9, 1},
+		{ScheduleStep, 25, 0.25},
+		{ScheduleExponential, 5, math.Sqrt(0.5)},
+		{ScheduleExponential, 20, 0.25},
+		{ScheduleCosine, 0, 1},
+		{ScheduleCosine, 50, 0.5},
+		{ScheduleCosine, 100, 0},
+	}
+
+	for _, test := range tests {
+		config.Schedule = test.schedule
+		rate, err := learningRate(&config, test.epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if math.Abs(rate-test.expected) > 1e-9 {
+			t.Errorf("Expected the %s schedule to give %f at epoch %d, got %f", test.schedule, test.expected, test.epoch, rate)
+		}
+	}
+
+	config.Schedule = "linear"
+	if _, err := learningRate(&config, 0); err == nil {
+		t.Errorf("Expected an unknown schedule to fail")
+	}
+}
@@ -5,10 +5,16 @@ package tuner
 import (
 	"blunder/engine"
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -19,6 +25,12 @@ const (
 	Epsilon            = 0.00000001
 	LearningRate       = 0.5
 
+	// The range the scaling factor is fitted in, and how
+	// closely it's fitted.
+	MinScalingFactor       = 0.0001
+	MaxScalingFactor       = 0.1
+	ScalingFactorTolerance = 0.000001
+
 	Draw     float64 = 0.5
 	WhiteWin float64 = 1.0
 	BlackWin float64 = 0.0
@@ -191,6 +203,9 @@ func loadDefaultWeights() (weights []float64, indexes Indexes) {
 	copy(tempWeights[index:index+4], []int16{1, 1, 1, 1})
 	copy(tempWeights[index+4:index+8], []int16{1, 1, 1, 1})
 	index += 8
+
+	indexes.MG_PassedPawn_PSQT_StartIndex = index
+	indexes.EG_PassedPawn_PSQT_StartIndex = index + 64
 	index += 128
 
 	indexes.MG_DoubledPawnIndex = index
@@ -239,49 +254,65 @@ func loadDefaultWeights() (weights []float64, indexes Indexes) {
 	return weights, indexes
 }
 
-// Load the given number of positions from the training set file.
-func loadEntries(infile string, numPositions int, indexes Indexes) (entries []Entry) {
-	file, err := os.Open(infile)
-	if err != nil {
-		panic(err)
-	}
-	reader := bufio.NewReader(file)
-	scanner := bufio.NewScanner(reader)
+// Load the given number of positions from the training set files, or every position
+// if the number is zero.
+func loadEntries(infiles []string, numPositions int, indexes Indexes) (entries []Entry, err error) {
+	for _, infile := range infiles {
+		file, err := os.Open(infile)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(bufio.NewReader(file))
+		for lineNumber := 1; scanner.Scan() && (numPositions == 0 || len(entries) < numPositions); lineNumber++ {
+			line := scanner.Text()
+			fields := strings.Fields(line)
 
-	for positionCount := 0; scanner.Scan() && positionCount < numPositions; positionCount++ {
-		line := scanner.Text()
-		fields := strings.Fields(line)
+			if len(fields) < 7 {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: invalid training position %q", infile, lineNumber, line)
+			}
+
+			fen := fields[0] + " " + fields[1] + " - - 0 1"
+			result := fields[6]
 
-		fen := fields[0] + " " + fields[1] + " - - 0 1"
-		result := fields[6]
+			outcome := Draw
+			if result == "[1.0]" {
+				outcome = WhiteWin
+			} else if result == "[0.0]" {
+				outcome = BlackWin
+			}
 
-		outcome := Draw
-		if result == "[1.0]" {
-			outcome = WhiteWin
-		} else if result == "[0.0]" {
-			outcome = BlackWin
+			pos := engine.Position{}
+			pos.LoadFEN(fen)
+
+			normalCoefficents, safetyCoefficents := getCoefficents(&pos, indexes)
+			phase := (pos.Phase*256 + (engine.TotalPhase / 2)) / engine.TotalPhase
+			mgPhase := float64(256-phase) / 256
+
+			entries = append(
+				entries,
+				Entry{
+					NormalCoefficents: normalCoefficents,
+					SafetyCoefficents: safetyCoefficents,
+					Outcome:           outcome,
+					MGPhase:           mgPhase,
+				},
+			)
 		}
 
-		pos := engine.Position{}
-		pos.LoadFEN(fen)
-
-		normalCoefficents, safetyCoefficents := getCoefficents(&pos, indexes)
-		phase := (pos.Phase*256 + (engine.TotalPhase / 2)) / engine.TotalPhase
-		mgPhase := float64(256-phase) / 256
-
-		entries = append(
-			entries,
-			Entry{
-				NormalCoefficents: normalCoefficents,
-				SafetyCoefficents: safetyCoefficents,
-				Outcome:           outcome,
-				MGPhase:           mgPhase,
-			},
-		)
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
 	}
 
-	fmt.Printf("Done loading %d positions...\n", numPositions)
-	return entries
+	if len(entries) == 0 {
+		return nil, errors.New("no training positions were loaded")
+	}
+
+	fmt.Printf("Done loading %d positions...\n", len(entries))
+	return entries, nil
 }
 
 // Get the evaluation coefficents of the position so it can be used to calculate
@@ -616,6 +647,12 @@ func computeSafetyDotProduct(v1 []float64, v2 []Coefficent) (sum float64) {
 	return sum
 }
 
+// Map a score in centipawns to an expected outcome between zero and one, using
+// the given scaling factor.
+func sigmoid(k, score float64) float64 {
+	return 1 / (1 + math.Exp(-(k * score)))
+}
+
 // Evaluate the position from the training set file.
 func evaluate(weights []float64, normalCoefficents []Coefficent, safetyCoefficents [][]Coefficent, indexes Indexes, mgPhase float64) (score float64) {
 	for i := range normalCoefficents {
@@ -632,29 +669,27 @@ func evaluate(weights []float64, normalCoefficents []Coefficent, safetyCoefficen
 	return score + whiteSafety - blackSafety
 }
 
-func computeGradientNumerically(entries []Entry, weights []float64, indexes Indexes, epsilon float64) (gradients []float64) {
+func computeGradientNumerically(entries []Entry, weights []float64, indexes Indexes, k, epsilon float64) (gradients []float64) {
 	N := float64(len(entries))
 	gradients = make([]float64, len(weights))
 	epsilonAddedErrSums := make([]float64, len(entries))
 	epsilonSubtractedErrSums := make([]float64, len(entries))
 
 	for i := range entries {
-		for k := range weights {
-			weights[k] += epsilon
+		for j := range weights {
+			weights[j] += epsilon
 
 			score := evaluate(weights, entries[i].NormalCoefficents, entries[i].SafetyCoefficents, indexes, entries[i].MGPhase)
-			sigmoid := 1 / (1 + math.Exp(-(ScalingFactor * score)))
-			err := entries[i].Outcome - sigmoid
-			epsilonAddedErrSums[k] += math.Pow(err, 2)
+			err := entries[i].Outcome - sigmoid(k, score)
+			epsilonAddedErrSums[j] += math.Pow(err, 2)
 
-			weights[k] -= epsilon * 2
+			weights[j] -= epsilon * 2
 
 			score = evaluate(weights, entries[i].NormalCoefficents, entries[i].SafetyCoefficents, indexes, entries[i].MGPhase)
-			sigmoid = 1 / (1 + math.Exp(-(ScalingFactor * score)))
-			err = entries[i].Outcome - sigmoid
-			epsilonSubtractedErrSums[k] += math.Pow(err, 2)
+			err = entries[i].Outcome - sigmoid(k, score)
+			epsilonSubtractedErrSums[j] += math.Pow(err, 2)
 
-			weights[k] += epsilon
+			weights[j] += epsilon
 		}
 	}
 
@@ -667,18 +702,18 @@ func computeGradientNumerically(entries []Entry, weights []float64, indexes Inde
 	return gradients
 }
 
-func computeGradient(entries []Entry, weights []float64, indexes Indexes) (gradients []float64) {
+func computeGradient(entries []Entry, weights []float64, indexes Indexes, k float64) (gradients []float64) {
 	gradients = make([]float64, NumWeights)
 
 	for i := range entries {
 		score := evaluate(weights, entries[i].NormalCoefficents, entries[i].SafetyCoefficents, indexes, entries[i].MGPhase)
-		sigmoid := 1 / (1 + math.Exp(-(ScalingFactor * score)))
-		err := entries[i].Outcome - sigmoid
+		expected := sigmoid(k, score)
+		err := entries[i].Outcome - expected
 
 		// Note the gradient here is incomplete, and should inclue the -2k/N coefficent. However,
 		// algebraically this can be factored out of the equation and done only when we need to use
 		// the gradient. This saves time and accuracy. Thanks to Ethereal for this tweak.
-		term := err * (1 - sigmoid) * sigmoid
+		term := err * (1 - expected) * expected
 
 		for k := range entries[i].NormalCoefficents {
 			coefficent := &entries[i].NormalCoefficents[k]
@@ -702,16 +737,106 @@ func computeGradient(entries []Entry, weights []float64, indexes Indexes) (gradi
 	return gradients
 }
 
-func computeMSE(entries []Entry, weights []float64, indexes Indexes) (errSum float64) {
+func computeMSE(entries []Entry, weights []float64, indexes Indexes, k float64) float64 {
+	return computeErrorSum(entries, weights, indexes, k) / float64(len(entries))
+}
+
+// Compute the sum of the squared errors of the entries.
+func computeErrorSum(entries []Entry, weights []float64, indexes Indexes, k float64) (errSum float64) {
 	for i := range entries {
 		score := evaluate(weights, entries[i].NormalCoefficents, entries[i].SafetyCoefficents, indexes, entries[i].MGPhase)
-		sigmoid := 1 / (1 + math.Exp(-(ScalingFactor * score)))
-		err := entries[i].Outcome - sigmoid
+		err := entries[i].Outcome - sigmoid(k, score)
 		errSum += math.Pow(err, 2)
 	}
+	return errSum
+}
+
+// Split the entries into one contiguous shard for each worker, and run the given function
+// on each shard in its own goroutine. The results are returned in the order of the shards,
+// so reducing them in that order gives the same result no matter how the goroutines are
+// scheduled.
+func runOnShards[T any](entries []Entry, numWorkers int, run func(shard []Entry) T) (results []T) {
+	numWorkers = engine.Min(numWorkers, len(entries))
+	if numWorkers <= 1 {
+		return []T{run(entries)}
+	}
+
+	results = make([]T, numWorkers)
+	shardSize := (len(entries) + numWorkers - 1) / numWorkers
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < numWorkers; i++ {
+		start := engine.Min(i*shardSize, len(entries))
+		end := engine.Min(start+shardSize, len(entries))
+
+		wg.Add(1)
+		go func(i int, shard []Entry) {
+			defer wg.Done()
+			results[i] = run(shard)
+		}(i, entries[start:end])
+	}
+
+	wg.Wait()
+	return results
+}
+
+// Compute the gradient of the entries like computeGradient, using the given
+// number of workers.
+func computeGradientParallel(entries []Entry, weights []float64, indexes Indexes, k float64, numWorkers int) (gradients []float64) {
+	partials := runOnShards(entries, numWorkers, func(shard []Entry) []float64 {
+		return computeGradient(shard, weights, indexes, k)
+	})
+
+	gradients = partials[0]
+	for _, partial := range partials[1:] {
+		for i := range gradients {
+			gradients[i] += partial[i]
+		}
+	}
+	return gradients
+}
+
+// Compute the mean squared error of the entries like computeMSE, using the
+// given number of workers.
+func computeMSEParallel(entries []Entry, weights []float64, indexes Indexes, k float64, numWorkers int) float64 {
+	partials := runOnShards(entries, numWorkers, func(shard []Entry) float64 {
+		return computeErrorSum(shard, weights, indexes, k)
+	})
+
+	errSum := float64(0)
+	for _, partial := range partials {
+		errSum += partial
+	}
 	return errSum / float64(len(entries))
 }
 
+// Find the scaling factor which minimizes the error of the given weights on the entries,
+// using a golden-section search, since the error only has a single minimum in the range
+// of scaling factors searched.
+func fitScalingFactor(entries []Entry, weights []float64, indexes Indexes, numWorkers int) (k float64) {
+	invPhi := (math.Sqrt(5) - 1) / 2
+	low, high := MinScalingFactor, MaxScalingFactor
+
+	mid1 := high - invPhi*(high-low)
+	mid2 := low + invPhi*(high-low)
+	err1 := computeMSEParallel(entries, weights, indexes, mid1, numWorkers)
+	err2 := computeMSEParallel(entries, weights, indexes, mid2, numWorkers)
+
+	for high-low > ScalingFactorTolerance {
+		if err1 < err2 {
+			high, mid2, err2 = mid2, mid1, err1
+			mid1 = high - invPhi*(high-low)
+			err1 = computeMSEParallel(entries, weights, indexes, mid1, numWorkers)
+		} else {
+			low, mid1, err1 = mid1, mid2, err2
+			mid2 = low + invPhi*(high-low)
+			err2 = computeMSEParallel(entries, weights, indexes, mid2, numWorkers)
+		}
+	}
+
+	return (low + high) / 2
+}
+
 func convertFloatSiceToInt(slice []float64) (ints []int16) {
 	for _, float := range slice {
 		ints = append(ints, int16(float))
@@ -719,143 +844,411 @@ func convertFloatSiceToInt(slice []float64) (ints []int16) {
 	return ints
 }
 
-func printSlice(name string, slice []int16) {
-	fmt.Print(name + ": {")
+func printSlice(out io.Writer, name string, slice []int16) {
+	fmt.Fprint(out, name+": {")
 	for _, integer := range slice {
-		fmt.Printf("%d, ", integer)
+		fmt.Fprintf(out, "%d, ", integer)
 	}
-	fmt.Print("}\n")
+	fmt.Fprint(out, "}\n")
 }
 
-func prettyPrintPSQT(name string, psqt []int16) {
-	fmt.Print("{\n")
-	fmt.Print("    // ", name, "\n    ")
+func prettyPrintPSQT(out io.Writer, name string, psqt []int16) {
+	fmt.Fprint(out, "{\n")
+	fmt.Fprint(out, "    // ", name, "\n    ")
 	for sq := 0; sq < 64; sq++ {
 		if sq > 0 && sq%8 == 0 {
-			fmt.Print("\n    ")
+			fmt.Fprint(out, "\n    ")
 		}
-		fmt.Print(psqt[sq], ", ")
+		fmt.Fprint(out, psqt[sq], ", ")
 	}
-	fmt.Print("\n},\n")
+	fmt.Fprint(out, "\n},\n")
 }
 
-func printParameters(weights []float64, indexes Indexes) {
-	printSlice("\nMG Piece Values", convertFloatSiceToInt(weights[indexes.MG_Material_StartIndex:indexes.MG_Material_StartIndex+5]))
-	printSlice("EG Piece Values", convertFloatSiceToInt(weights[indexes.EG_Material_StartIndex:indexes.EG_Material_StartIndex+5]))
+func printParameters(out io.Writer, weights []float64, indexes Indexes, k float64) {
+	fmt.Fprintln(out, "Scaling Factor K:", k)
 
-	printSlice("\nMG Piece Mobility Coefficents", convertFloatSiceToInt(weights[indexes.MG_MobilityStartIndex:indexes.MG_MobilityStartIndex+4]))
-	printSlice("EG Piece Mobility Coefficents", convertFloatSiceToInt(weights[indexes.EG_MobilityStartIndex:indexes.EG_MobilityStartIndex+4]))
+	printSlice(out, "\nMG Piece Values", convertFloatSiceToInt(weights[indexes.MG_Material_StartIndex:indexes.MG_Material_StartIndex+5]))
+	printSlice(out, "EG Piece Values", convertFloatSiceToInt(weights[indexes.EG_Material_StartIndex:indexes.EG_Material_StartIndex+5]))
 
-	fmt.Println("\nBishop Pair Bonus MG:", weights[indexes.MG_BishopPairIndex])
-	fmt.Println("Bishop Pair Bonus EG:", weights[indexes.EG_BishopPairIndex])
+	printSlice(out, "\nMG Piece Mobility Coefficents", convertFloatSiceToInt(weights[indexes.MG_MobilityStartIndex:indexes.MG_MobilityStartIndex+4]))
+	printSlice(out, "EG Piece Mobility Coefficents", convertFloatSiceToInt(weights[indexes.EG_MobilityStartIndex:indexes.EG_MobilityStartIndex+4]))
 
-	fmt.Println("\nIsolated Pawn Penalty MG:", weights[indexes.MG_IsoPawnIndex])
-	fmt.Println("Isolated Pawn Penalty EG:", weights[indexes.EG_IsoPawnIndex])
+	fmt.Fprintln(out, "\nBishop Pair Bonus MG:", weights[indexes.MG_BishopPairIndex])
+	fmt.Fprintln(out, "Bishop Pair Bonus EG:", weights[indexes.EG_BishopPairIndex])
 
-	fmt.Println("\nDoubled Pawn Penalty MG:", weights[indexes.MG_DoubledPawnIndex])
-	fmt.Println("Doubled Pawn Penalty EG:", weights[indexes.EG_DoubledPawnIndex])
+	fmt.Fprintln(out, "\nIsolated Pawn Penalty MG:", weights[indexes.MG_IsoPawnIndex])
+	fmt.Fprintln(out, "Isolated Pawn Penalty EG:", weights[indexes.EG_IsoPawnIndex])
 
-	fmt.Println("\nRook Or Queen On Seventh Bonus EG:", weights[indexes.EG_RookOrQueenOnSeventhIndex])
+	fmt.Fprintln(out, "\nDoubled Pawn Penalty MG:", weights[indexes.MG_DoubledPawnIndex])
+	fmt.Fprintln(out, "Doubled Pawn Penalty EG:", weights[indexes.EG_DoubledPawnIndex])
 
-	fmt.Println("\nKnight On Outpost Bonus MG:", weights[indexes.MG_KnightOutpostIndex])
-	fmt.Println("Knight On Outpost Bonus EG:", weights[indexes.EG_KnightOutpostIndex])
+	fmt.Fprintln(out, "\nRook Or Queen On Seventh Bonus EG:", weights[indexes.EG_RookOrQueenOnSeventhIndex])
 
-	fmt.Println("\nRook On Open File Bonus MG:", weights[indexes.MG_RookOnOpenFileIndex])
-	fmt.Println("Tempo Bonus MG:", weights[indexes.TempoBonusIndex])
+	fmt.Fprintln(out, "\nKnight On Outpost Bonus MG:", weights[indexes.MG_KnightOutpostIndex])
+	fmt.Fprintln(out, "Knight On Outpost Bonus EG:", weights[indexes.EG_KnightOutpostIndex])
 
-	fmt.Println("\nBishop On Outpost Bonus MG:", weights[indexes.MG_BishopOutpostIndex])
-	fmt.Println("Bishop On Outpost Bonus EG:", weights[indexes.EG_BishopOutpostIndex])
+	fmt.Fprintln(out, "\nRook On Open File Bonus MG:", weights[indexes.MG_RookOnOpenFileIndex])
+	fmt.Fprintln(out, "Tempo Bonus MG:", weights[indexes.TempoBonusIndex])
 
-	printSlice("\nOuter Ring Attack Coefficents", convertFloatSiceToInt(weights[indexes.KingSafteyStartIndex:indexes.KingSafteyStartIndex+4]))
-	printSlice("Inner Ring Attack Coefficents", convertFloatSiceToInt(weights[indexes.KingSafteyStartIndex+4:indexes.KingSafteyStartIndex+8]))
-	fmt.Println("Semi-Open File Next To King Penalty:", weights[indexes.KingSafteyStartIndex+8])
+	fmt.Fprintln(out, "\nBishop On Outpost Bonus MG:", weights[indexes.MG_BishopOutpostIndex])
+	fmt.Fprintln(out, "Bishop On Outpost Bonus EG:", weights[indexes.EG_BishopOutpostIndex])
+
+	printSlice(out, "\nOuter Ring Attack Coefficents", convertFloatSiceToInt(weights[indexes.KingSafteyStartIndex:indexes.KingSafteyStartIndex+4]))
+	printSlice(out, "Inner Ring Attack Coefficents", convertFloatSiceToInt(weights[indexes.KingSafteyStartIndex+4:indexes.KingSafteyStartIndex+8]))
+	fmt.Fprintln(out, "Semi-Open File Next To King Penalty:", weights[indexes.KingSafteyStartIndex+8])
 
 	pieceNames := []string{"Pawn", "Knight", "Bishop", "Rook", "Queen", "King"}
 
 	for piece, index := uint8(0), uint16(0); piece <= engine.King; piece, index = piece+1, index+64 {
 		tableName := fmt.Sprintf("MG %s PST", pieceNames[piece])
-		prettyPrintPSQT(tableName, convertFloatSiceToInt(weights[index:index+64]))
+		prettyPrintPSQT(out, tableName, convertFloatSiceToInt(weights[index:index+64]))
 	}
 
 	for piece, index := uint8(0), uint16(0); piece <= engine.King; piece, index = piece+1, index+64 {
 		tableName := fmt.Sprintf("MG %s PST", pieceNames[piece])
-		prettyPrintPSQT(tableName, convertFloatSiceToInt(weights[indexes.EG_PSQT_StartIndex+index:indexes.EG_PSQT_StartIndex+index+64]))
+		prettyPrintPSQT(out, tableName, convertFloatSiceToInt(weights[indexes.EG_PSQT_StartIndex+index:indexes.EG_PSQT_StartIndex+index+64]))
 	}
 
-	prettyPrintPSQT("MG Pawn PST", convertFloatSiceToInt(weights[0:64]))
-	prettyPrintPSQT("MG Knight PST", convertFloatSiceToInt(weights[64:128]))
-	prettyPrintPSQT("MG Bishop PST", convertFloatSiceToInt(weights[128:192]))
-	prettyPrintPSQT("MG Rook PST", convertFloatSiceToInt(weights[192:256]))
-	prettyPrintPSQT("MG Queen PST", convertFloatSiceToInt(weights[256:320]))
-	prettyPrintPSQT("MG King PST", convertFloatSiceToInt(weights[320:384]))
+	prettyPrintPSQT(out, "MG Pawn PST", convertFloatSiceToInt(weights[0:64]))
+	prettyPrintPSQT(out, "MG Knight PST", convertFloatSiceToInt(weights[64:128]))
+	prettyPrintPSQT(out, "MG Bishop PST", convertFloatSiceToInt(weights[128:192]))
+	prettyPrintPSQT(out, "MG Rook PST", convertFloatSiceToInt(weights[192:256]))
+	prettyPrintPSQT(out, "MG Queen PST", convertFloatSiceToInt(weights[256:320]))
+	prettyPrintPSQT(out, "MG King PST", convertFloatSiceToInt(weights[320:384]))
 
-	prettyPrintPSQT("EG Pawn PST", convertFloatSiceToInt(weights[384:448]))
-	prettyPrintPSQT("EG Knight PST", convertFloatSiceToInt(weights[448:512]))
-	prettyPrintPSQT("EG Bishop PST", convertFloatSiceToInt(weights[512:576]))
-	prettyPrintPSQT("EG Rook PST", convertFloatSiceToInt(weights[576:640]))
-	prettyPrintPSQT("EG Queen PST", convertFloatSiceToInt(weights[640:704]))
-	prettyPrintPSQT("EG King PST", convertFloatSiceToInt(weights[704:768]))
+	prettyPrintPSQT(out, "EG Pawn PST", convertFloatSiceToInt(weights[384:448]))
+	prettyPrintPSQT(out, "EG Knight PST", convertFloatSiceToInt(weights[448:512]))
+	prettyPrintPSQT(out, "EG Bishop PST", convertFloatSiceToInt(weights[512:576]))
+	prettyPrintPSQT(out, "EG Rook PST", convertFloatSiceToInt(weights[576:640]))
+	prettyPrintPSQT(out, "EG Queen PST", convertFloatSiceToInt(weights[640:704]))
+	prettyPrintPSQT(out, "EG King PST", convertFloatSiceToInt(weights[704:768]))
 
-	prettyPrintPSQT("MG Passed Pawn PST", convertFloatSiceToInt(weights[indexes.MG_PassedPawn_PSQT_StartIndex:indexes.MG_PassedPawn_PSQT_StartIndex+64]))
-	prettyPrintPSQT("EG Passed Pawn PST", convertFloatSiceToInt(weights[indexes.EG_PassedPawn_PSQT_StartIndex:indexes.EG_PassedPawn_PSQT_StartIndex+64]))
+	prettyPrintPSQT(out, "MG Passed Pawn PST", convertFloatSiceToInt(weights[indexes.MG_PassedPawn_PSQT_StartIndex:indexes.MG_PassedPawn_PSQT_StartIndex+64]))
+	prettyPrintPSQT(out, "EG Passed Pawn PST", convertFloatSiceToInt(weights[indexes.EG_PassedPawn_PSQT_StartIndex:indexes.EG_PassedPawn_PSQT_StartIndex+64]))
 
-	fmt.Println()
+	fmt.Fprintln(out)
 }
 
-func Tune(infile string, epochs, numPositions int, recordErrorRate bool, useDefaultWeights bool) {
+// Get the values of the parameters of the evaluation given by the weights, named as in
+// engine.EvalParams. The values which aren't tuned, like the mobility of pawns, are kept
+// as they are in the engine, and the tuned values are clamped to the bounds of their
+// parameter, so the engine can always load them.
+func evalParamValues(weights []float64, indexes Indexes) map[string][]int16 {
+	values := engine.EvalParamValues()
+	params := map[string]engine.EvalParam{}
+	for _, param := range engine.EvalParams {
+		params[param.Name] = param
+	}
+
+	set := func(name string, start int, tuned []float64) {
+		for i, value := range convertFloatSiceToInt(tuned) {
+			if value < params[name].Min {
+				value = params[name].Min
+			} else if value > params[name].Max {
+				value = params[name].Max
+			}
+			values[name][start+i] = value
+		}
+	}
+
+	set("PSQT_MG", 0, weights[0:indexes.EG_PSQT_StartIndex])
+	set("PSQT_EG", 0, weights[indexes.EG_PSQT_StartIndex:768])
+
+	set("PieceValueMG", 0, weights[indexes.MG_Material_StartIndex:indexes.MG_Material_StartIndex+5])
+	set("PieceValueEG", 0, weights[indexes.EG_Material_StartIndex:indexes.EG_Material_StartIndex+5])
+
+	set("BishopPairBonusMG", 0, weights[indexes.MG_BishopPairIndex:indexes.MG_BishopPairIndex+1])
+	set("BishopPairBonusEG", 0, weights[indexes.EG_BishopPairIndex:indexes.EG_BishopPairIndex+1])
+
+	set("PieceMobilityMG", 1, weights[indexes.MG_MobilityStartIndex:indexes.MG_MobilityStartIndex+4])
+	set("PieceMobilityEG", 1, weights[indexes.EG_MobilityStartIndex:indexes.EG_MobilityStartIndex+4])
+
+	set("PassedPawnPSQT_MG", 0, weights[indexes.MG_PassedPawn_PSQT_StartIndex:indexes.MG_PassedPawn_PSQT_StartIndex+64])
+	set("PassedPawnPSQT_EG", 0, weights[indexes.EG_PassedPawn_PSQT_StartIndex:indexes.EG_PassedPawn_PSQT_StartIndex+64])
+
+	set("DoubledPawnPenatlyMG", 0, weights[indexes.MG_DoubledPawnIndex:indexes.MG_DoubledPawnIndex+1])
+	set("DoubledPawnPenatlyEG", 0, weights[indexes.EG_DoubledPawnIndex:indexes.EG_DoubledPawnIndex+1])
+	set("IsolatedPawnPenatlyMG", 0, weights[indexes.MG_IsoPawnIndex:indexes.MG_IsoPawnIndex+1])
+	set("IsolatedPawnPenatlyEG", 0, weights[indexes.EG_IsoPawnIndex:indexes.EG_IsoPawnIndex+1])
+
+	set("RookOrQueenOnSeventhBonusEG", 0, weights[indexes.EG_RookOrQueenOnSeventhIndex:indexes.EG_RookOrQueenOnSeventhIndex+1])
+	set("KnightOnOutpostBonusMG", 0, weights[indexes.MG_KnightOutpostIndex:indexes.MG_KnightOutpostIndex+1])
+	set("KnightOnOutpostBonusEG", 0, weights[indexes.EG_KnightOutpostIndex:indexes.EG_KnightOutpostIndex+1])
+	set("BishopOutPostBonusMG", 0, weights[indexes.MG_BishopOutpostIndex:indexes.MG_BishopOutpostIndex+1])
+	set("BishopOutPostBonusEG", 0, weights[indexes.EG_BishopOutpostIndex:indexes.EG_BishopOutpostIndex+1])
+	set("RookOnOpenFileBonusMG", 0, weights[indexes.MG_RookOnOpenFileIndex:indexes.MG_RookOnOpenFileIndex+1])
+	set("TempoBonusMG", 0, weights[indexes.TempoBonusIndex:indexes.TempoBonusIndex+1])
+
+	set("OuterRingAttackPoints", 1, weights[indexes.KingSafteyStartIndex:indexes.KingSafteyStartIndex+4])
+	set("InnerRingAttackPoints", 1, weights[indexes.KingSafteyStartIndex+4:indexes.KingSafteyStartIndex+8])
+	set("SemiOpenFileNextToKingPenalty", 0, weights[indexes.KingSafteyStartIndex+8:NumWeights])
+	return values
+}
+
+// Tune the evaluation weights with the given settings, and write the tuned
+// weights to the output path.
+func Tune(config TunerConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
 	var weights []float64
 	var indexes Indexes
 
-	if useDefaultWeights {
+	if config.DefaultWeights {
 		weights, indexes = loadDefaultWeights()
 	} else {
 		weights, indexes = loadWeights()
 	}
 
-	entries := loadEntries(infile, numPositions, indexes)
+	frozen, err := frozenWeights(config.FrozenGroups, indexes)
+	if err != nil {
+		return err
+	}
+
+	optimizer, err := NewOptimizer(&config, NumWeights)
+	if err != nil {
+		return err
+	}
+
+	entries, err := loadEntries(config.DataFiles, config.NumPositions, indexes)
+	if err != nil {
+		return err
+	}
+
+	validation, entries, err := loadValidationEntries(&config, entries, indexes)
+	if err != nil {
+		return err
+	}
+
+	numWorkers := config.Workers
+	if numWorkers == 0 {
+		numWorkers = runtime.NumCPU()
+	}
 
-	gradientsSumsSquared := make([]float64, len(weights))
-	beforeErr := computeMSE(entries, weights, indexes)
+	k := config.ScalingFactor
+	checkpoint := Checkpoint{Optimizer: config.Optimizer}
 
-	N := float64(numPositions)
-	learningRate := LearningRate
+	if config.Resume {
+		if checkpoint, err = LoadCheckpoint(config.CheckpointPath); err != nil {
+			return err
+		}
 
-	errors := []float64{beforeErr}
-	errorRecordingRate := epochs / 100
+		if checkpoint.Optimizer != config.Optimizer {
+			return fmt.Errorf("checkpoint uses the optimizer %s, not %s", checkpoint.Optimizer, config.Optimizer)
+		}
 
-	for epoch := 0; epoch < epochs; epoch++ {
-		gradients := computeGradient(entries, weights, indexes)
-		for k, gradient := range gradients {
-			leadingCoefficent := (-2 * ScalingFactor) / N
-			gradientsSumsSquared[k] += (leadingCoefficent * gradient) * (leadingCoefficent * gradient)
-			weights[k] += (leadingCoefficent * gradient) * (-learningRate / math.Sqrt(gradientsSumsSquared[k]+Epsilon))
+		if err := optimizer.SetState(checkpoint.OptimizerState); err != nil {
+			return err
 		}
 
-		fmt.Printf("Epoch number %d completed\n", epoch+1)
+		// Keep tuning with the scaling factor the session started with.
+		if checkpoint.ScalingFactor > 0 {
+			k = checkpoint.ScalingFactor
+		}
+		fmt.Printf("Resuming from epoch %d with scaling factor K: %v\n", checkpoint.Epoch, k)
+	} else if config.FitScalingFactor {
+		k = fitScalingFactor(entries, weights, indexes, numWorkers)
+		fmt.Println("Fitted scaling factor K:", k)
+	}
+
+	beforeErr := computeMSEParallel(entries, weights, indexes, k, numWorkers)
+	if config.Resume {
+		copy(weights, checkpoint.Weights)
 
-		if recordErrorRate && epoch > 0 && epoch%errorRecordingRate == 0 {
-			errors = append(errors, computeMSE(entries, weights, indexes))
+		// A checkpoint saved without validation positions has no best weights yet.
+		if len(validation) > 0 && len(checkpoint.BestWeights) != NumWeights {
+			checkpoint.BestWeights = append([]float64{}, weights...)
+			checkpoint.BestEpoch = checkpoint.Epoch
+			checkpoint.BestValidationError = computeMSEParallel(validation, weights, indexes, k, numWorkers)
+		}
+	} else {
+		checkpoint.ScalingFactor = k
+		checkpoint.Errors = []float64{beforeErr}
+
+		// The starting weights are the best weights until the validation error improves.
+		if len(validation) > 0 {
+			checkpoint.BestWeights = append([]float64{}, weights...)
+			checkpoint.BestValidationError = computeMSEParallel(validation, weights, indexes, k, numWorkers)
+			fmt.Println("Validation error before tuning:", checkpoint.BestValidationError)
+		}
+
+		checkpoint.Seed = config.Seed
+		if checkpoint.Seed == 0 {
+			checkpoint.Seed = time.Now().UnixNano()
 		}
 	}
 
-	if recordErrorRate {
-		errors = append(errors, computeMSE(entries, weights, indexes))
-		file, err := os.Create("errors.txt")
+	batchSize := config.BatchSize
+	if batchSize == 0 || batchSize > len(entries) {
+		batchSize = len(entries)
+	}
+
+	errorRecordingRate := config.Epochs / 100
+	if errorRecordingRate == 0 {
+		errorRecordingRate = 1
+	}
+
+	order := make([]Entry, len(entries))
+	copy(order, entries)
+
+	for epoch := checkpoint.Epoch; epoch < config.Epochs && !checkpoint.Stopped; epoch++ {
+		rate, err := learningRate(&config, epoch)
 		if err != nil {
-			fmt.Println("Couldn't create \"errors.txt\" to store recored error rates")
-		} else {
-			fmt.Println("Storing error rates in errors.txt")
+			return err
+		}
+
+		// Shuffle the positions with a generator seeded from the epoch, so the order of
+		// the positions in an epoch doesn't depend on whether tuning was resumed.
+		if config.Shuffle && batchSize < len(entries) {
+			random := rand.New(rand.NewSource(checkpoint.Seed + int64(epoch)))
+			for i, j := range random.Perm(len(entries)) {
+				order[i] = entries[j]
+			}
+		}
+
+		for start := 0; start < len(order); start += batchSize {
+			batch := order[start:engine.Min(start+batchSize, len(order))]
+			gradients := computeGradientParallel(batch, weights, indexes, k, numWorkers)
+
+			// Complete the gradient with the -2k/N coefficent factored out of it.
+			leadingCoefficent := (-2 * k) / float64(len(batch))
+			for i := range gradients {
+				gradients[i] *= leadingCoefficent
+			}
+
+			optimizer.Step(weights, gradients, frozen, rate)
 		}
 
-		for _, err := range errors {
-			_, e := file.WriteString(fmt.Sprintf("%f\n", err))
-			if e != nil {
-				panic(e)
+		fmt.Printf("Epoch number %d completed\n", epoch+1)
+
+		if config.ErrorsPath != "" && ((epoch+1)%errorRecordingRate == 0 || epoch+1 == config.Epochs) {
+			checkpoint.Errors = append(checkpoint.Errors, computeMSEParallel(entries, weights, indexes, k, numWorkers))
+		}
+
+		if len(validation) > 0 && ((epoch+1)%config.ValidationInterval == 0 || epoch+1 == config.Epochs) {
+			if err := checkValidationError(&config, &checkpoint, validation, weights, indexes, k, numWorkers, epoch+1); err != nil {
+				return err
+			}
+		}
+
+		if config.CheckpointPath != "" && ((config.CheckpointInterval > 0 && (epoch+1)%config.CheckpointInterval == 0) ||
+			epoch+1 == config.Epochs || checkpoint.Stopped) {
+			checkpoint.Epoch = epoch + 1
+			checkpoint.Weights = weights
+			checkpoint.OptimizerState = optimizer.State()
+
+			if err := SaveCheckpoint(config.CheckpointPath, &checkpoint); err != nil {
+				return fmt.Errorf("failed to save checkpoint: %w", err)
 			}
 		}
 	}
 
-	printParameters(weights, indexes)
+	if config.ErrorsPath != "" {
+		if err := writeErrors(config.ErrorsPath, checkpoint.Errors); err != nil {
+			return err
+		}
+		fmt.Println("Storing error rates in", config.ErrorsPath)
+	}
+
+	if len(validation) > 0 {
+		copy(weights, checkpoint.BestWeights)
+		fmt.Printf(
+			"Using the weights from epoch %d, with a validation error of %v\n",
+			checkpoint.BestEpoch, checkpoint.BestValidationError,
+		)
+	}
+
+	if err := writeParameters(config.OutputPath, weights, indexes, k); err != nil {
+		return err
+	}
+
 	fmt.Println("Best error before tuning:", beforeErr)
-	fmt.Println("Best error after tuning:", computeMSE(entries, weights, indexes))
+	fmt.Println("Best error after tuning:", computeMSEParallel(entries, weights, indexes, k, numWorkers))
+	return nil
+}
+
+// Get the validation positions, either by loading them from the validation files, or
+// by holding out the validation split from the end of the training positions. The
+// training positions left are returned along with them.
+func loadValidationEntries(config *TunerConfig, entries []Entry, indexes Indexes) (validation, training []Entry, err error) {
+	if len(config.ValidationFiles) > 0 {
+		validation, err = loadEntries(config.ValidationFiles, 0, indexes)
+		return validation, entries, err
+	}
+
+	numValidation := int(float64(len(entries)) * config.ValidationSplit)
+	if numValidation == 0 {
+		return nil, entries, nil
+	}
+
+	if numValidation == len(entries) {
+		return nil, nil, errors.New("the validation split leaves no positions to train on")
+	}
+
+	fmt.Printf("Holding out %d positions for validation...\n", numValidation)
+	return entries[len(entries)-numValidation:], entries[:len(entries)-numValidation], nil
+}
+
+// Compute the validation error of the weights after the given epoch, keeping them as the
+// best weights if they have the lowest error so far, and stopping tuning early if the
+// error hasn't improved in the number of checks given by the patience.
+func checkValidationError(config *TunerConfig, checkpoint *Checkpoint, validation []Entry, weights []float64,
+	indexes Indexes, k float64, numWorkers, epoch int) error {
+	validationErr := computeMSEParallel(validation, weights, indexes, k, numWorkers)
+	fmt.Printf("Validation error after epoch %d: %v\n", epoch, validationErr)
+
+	if validationErr < checkpoint.BestValidationError {
+		checkpoint.BestEpoch = epoch
+		checkpoint.BestValidationError = validationErr
+		copy(checkpoint.BestWeights, weights)
+
+		// Save the best weights right away, so they're kept even if tuning is stopped.
+		if config.OutputPath != "" {
+			return writeParameters(config.OutputPath, weights, indexes, k)
+		}
+	} else if config.Patience > 0 && epoch-checkpoint.BestEpoch >= config.Patience*config.ValidationInterval {
+		fmt.Printf("Stopping early, since the validation error hasn't improved since epoch %d\n", checkpoint.BestEpoch)
+		checkpoint.Stopped = true
+	}
+	return nil
+}
+
+// Write the error rates recorded during tuning to the given file, one on each line.
+func writeErrors(path string, errors []float64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, err := range errors {
+		if _, e := fmt.Fprintf(file, "%f\n", err); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Write the tuned weights to the given file, as a JSON file of evaluation parameters
+// the engine can load with the EvalParamsFile option, or print them to stdout if the
+// path is empty.
+func writeParameters(path string, weights []float64, indexes Indexes, k float64) error {
+	if path == "" {
+		printParameters(os.Stdout, weights, indexes, k)
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := engine.WriteEvalParams(file, evalParamValues(weights, indexes), k); err != nil {
+		file.Close()
+		return err
+	}
+
+	fmt.Println("Tuned weights written to", path)
+	return file.Close()
 }
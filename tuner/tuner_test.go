@@ -2,6 +2,10 @@ package tuner
 
 import (
 	"blunder/engine"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -10,6 +14,7 @@ func init() {
 	engine.InitTables()
 	engine.InitZobrist()
 	engine.InitEvalBitboards()
+	engine.InitSearchTables()
 }
 
 var TestFENs = []string{
@@ -255,3 +260,220 @@ func abs_float64(n float64) float64 {
 	}
 	return n
 }
+
+// Write the test positions to a file of training positions, with results made up
+// from the evaluation of each position, and a few upsets.
+func writeTestTrainingFile(t *testing.T) string {
+	data := ""
+	for i, fen := range TestFENs {
+		pos := engine.Position{}
+		pos.LoadFEN(fen)
+
+		eval := engine.EvaluatePos(&pos)
+		if pos.SideToMove == engine.Black {
+			eval = -eval
+		}
+
+		result := "[0.5]"
+		if i%5 != 0 && eval > 50 {
+			result = "[1.0]"
+		} else if i%5 != 0 && eval < -50 {
+			result = "[0.0]"
+		}
+		data += fen + " " + result + "\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "positions.txt")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidationEarlyStopping(t *testing.T) {
+	dataFile := writeTestTrainingFile(t)
+	weights, indexes := loadWeights()
+	entries, err := loadEntries([]string{dataFile}, 0, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultTunerConfig
+	config.ValidationSplit = 0.25
+	validation, training, err := loadValidationEntries(&config, entries, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(validation) != len(entries)/4 || len(validation)+len(training) != len(entries) {
+		t.Errorf("Expected a quarter of %d positions to be held out, got %d", len(entries), len(validation))
+	}
+
+	// Validating with the opposite results of the training positions means the
+	// validation error gets worse as soon as tuning starts, so tuning should
+	// stop early and keep the starting weights.
+	dir := t.TempDir()
+	flipped := ""
+	data, _ := os.ReadFile(dataFile)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		flipped += strings.NewReplacer("[1.0]", "[0.0]", "[0.0]", "[1.0]").Replace(line) + "\n"
+	}
+	validationFile := filepath.Join(dir, "validation.txt")
+	os.WriteFile(validationFile, []byte(flipped), 0644)
+
+	config.DataFiles = []string{dataFile}
+	config.ValidationSplit = 0
+	config.ValidationFiles = []string{validationFile}
+	config.ValidationInterval = 2
+	config.Patience = 2
+	config.Epochs = 50
+	config.OutputPath = filepath.Join(dir, "weights.json")
+	config.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+
+	if err := Tune(config); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := LoadCheckpoint(config.CheckpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkpoint.Stopped || checkpoint.Epoch != 4 || checkpoint.BestEpoch != 0 {
+		t.Errorf(
+			"Expected tuning to stop at epoch 4 with the best weights from epoch 0, got epoch %d, stopped %v, best epoch %d",
+			checkpoint.Epoch, checkpoint.Stopped, checkpoint.BestEpoch,
+		)
+	}
+
+	for i := range weights {
+		if checkpoint.BestWeights[i] != weights[i] {
+			t.Fatalf("Expected best weight %d to be the starting weight %f, got %f", i, weights[i], checkpoint.BestWeights[i])
+		}
+	}
+}
+
+func TestTuneCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultTunerConfig
+	config.DataFiles = []string{writeTestTrainingFile(t)}
+	config.Epochs = 6
+	config.BatchSize = 16
+	config.Seed = 1
+	config.FrozenGroups = []string{"material"}
+	config.OutputPath = filepath.Join(dir, "weights.json")
+	config.CheckpointPath = filepath.Join(dir, "full.json")
+
+	if err := Tune(config); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := LoadCheckpoint(config.CheckpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weights, indexes := loadWeights()
+	for i := indexes.MG_Material_StartIndex; i < indexes.MG_Material_StartIndex+10; i++ {
+		if full.Weights[i] != weights[i] {
+			t.Errorf("Expected frozen weight %d to stay %f, got %f", i, weights[i], full.Weights[i])
+		}
+	}
+
+	// The tuned weights should be written as parameters the engine can load. The
+	// engine's parameters are restored after, since tuning starts from them.
+	original := engine.EvalParamValues()
+	if err := engine.LoadEvalParams(config.OutputPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if engine.TempoBonusMG != int16(full.Weights[indexes.TempoBonusIndex]) || engine.PSQT_EG[engine.Knight][10] != int16(full.Weights[448+10]) {
+		t.Errorf(
+			"Expected the engine to load the tuned weights %f and %f, got %d and %d",
+			full.Weights[indexes.TempoBonusIndex], full.Weights[448+10], engine.TempoBonusMG, engine.PSQT_EG[engine.Knight][10],
+		)
+	}
+	engine.SetEvalParamValues(original)
+
+	// Tuning for half the epochs and resuming from the checkpoint
+	// should end with the same weights as tuning all at once.
+	config.CheckpointPath = filepath.Join(dir, "resumed.json")
+	config.Epochs = 3
+	if err := Tune(config); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Epochs = 6
+	config.Resume = true
+	if err := Tune(config); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := LoadCheckpoint(config.CheckpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.Epoch != full.Epoch {
+		t.Errorf("Expected the resumed checkpoint to be at epoch %d, got %d", full.Epoch, resumed.Epoch)
+	}
+
+	for i := range full.Weights {
+		if full.Weights[i] != resumed.Weights[i] {
+			t.Fatalf("Expected weight %d to be %f after resuming, got %f", i, full.Weights[i], resumed.Weights[i])
+		}
+	}
+}
+
+func TestFitScalingFactor(t *testing.T) {
+	weights, indexes := loadWeights()
+	entries, err := loadEntries([]string{writeTestTrainingFile(t)}, 0, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := fitScalingFactor(entries, weights, indexes, 4)
+	if k <= MinScalingFactor || k >= MaxScalingFactor {
+		t.Fatalf("Expected the fitted scaling factor to be inside the range searched, got %f", k)
+	}
+
+	mse := computeMSE(entries, weights, indexes, k)
+	for _, delta := range []float64{-0.001, 0.001} {
+		if other := computeMSE(entries, weights, indexes, k+delta); other < mse {
+			t.Errorf("Expected K = %f to have the lowest error %f, but K = %f has %f", k, mse, k+delta, other)
+		}
+	}
+}
+
+func TestParallelGradient(t *testing.T) {
+	weights, indexes := loadWeights()
+	entries, err := loadEntries([]string{writeTestTrainingFile(t)}, 0, indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := computeGradient(entries, weights, indexes, ScalingFactor)
+	serialMSE := computeMSE(entries, weights, indexes, ScalingFactor)
+
+	for _, numWorkers := range []int{1, 3, 8, len(entries) + 1} {
+		parallel := computeGradientParallel(entries, weights, indexes, ScalingFactor, numWorkers)
+		for i := range serial {
+			if math.Abs(serial[i]-parallel[i]) > 1e-9*math.Max(1, math.Abs(serial[i])) {
+				t.Fatalf("With %d workers, expected gradient %d to be %g, got %g", numWorkers, i, serial[i], parallel[i])
+			}
+		}
+
+		parallelMSE := computeMSEParallel(entries, weights, indexes, ScalingFactor, numWorkers)
+		if math.Abs(serialMSE-parallelMSE) > 1e-12 {
+			t.Errorf("With %d workers, expected an error of %g, got %g", numWorkers, serialMSE, parallelMSE)
+		}
+
+		// The partial gradients are always reduced in the same order.
+		repeated := computeGradientParallel(entries, weights, indexes, ScalingFactor, numWorkers)
+		for i := range parallel {
+			if parallel[i] != repeated[i] {
+				t.Fatalf("With %d workers, expected gradient %d to be the same each time", numWorkers, i)
+			}
+		}
+	}
+}